@@ -0,0 +1,82 @@
+package sessions
+
+import (
+	"github.com/go-floki/floki"
+	"net/http"
+	"time"
+)
+
+// Keys under which the metadata passed to Config.Anomaly is kept.
+const (
+	lastIPKey      = "_ip"
+	lastCountryKey = "_country"
+)
+
+// SessionMeta is the metadata of the previous request made with a session,
+// passed to Config.Anomaly.
+type SessionMeta struct {
+	// LastIP is the IP address of the client, see LimitByIP.
+	LastIP string
+
+	// LastCountry is the country of the client given by Config.Country,
+	// or empty.
+	LastCountry string
+
+	// LastSeen is the time of the request, or the zero time.
+	LastSeen time.Time
+}
+
+// Decision is the outcome of the Config.Anomaly check of a request.
+type Decision int
+
+const (
+	// Allow lets the request through.
+	Allow Decision = iota
+
+	// Reauthenticate forgets the user of the session, as if they logged
+	// out, forcing them to authenticate again; the rest of the session is
+	// kept.
+	Reauthenticate
+
+	// Reject invalidates the session and aborts the request with 403
+	// Forbidden.
+	Reject
+)
+
+// sessionMeta returns the metadata stored in s.
+func sessionMeta(s *Session) SessionMeta {
+	var meta SessionMeta
+	meta.LastIP, _ = s.Get(lastIPKey).(string)
+	meta.LastCountry, _ = s.Get(lastCountryKey).(string)
+	meta.LastSeen, _ = unixTime(s.Get(lastSeenKey))
+	return meta
+}
+
+// checkAnomaly passes meta, the metadata of s read before the timeouts
+// recorded the current request, to the Anomaly hook of config and applies
+// its decision, then records the metadata of the request. Rejected
+// sessions are not recorded, see reject.
+func checkAnomaly(c *floki.Context, s *Session, meta SessionMeta, config *Config) {
+	var country string
+	if config.Country != nil {
+		country = config.Country(c)
+	}
+	if !s.IsNew {
+		switch config.Anomaly(c, s, meta) {
+		case Reauthenticate:
+			audit(c, s, AuditBindingViolation, "anomaly, re-authentication required")
+			s.SetAuthenticated("")
+		case Reject:
+			audit(c, s, AuditBindingViolation, "anomaly, session rejected")
+			reject(c, s, http.StatusForbidden)
+			return
+		}
+	}
+	if ip := LimitByIP(c); s.Get(lastIPKey) != ip {
+		s.Set(lastIPKey, ip)
+	}
+	if country != "" && s.Get(lastCountryKey) != country {
+		s.Set(lastCountryKey, country)
+	}
+	touchLastSeen(s, time.Now())
+}
@@ -0,0 +1,109 @@
+package sessions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/go-floki/floki"
+	"sync"
+	"time"
+)
+
+// AuditEventType identifies the kind of an AuditEvent.
+type AuditEventType string
+
+// Types of the events emitted to audit sinks.
+const (
+	AuditCreated          AuditEventType = "session.created"
+	AuditRegenerated      AuditEventType = "session.regenerated"
+	AuditRevoked          AuditEventType = "session.revoked"
+	AuditExpired          AuditEventType = "session.expired"
+	AuditBindingViolation AuditEventType = "session.binding_violation"
+	AuditDecodeFailure    AuditEventType = "session.decode_failure"
+	AuditEvicted          AuditEventType = "session.evicted"
+)
+
+// AuditEvent is a security relevant event in the life of a session.
+type AuditEvent struct {
+	Type AuditEventType
+	Time time.Time
+
+	// Session is the name of the session.
+	Session string
+
+	// SessionHash is a hash of the session ID, or of the revocation ID of
+	// sessions without one: IDs are credentials and must not be logged.
+	// It is empty for sessions that have neither yet.
+	SessionHash string
+
+	// UserID is the user recorded with Session.SetAuthenticated, if any.
+	UserID string
+
+	// RemoteAddr is the network address of the client.
+	RemoteAddr string
+
+	// Detail describes the cause of the event, such as which timeout
+	// expired the session.
+	Detail string
+}
+
+// AuditSink receives audit events, for instance to forward them to a SIEM.
+// Audit is called synchronously in the request path, so slow sinks should
+// buffer events.
+type AuditSink interface {
+	Audit(e AuditEvent)
+}
+
+// AuditSinkFunc adapts a function to the AuditSink interface.
+type AuditSinkFunc func(e AuditEvent)
+
+// Audit calls f.
+func (f AuditSinkFunc) Audit(e AuditEvent) {
+	f(e)
+}
+
+var (
+	auditMu    sync.RWMutex
+	auditSinks []AuditSink
+)
+
+// RegisterAuditSink adds sink to the sinks receiving every audit event.
+func RegisterAuditSink(sink AuditSink) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	auditSinks = append(auditSinks, sink)
+}
+
+// auditHash returns the hash of a session ID put in audit events.
+func auditHash(id string) string {
+	if id == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:16])
+}
+
+// audit emits an event of type typ for s, which may be nil, to the
+// registered sinks.
+func audit(c *floki.Context, s *Session, typ AuditEventType, detail string) {
+	auditMu.RLock()
+	sinks := auditSinks
+	auditMu.RUnlock()
+	if len(sinks) == 0 {
+		return
+	}
+	e := AuditEvent{Type: typ, Time: time.Now(), Detail: detail}
+	if s != nil {
+		e.Session = s.name
+		e.SessionHash = auditHash(s.RevocationID())
+		if s.raw == nil {
+			// Don't decode lazily loaded sessions just to audit them.
+			e.UserID = sessionUserID(s)
+		}
+	}
+	if c != nil && c.Request != nil {
+		e.RemoteAddr = c.Request.RemoteAddr
+	}
+	for _, sink := range sinks {
+		sink.Audit(e)
+	}
+}
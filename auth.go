@@ -0,0 +1,60 @@
+package sessions
+
+import (
+	"github.com/go-floki/floki"
+)
+
+// claimsKey is the session key of the claims recorded by SetUser.
+const claimsKey = "_claims"
+
+func init() {
+	RegisterType(map[string]interface{}{})
+}
+
+// SetUser logs userID in, like SetAuthenticated, recording claims about
+// them such as their name or roles; the session ID is regenerated at the
+// end of the request. The claims must be encodable by the codec of the
+// store; with the default one, the types of their values must be registered
+// with RegisterType.
+func (s *Session) SetUser(userID string, claims map[string]interface{}) {
+	s.SetAuthenticated(userID)
+	if userID != "" && claims != nil {
+		s.Set(claimsKey, claims)
+	}
+}
+
+// UserID returns the user logged in with SetUser or SetAuthenticated, or
+// an empty string for anonymous sessions.
+func (s *Session) UserID() string {
+	s.Load()
+	return sessionUserID(s)
+}
+
+// IsAuthenticated reports whether a user is logged in with the session.
+func (s *Session) IsAuthenticated() bool {
+	return s.UserID() != ""
+}
+
+// Claims returns the claims recorded by SetUser, or nil.
+func (s *Session) Claims() map[string]interface{} {
+	claims, _ := s.Get(claimsKey).(map[string]interface{})
+	return claims
+}
+
+// Claim returns the claim called name recorded by SetUser, or nil.
+func (s *Session) Claim(name string) interface{} {
+	return s.Claims()[name]
+}
+
+// Logout ends the session of the user with Destroy, and revokes their
+// remember-me token if the middleware handling the session has
+// Config.RememberMe.
+func (s *Session) Logout(c *floki.Context) error {
+	err := s.Destroy(c)
+	if s.remember != nil {
+		if ferr := s.remember.forget(c); err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
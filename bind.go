@@ -0,0 +1,127 @@
+package sessions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// errNotStruct is returned by Bind and Put for values that are not structs.
+var errNotStruct = errors.New("sessions: Bind and Put need a struct or a pointer to one")
+
+// Put stores the exported fields of the struct v in the session, one value
+// per field. Fields are stored under their name, or under the name given by
+// a "session" tag; fields tagged "-" are skipped, and the "omitempty"
+// option skips zero values:
+//
+//	type Wizard struct {
+//		Step  int    `session:"wizard_step"`
+//		Email string `session:"wizard_email,omitempty"`
+//		Draft []byte `session:"-"`
+//	}
+//
+// Embedded structs are stored as a single value like other fields.
+func (s *Session) Put(v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return errNotStruct
+	}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		key, omitEmpty, ok := sessionField(rt.Field(i))
+		if !ok {
+			continue
+		}
+		fv := rv.Field(i)
+		if omitEmpty && fv.IsZero() {
+			continue
+		}
+		s.Set(key, fv.Interface())
+	}
+	return nil
+}
+
+// Bind sets the exported fields of the struct pointed to by dst from the
+// session, with the names of Put. Fields whose key is not in the session
+// are left alone. Values the codec decoded into another type, such as
+// json.Number, are converted to the type of the field.
+func (s *Session) Bind(dst interface{}) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return errNotStruct
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		key, _, ok := sessionField(rt.Field(i))
+		if !ok || !s.Has(key) {
+			continue
+		}
+		if err := assignValue(rv.Field(i), s.Get(key)); err != nil {
+			return fmt.Errorf("sessions: can't bind %q to %s: %v", key,
+				rt.Field(i).Name, err)
+		}
+	}
+	return nil
+}
+
+// sessionField returns the session key of field f and whether its tag has
+// the omitempty option. It reports false for fields that are not bound.
+func sessionField(f reflect.StructField) (key string, omitEmpty, ok bool) {
+	if f.PkgPath != "" {
+		return "", false, false
+	}
+	tag := f.Tag.Get("session")
+	if tag == "-" {
+		return "", false, false
+	}
+	parts := strings.Split(tag, ",")
+	key = parts[0]
+	if key == "" {
+		key = f.Name
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return key, omitEmpty, true
+}
+
+// assignValue sets dst to v, converting v to the type of dst if needed.
+func assignValue(dst reflect.Value, v interface{}) error {
+	if v == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Type().AssignableTo(dst.Type()) {
+		dst.Set(rv)
+		return nil
+	}
+	if n, ok := int64Value(v); ok {
+		switch dst.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if dst.OverflowInt(n) {
+				return fmt.Errorf("%d overflows %s", n, dst.Type())
+			}
+			dst.SetInt(n)
+			return nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if n < 0 || dst.OverflowUint(uint64(n)) {
+				return fmt.Errorf("%d overflows %s", n, dst.Type())
+			}
+			dst.SetUint(uint64(n))
+			return nil
+		}
+	}
+	// Integers decoded as floats, strings as []byte and the generic maps
+	// of JSONCodec are converted through JSON.
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst.Addr().Interface())
+}
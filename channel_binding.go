@@ -0,0 +1,71 @@
+package sessions
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"github.com/go-floki/floki"
+	"net/http"
+)
+
+// channelBindingKey is the session key of the hash of the TLS channel the
+// session is bound to.
+const channelBindingKey = "_cb"
+
+// ErrNoChannelBinding is returned by a ChannelBinding for requests not made
+// over a TLS connection providing the binding.
+var ErrNoChannelBinding = errors.New("sessions: no TLS channel binding")
+
+// ChannelBinding returns a value identifying the TLS channel of a request,
+// which sessions are bound to. See Config.ChannelBinding.
+type ChannelBinding func(c *floki.Context) ([]byte, error)
+
+// ClientCertificateBinding binds sessions to the client certificate
+// presented in mutual TLS, so a session stolen from a client can't be used
+// by one holding another certificate.
+func ClientCertificateBinding(c *floki.Context) ([]byte, error) {
+	tls := c.Request.TLS
+	if tls == nil || len(tls.PeerCertificates) == 0 {
+		return nil, ErrNoChannelBinding
+	}
+	return tls.PeerCertificates[0].Raw, nil
+}
+
+// TLSExporterBinding returns a ChannelBinding binding sessions to keying
+// material exported from the TLS connection (RFC 5705) with label. Each
+// connection exports a different value, so sessions only work over the
+// connection that created them; it suits clients keeping a long-lived
+// connection, such as internal services.
+func TLSExporterBinding(label string) ChannelBinding {
+	return func(c *floki.Context) ([]byte, error) {
+		tls := c.Request.TLS
+		if tls == nil {
+			return nil, ErrNoChannelBinding
+		}
+		return tls.ExportKeyingMaterial(label, nil, 32)
+	}
+}
+
+// checkChannelBinding compares the channel binding stored in s with the one
+// of the current request, binding s if it is not yet. It reports false if
+// they differ or the request has no binding.
+func checkChannelBinding(c *floki.Context, s *Session, binding ChannelBinding) bool {
+	b, err := binding(c)
+	if err != nil {
+		return false
+	}
+	sum := sha256.Sum256(b)
+	current := hex.EncodeToString(sum[:])
+	stored, ok := s.Get(channelBindingKey).(string)
+	if !ok {
+		s.Set(channelBindingKey, current)
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(current)) == 1
+}
+
+// rejectChannelBinding is the default Config.OnChannelBindingMismatch.
+func rejectChannelBinding(c *floki.Context, s *Session) {
+	reject(c, s, http.StatusForbidden)
+}
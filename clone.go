@@ -0,0 +1,87 @@
+package sessions
+
+import (
+	"reflect"
+)
+
+// Clone returns a deep copy of the session: its values, options and
+// metadata are copied, so the clone can be changed, or handed to another
+// goroutine, without affecting the session. The clone belongs to the same
+// store and, once saved, replaces the record of the session.
+//
+// Maps, slices, arrays, pointers and exported struct fields are copied
+// recursively; channels, functions and unexported struct fields are shared.
+func (s *Session) Clone() *Session {
+	s.Load()
+	c := *s
+	c.Values = deepCopy(s.Values).(map[interface{}]interface{})
+	if s.Options != nil {
+		options := *s.Options
+		c.Options = &options
+	}
+	c.saved = nil
+	c.watched = nil
+	return &c
+}
+
+// deepCopy returns a deep copy of v.
+func deepCopy(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return copyValue(reflect.ValueOf(v)).Interface()
+}
+
+// copyValue returns a deep copy of v.
+func copyValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		m := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, k := range v.MapKeys() {
+			m.SetMapIndex(k, copyValue(v.MapIndex(k)))
+		}
+		return m
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		s := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			s.Index(i).Set(copyValue(v.Index(i)))
+		}
+		return s
+	case reflect.Array:
+		a := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			a.Index(i).Set(copyValue(v.Index(i)))
+		}
+		return a
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		p := reflect.New(v.Type().Elem())
+		p.Elem().Set(copyValue(v.Elem()))
+		return p
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		i := reflect.New(v.Type()).Elem()
+		i.Set(copyValue(v.Elem()))
+		return i
+	case reflect.Struct:
+		st := reflect.New(v.Type()).Elem()
+		st.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if f := st.Field(i); f.CanSet() {
+				f.Set(copyValue(v.Field(i)))
+			}
+		}
+		return st
+	}
+	return v
+}
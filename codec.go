@@ -0,0 +1,249 @@
+package sessions
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/securecookie"
+	ugorji "github.com/ugorji/go/codec"
+	"reflect"
+)
+
+// Codec serializes session values.
+//
+// Stores use the Codec set in their ValueCodec field, or gob when it is nil.
+type Codec interface {
+	// Marshal encodes values.
+	Marshal(values map[interface{}]interface{}) ([]byte, error)
+
+	// Unmarshal decodes data into values, which is never nil.
+	Unmarshal(data []byte, values map[interface{}]interface{}) error
+}
+
+// GobCodec encodes session values with encoding/gob.
+//
+// Custom types stored in a session must be registered with gob.Register.
+type GobCodec struct{}
+
+func (GobCodec) Marshal(values map[interface{}]interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(values); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, values map[interface{}]interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(&values)
+}
+
+// JSONCodec encodes session values as a JSON object, so they can be read
+// with standard tools and by services not written in Go.
+//
+// Keys must be strings. Numbers are decoded as json.Number and nested
+// objects as map[string]interface{}.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(values map[interface{}]interface{}) ([]byte, error) {
+	m := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("sessions: JSONCodec can't encode key %v of type %T", k, k)
+		}
+		m[key] = v
+	}
+	return json.Marshal(m)
+}
+
+func (JSONCodec) Unmarshal(data []byte, values map[interface{}]interface{}) error {
+	var m map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&m); err != nil {
+		return err
+	}
+	for k, v := range m {
+		values[k] = v
+	}
+	return nil
+}
+
+// MsgpackCodec encodes session values with MessagePack, a compact binary
+// format with implementations in most languages, so sessions can be shared
+// with services written in Node, Python and the like.
+//
+// Strings are written with the str type of the current MessagePack spec and
+// nested maps are decoded as map[interface{}]interface{}.
+type MsgpackCodec struct{}
+
+var msgpackHandle = func() *ugorji.MsgpackHandle {
+	h := &ugorji.MsgpackHandle{WriteExt: true}
+	h.RawToString = true
+	h.MapType = reflect.TypeOf(map[interface{}]interface{}(nil))
+	return h
+}()
+
+func (MsgpackCodec) Marshal(values map[interface{}]interface{}) ([]byte, error) {
+	var b []byte
+	err := ugorji.NewEncoderBytes(&b, msgpackHandle).Encode(values)
+	return b, err
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, values map[interface{}]interface{}) error {
+	return ugorji.NewDecoderBytes(data, msgpackHandle).Decode(&values)
+}
+
+// CBORCodec encodes session values with CBOR (RFC 8949).
+//
+// Map keys are written in canonical order, so encoding the same values
+// always yields the same bytes, which matters when payloads are also signed
+// or hashed.
+type CBORCodec struct{}
+
+var cborHandle = func() *ugorji.CborHandle {
+	h := &ugorji.CborHandle{}
+	h.Canonical = true
+	h.MapType = reflect.TypeOf(map[interface{}]interface{}(nil))
+	return h
+}()
+
+func (CBORCodec) Marshal(values map[interface{}]interface{}) ([]byte, error) {
+	var b []byte
+	err := ugorji.NewEncoderBytes(&b, cborHandle).Encode(values)
+	return b, err
+}
+
+func (CBORCodec) Unmarshal(data []byte, values map[interface{}]interface{}) error {
+	return ugorji.NewDecoderBytes(data, cborHandle).Decode(&values)
+}
+
+// Tag following payloadMagic in the header of versioned payloads.
+const versionedTag byte = 'v'
+
+// ErrUnknownVersion is returned when a versioned payload can't be brought up
+// to the current format version.
+var ErrUnknownVersion = errors.New("sessions: unknown session format version")
+
+// VersionedCodec prefixes the payloads of another Codec with a format
+// version, and runs registered upgrade functions on payloads written with
+// an older version before decoding them. This lets applications change
+// their codec or the shape of their session data without invalidating
+// every existing session.
+//
+// Payloads without a version header are treated as version 0.
+type VersionedCodec struct {
+	codec    Codec
+	version  byte
+	upgrades map[byte]func(data []byte) ([]byte, error)
+}
+
+// NewVersionedCodec returns a VersionedCodec writing payloads of codec with
+// the given format version.
+func NewVersionedCodec(codec Codec, version byte) *VersionedCodec {
+	return &VersionedCodec{
+		codec:    codec,
+		version:  version,
+		upgrades: make(map[byte]func(data []byte) ([]byte, error)),
+	}
+}
+
+// Upgrade registers fn to convert a payload from version from to version
+// from+1. A payload is upgraded step by step until it reaches the current
+// version. Upgrade should be called before the codec is in use.
+func (v *VersionedCodec) Upgrade(from byte, fn func(data []byte) ([]byte, error)) {
+	v.upgrades[from] = fn
+}
+
+func (v *VersionedCodec) Marshal(values map[interface{}]interface{}) ([]byte, error) {
+	data, err := v.codec.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{payloadMagic, versionedTag, v.version}, data...), nil
+}
+
+func (v *VersionedCodec) Unmarshal(data []byte, values map[interface{}]interface{}) error {
+	var version byte
+	if len(data) >= 3 && data[0] == payloadMagic && data[1] == versionedTag {
+		version, data = data[2], data[3:]
+	}
+	for ; version < v.version; version++ {
+		fn := v.upgrades[version]
+		if fn == nil {
+			return ErrUnknownVersion
+		}
+		var err error
+		if data, err = fn(data); err != nil {
+			return err
+		}
+	}
+	if version != v.version {
+		return ErrUnknownVersion
+	}
+	return v.codec.Unmarshal(data, values)
+}
+
+// MaxLengthCodec returns a Codec failing with ErrSessionTooLarge when the
+// payload of codec is longer than n bytes.
+func MaxLengthCodec(codec Codec, n int) Codec {
+	return &maxLengthCodec{codec: codec, n: n}
+}
+
+// maxLengthCodec is the Codec returned by MaxLengthCodec.
+type maxLengthCodec struct {
+	codec Codec
+	n     int
+}
+
+func (m *maxLengthCodec) Marshal(values map[interface{}]interface{}) ([]byte, error) {
+	data, err := m.codec.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	if m.n != 0 && len(data) > m.n {
+		return nil, ErrSessionTooLarge
+	}
+	return data, nil
+}
+
+func (m *maxLengthCodec) Unmarshal(data []byte, values map[interface{}]interface{}) error {
+	return m.codec.Unmarshal(data, values)
+}
+
+// codecOrGob returns c, or GobCodec if c is nil.
+func codecOrGob(c Codec) Codec {
+	if c == nil {
+		return GobCodec{}
+	}
+	return c
+}
+
+// encodeSecureValues encodes values into a securecookie value. Values are
+// serialized with codec, or directly by securecookie if codec is nil.
+func encodeSecureValues(name string, values map[interface{}]interface{},
+	codec Codec, codecs ...securecookie.Codec) (string, error) {
+	if codec == nil {
+		return securecookie.EncodeMulti(name, values, codecs...)
+	}
+	data, err := codec.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return securecookie.EncodeMulti(name, data, codecs...)
+}
+
+// decodeSecureValues reverses encodeSecureValues.
+func decodeSecureValues(name, value string, values map[interface{}]interface{},
+	codec Codec, codecs ...securecookie.Codec) error {
+	if codec == nil {
+		return decodeError(securecookie.DecodeMulti(name, value, &values, codecs...))
+	}
+	var data []byte
+	if err := securecookie.DecodeMulti(name, value, &data, codecs...); err != nil {
+		return decodeError(err)
+	}
+	return malformed(codec.Unmarshal(data, values))
+}
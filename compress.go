@@ -0,0 +1,222 @@
+package sessions
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"io/ioutil"
+	"sync"
+)
+
+// DefaultCompressThreshold is the payload size, in bytes, from which
+// EnableCompression starts compressing.
+const DefaultCompressThreshold = 1024
+
+// Tag following payloadMagic in the header of compressed payloads.
+const compressedTag byte = 'z'
+
+// Compressor compresses and decompresses session payloads.
+type Compressor interface {
+	// ID identifies the algorithm in the header of compressed payloads.
+	ID() byte
+	Compress(b []byte) ([]byte, error)
+	Decompress(b []byte) ([]byte, error)
+}
+
+// Built-in compressors.
+var (
+	Gzip   Compressor = gzipCompressor{}
+	Snappy Compressor = snappyCompressor{}
+	Zstd   Compressor = &zstdCompressor{}
+)
+
+// compressors maps header IDs to the built-in compressors, so payloads
+// written with a previously configured algorithm can still be read.
+var compressors = map[byte]Compressor{
+	Gzip.ID():   Gzip,
+	Snappy.ID(): Snappy,
+	Zstd.ID():   Zstd,
+}
+
+// ErrNoPayloadStore is returned by EnableCompression and EnableEncryption for
+// stores that don't implement PayloadStore.
+var ErrNoPayloadStore = errors.New("sessions: the store does not implement PayloadStore")
+
+// EnableCompression makes store compress payloads of at least
+// DefaultCompressThreshold bytes with codec before they are persisted.
+// The store itself is changed, for every middleware and handler using it.
+//
+// Compressed payloads carry a header identifying the algorithm; payloads
+// without it, such as sessions written before compression was enabled, are
+// read back unchanged. Calling it again replaces the compression of store
+// rather than compressing twice.
+//
+// It returns ErrNoPayloadStore if store does not implement PayloadStore.
+func EnableCompression(store Store, codec Compressor) error {
+	return EnableCompressionThreshold(store, codec, DefaultCompressThreshold)
+}
+
+// EnableCompressionThreshold is like EnableCompression but compresses
+// payloads of at least threshold bytes.
+func EnableCompressionThreshold(store Store, codec Compressor, threshold int) error {
+	ps, ok := store.(PayloadStore)
+	if !ok {
+		return ErrNoPayloadStore
+	}
+	ps.AddTransform(&compressTransform{codec: codec, threshold: threshold})
+	return nil
+}
+
+// CompressedCodec returns a Codec compressing the payloads of codec with c
+// when they are at least threshold bytes long.
+//
+// Compression happens on the encoded values, before the payload is signed
+// or encrypted, which keeps large cookie-stored sessions under the browser
+// size limits. Payloads written without compression are decoded unchanged.
+func CompressedCodec(codec Codec, c Compressor, threshold int) Codec {
+	return &compressedCodec{codec: codec, c: c, threshold: threshold}
+}
+
+// compressedCodec is the Codec returned by CompressedCodec.
+type compressedCodec struct {
+	codec     Codec
+	c         Compressor
+	threshold int
+}
+
+func (cc *compressedCodec) Marshal(values map[interface{}]interface{}) ([]byte, error) {
+	data, err := cc.codec.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	return compress(cc.c, cc.threshold, data)
+}
+
+func (cc *compressedCodec) Unmarshal(data []byte, values map[interface{}]interface{}) error {
+	data, err := decompress(cc.c, data)
+	if err != nil {
+		return err
+	}
+	return cc.codec.Unmarshal(data, values)
+}
+
+// compressTransform is the PayloadTransform installed by EnableCompression.
+type compressTransform struct {
+	codec     Compressor
+	threshold int
+}
+
+func (*compressTransform) kind() byte { return compressedTag }
+
+func (t *compressTransform) Encode(b []byte) ([]byte, error) {
+	return compress(t.codec, t.threshold, b)
+}
+
+func (t *compressTransform) Decode(b []byte) ([]byte, error) {
+	return decompress(t.codec, b)
+}
+
+// compress compresses b with codec and prepends the compressed payload
+// header, unless b is shorter than threshold.
+func compress(codec Compressor, threshold int, b []byte) ([]byte, error) {
+	if len(b) < threshold {
+		return b, nil
+	}
+	out, err := codec.Compress(b)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{payloadMagic, compressedTag, codec.ID()}, out...), nil
+}
+
+// decompress reverses compress. Payloads without a compressed header are
+// returned unchanged.
+func decompress(codec Compressor, b []byte) ([]byte, error) {
+	if len(b) < 3 || b[0] != payloadMagic || b[1] != compressedTag {
+		return b, nil
+	}
+	c := codec
+	if b[2] != codec.ID() {
+		if c = compressors[b[2]]; c == nil {
+			return nil, errors.New("sessions: unknown compression algorithm")
+		}
+	}
+	return c.Decompress(b[3:])
+}
+
+// gzipCompressor compresses payloads with compress/gzip.
+type gzipCompressor struct{}
+
+func (gzipCompressor) ID() byte { return 'g' }
+
+func (gzipCompressor) Compress(b []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// snappyCompressor compresses payloads with snappy, trading compression
+// ratio for speed.
+type snappyCompressor struct{}
+
+func (snappyCompressor) ID() byte { return 's' }
+
+func (snappyCompressor) Compress(b []byte) ([]byte, error) {
+	return snappy.Encode(nil, b), nil
+}
+
+func (snappyCompressor) Decompress(b []byte) ([]byte, error) {
+	return snappy.Decode(nil, b)
+}
+
+// zstdCompressor compresses payloads with zstd, sharing one encoder and
+// decoder between all sessions.
+type zstdCompressor struct {
+	once sync.Once
+	enc  *zstd.Encoder
+	dec  *zstd.Decoder
+	err  error
+}
+
+func (*zstdCompressor) ID() byte { return 'Z' }
+
+func (z *zstdCompressor) init() error {
+	z.once.Do(func() {
+		if z.enc, z.err = zstd.NewWriter(nil); z.err != nil {
+			return
+		}
+		z.dec, z.err = zstd.NewReader(nil)
+	})
+	return z.err
+}
+
+func (z *zstdCompressor) Compress(b []byte) ([]byte, error) {
+	if err := z.init(); err != nil {
+		return nil, err
+	}
+	return z.enc.EncodeAll(b, nil), nil
+}
+
+func (z *zstdCompressor) Decompress(b []byte) ([]byte, error) {
+	if err := z.init(); err != nil {
+		return nil, err
+	}
+	return z.dec.DecodeAll(b, nil)
+}
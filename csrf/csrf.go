@@ -0,0 +1,245 @@
+// Package csrf protects floki applications against cross-site request
+// forgery with tokens tied to the session managed by the sessions package.
+//
+// Install it after the sessions middleware:
+//
+//	f.Use(sessions.Sessions("my_session", store, nil))
+//	f.Use(csrf.Protect(csrf.Config{}))
+//
+// and send the token back with every unsafe request, either in a form field
+// rendered with csrf.TemplateField or in the X-CSRF-Token header.
+package csrf
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"github.com/go-floki/floki"
+	"github.com/go-floki/sessions"
+	"github.com/gorilla/securecookie"
+	"html/template"
+	"net/http"
+)
+
+const (
+	// HeaderName is the default request header carrying the token.
+	HeaderName = "X-CSRF-Token"
+
+	// FieldName is the default form field carrying the token, and the
+	// context key under which Protect exposes it to templates.
+	FieldName = "csrf_token"
+)
+
+// Session keys of the per-session token secret, and of the digest of the
+// session ID the secret is bound to.
+const (
+	secretKey = "_csrf"
+	boundKey  = "_csrf_id"
+)
+
+func init() {
+	sessions.RegisterSensitive(secretKey)
+}
+
+// fieldKey is the context key under which Protect records Config.Field.
+const fieldKey = "_csrf_field"
+
+// secretLength is the length, in bytes, of the token secret.
+const secretLength = 32
+
+// Config configures the middleware returned by Protect.
+type Config struct {
+	// Header is the request header carrying the token. Defaults to
+	// HeaderName.
+	Header string
+
+	// Field is the form field carrying the token. Defaults to FieldName.
+	Field string
+
+	// ErrorHandler is called when an unsafe request carries no valid
+	// token. Defaults to aborting the request with 403 Forbidden.
+	ErrorHandler floki.HandlerFunc
+}
+
+// Protect returns a middleware rejecting POST, PUT, PATCH, DELETE and other
+// unsafe requests that don't carry a valid token for the session, and
+// exposing the token to templates under FieldName. The token secret is only
+// created when a token is rendered, so requests that render none leave new
+// sessions untouched. Unsafe requests on routes without the sessions
+// middleware are rejected.
+func Protect(config Config) floki.HandlerFunc {
+	if config.Header == "" {
+		config.Header = HeaderName
+	}
+	if config.Field == "" {
+		config.Field = FieldName
+	}
+	if config.ErrorHandler == nil {
+		config.ErrorHandler = func(c *floki.Context) {
+			c.Abort(http.StatusForbidden)
+		}
+	}
+
+	return func(c *floki.Context) {
+		c.Set(fieldKey, config.Field)
+		c.Set(FieldName, lazyToken{c})
+
+		switch c.Request.Method {
+		case "GET", "HEAD", "OPTIONS", "TRACE":
+		default:
+			token := c.Request.Header.Get(config.Header)
+			if token == "" {
+				token = c.Request.FormValue(config.Field)
+			}
+			if s, ok := sessions.TryGet(c); !ok || !Valid(s, token) {
+				config.ErrorHandler(c)
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// Token returns a token for the session of the current request, creating
+// the session's token secret if needed. Every call returns a different
+// masked token for the same secret, which protects it against
+// compression-based attacks such as BREACH.
+//
+// The secret is bound to the session ID, so tokens stop being valid when
+// the ID is regenerated, such as on login. Read-only sessions can't store a
+// new secret: Token returns an empty string for the ones that have none
+// yet, as it does for routes without the sessions middleware.
+func Token(c *floki.Context) string {
+	s, ok := sessions.TryGet(c)
+	if !ok {
+		return ""
+	}
+	b := secret(s)
+	if b == nil {
+		return ""
+	}
+	return mask(b)
+}
+
+// lazyToken is the value Protect exposes to templates: it renders a token,
+// creating the secret, only when a template prints it.
+type lazyToken struct {
+	c *floki.Context
+}
+
+func (t lazyToken) String() string {
+	return Token(t.c)
+}
+
+// field returns the form field carrying the token, as configured in
+// Protect.
+func field(c *floki.Context) string {
+	if v, err := c.Get(fieldKey); err == nil {
+		if f, ok := v.(string); ok {
+			return f
+		}
+	}
+	return FieldName
+}
+
+// TemplateField returns a hidden form input carrying the token, named
+// after Config.Field.
+func TemplateField(c *floki.Context) template.HTML {
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+		template.HTMLEscapeString(field(c)), Token(c)))
+}
+
+// JSON returns the token in a map ready to be encoded in a JSON response,
+// under Config.Field.
+func JSON(c *floki.Context) map[string]string {
+	return map[string]string{field(c): Token(c)}
+}
+
+// Valid reports whether token is a valid token for the session s and its
+// current ID. The comparison runs in constant time.
+func Valid(s *sessions.Session, token string) bool {
+	stored := bound(s)
+	if stored == nil {
+		return false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) != 2*secretLength {
+		return false
+	}
+	return subtle.ConstantTimeCompare(unmask(b), stored) == 1
+}
+
+// binding returns the digest of the ID of s that its secret is bound to, or
+// nil if s has no ID, as with cookie stores.
+func binding(s *sessions.Session) []byte {
+	if s.ID == "" {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(s.ID))
+	return sum[:]
+}
+
+// bound returns the token secret of s if it is bound to the current ID of
+// s, or nil. A secret created before s had an ID, by the request creating
+// the session, is bound to the ID it was saved under on first use; one
+// bound to a previous ID is ignored.
+func bound(s *sessions.Session) []byte {
+	b, ok := s.Get(secretKey).([]byte)
+	if !ok || len(b) != secretLength {
+		return nil
+	}
+	id := binding(s)
+	stored, _ := s.Get(boundKey).([]byte)
+	switch {
+	case bytes.Equal(stored, id):
+		return b
+	case stored == nil && !s.IsReadOnly():
+		s.Set(boundKey, id)
+		return b
+	}
+	return nil
+}
+
+// secret returns the token secret of s, creating it if needed, or nil if s
+// has none and is read-only.
+func secret(s *sessions.Session) []byte {
+	if b := bound(s); b != nil {
+		return b
+	}
+	if s.IsReadOnly() {
+		return nil
+	}
+	b := securecookie.GenerateRandomKey(secretLength)
+	s.Set(secretKey, b)
+	if id := binding(s); id != nil {
+		s.Set(boundKey, id)
+	} else {
+		s.Delete(boundKey)
+	}
+	return b
+}
+
+// mask XORs secret with a random one-time pad, returning the pad followed
+// by the result.
+func mask(secret []byte) string {
+	pad := securecookie.GenerateRandomKey(len(secret))
+	b := make([]byte, 2*len(secret))
+	copy(b, pad)
+	for i := range secret {
+		b[len(secret)+i] = secret[i] ^ pad[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// unmask reverses mask.
+func unmask(b []byte) []byte {
+	n := len(b) / 2
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = b[i] ^ b[n+i]
+	}
+	return out
+}
@@ -0,0 +1,237 @@
+package csrf
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-floki/floki"
+	"github.com/go-floki/sessions"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_Protect(t *testing.T) {
+	f := floki.Default()
+
+	store := sessions.NewCookieStore([]byte("secret123"))
+	f.Use(sessions.Sessions("my_session", store, nil))
+	f.Use(Protect(Config{}))
+
+	var token string
+	f.GET("/form", func(c *floki.Context) {
+		token = Token(c)
+		if b, _ := json.Marshal(sessions.Get(c)); !strings.Contains(string(b), `"_csrf":"[redacted]"`) {
+			t.Error("Token secret was not redacted:", string(b))
+		}
+		c.Send(200, "OK")
+	})
+	f.POST("/submit", func(c *floki.Context) {
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/form", nil)
+	f.ServeHTTP(res, req)
+	cookie := res.Header().Get("Set-Cookie")
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("POST", "/submit", nil)
+	req2.Header.Set("Cookie", cookie)
+	f.ServeHTTP(res2, req2)
+	if res2.Code != http.StatusForbidden {
+		t.Error("Request without a token was not rejected:", res2.Code)
+	}
+
+	res3 := httptest.NewRecorder()
+	req3, _ := http.NewRequest("POST", "/submit", nil)
+	req3.Header.Set("Cookie", cookie)
+	req3.Header.Set(HeaderName, token)
+	f.ServeHTTP(res3, req3)
+	if res3.Code != http.StatusOK {
+		t.Error("Request with a valid token was rejected:", res3.Code)
+	}
+}
+
+func Test_TokenReadOnly(t *testing.T) {
+	f := floki.Default()
+
+	store := sessions.NewCookieStore([]byte("secret123"))
+	f.Use(sessions.SessionsWithConfig("my_session", store, sessions.Config{
+		ReadOnly: []string{"/page"},
+	}))
+	f.Use(Protect(Config{}))
+
+	var token string
+	f.GET("/form", func(c *floki.Context) {
+		token = Token(c)
+		c.Send(200, "OK")
+	})
+	f.GET("/page", func(c *floki.Context) {
+		token = Token(c)
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/page", nil)
+	f.ServeHTTP(res, req)
+	if token != "" {
+		t.Error("Token was issued without a stored secret")
+	}
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/form", nil)
+	f.ServeHTTP(res2, req2)
+
+	res3 := httptest.NewRecorder()
+	req3, _ := http.NewRequest("GET", "/page", nil)
+	req3.Header.Set("Cookie", res2.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res3, req3)
+	if token == "" {
+		t.Error("Token was not issued for the stored secret")
+	}
+}
+
+func Test_ProtectLazy(t *testing.T) {
+	f := floki.Default()
+
+	store := sessions.NewCookieStore([]byte("secret123"))
+	f.Use(sessions.SessionsWithConfig("my_session", store, sessions.Config{LazyCreation: true}))
+	f.Use(Protect(Config{}))
+
+	var token string
+	f.GET("/page", func(c *floki.Context) {
+		c.Send(200, "OK")
+	})
+	f.GET("/form", func(c *floki.Context) {
+		v, _ := c.Get(FieldName)
+		token = fmt.Sprint(v)
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/page", nil)
+	f.ServeHTTP(res, req)
+	if res.Header().Get("Set-Cookie") != "" {
+		t.Error("Session was created by a request rendering no token")
+	}
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/form", nil)
+	f.ServeHTTP(res2, req2)
+	if token == "" || res2.Header().Get("Set-Cookie") == "" {
+		t.Error("Rendering the token did not create its secret")
+	}
+}
+
+func Test_ProtectField(t *testing.T) {
+	f := floki.Default()
+
+	store := sessions.NewCookieStore([]byte("secret123"))
+	f.Use(sessions.Sessions("my_session", store, nil))
+	f.Use(Protect(Config{Field: "authenticity_token"}))
+
+	var field template.HTML
+	var token string
+	f.GET("/form", func(c *floki.Context) {
+		field = TemplateField(c)
+		token = JSON(c)["authenticity_token"]
+		c.Send(200, "OK")
+	})
+	f.POST("/submit", func(c *floki.Context) {
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/form", nil)
+	f.ServeHTTP(res, req)
+	if !strings.Contains(string(field), `name="authenticity_token"`) {
+		t.Error("TemplateField ignored Config.Field:", field)
+	}
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("POST", "/submit", strings.NewReader(url.Values{"authenticity_token": {token}}.Encode()))
+	req2.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+	if res2.Code != http.StatusOK {
+		t.Error("Request with a valid token in Config.Field was rejected:", res2.Code)
+	}
+}
+
+func Test_TokenBinding(t *testing.T) {
+	f := floki.Default()
+
+	store := sessions.NewMemoryStore([]byte("secret123"))
+	f.Use(sessions.Sessions("my_session", store, nil))
+	f.Use(Protect(Config{}))
+
+	var token string
+	f.GET("/form", func(c *floki.Context) {
+		token = Token(c)
+		c.Send(200, "OK")
+	})
+	f.POST("/submit", func(c *floki.Context) {
+		c.Send(200, "OK")
+	})
+	f.POST("/login", func(c *floki.Context) {
+		sessions.Get(c).SetAuthenticated("alice")
+		c.Send(200, "OK")
+	})
+
+	post := func(path, cookie string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, nil)
+		req.Header.Set("Cookie", cookie)
+		req.Header.Set(HeaderName, token)
+		f.ServeHTTP(res, req)
+		return res
+	}
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/form", nil)
+	f.ServeHTTP(res, req)
+	cookie := res.Header().Get("Set-Cookie")
+
+	if res2 := post("/submit", cookie); res2.Code != http.StatusOK {
+		t.Error("Request with a valid token was rejected:", res2.Code)
+	}
+
+	res3 := post("/login", cookie)
+	if res3.Code != http.StatusOK || res3.Header().Get("Set-Cookie") == "" {
+		t.Fatal("Login was rejected:", res3.Code)
+	}
+	if res4 := post("/submit", res3.Header().Get("Set-Cookie")); res4.Code != http.StatusForbidden {
+		t.Error("Token survived the regeneration of the session ID:", res4.Code)
+	}
+}
+
+func Test_ProtectWithoutSessions(t *testing.T) {
+	f := floki.Default()
+	f.Use(Protect(Config{}))
+
+	var token string
+	f.GET("/form", func(c *floki.Context) {
+		token = Token(c)
+		c.Send(200, "OK")
+	})
+	f.POST("/submit", func(c *floki.Context) {
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/form", nil)
+	f.ServeHTTP(res, req)
+	if res.Code != http.StatusOK || token != "" {
+		t.Error("Token was rendered without a session:", res.Code, token)
+	}
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("POST", "/submit", nil)
+	f.ServeHTTP(res2, req2)
+	if res2.Code != http.StatusForbidden {
+		t.Error("Unsafe request without a session was not rejected:", res2.Code)
+	}
+}
@@ -0,0 +1,82 @@
+package sessions
+
+import (
+	"errors"
+	"github.com/go-floki/floki"
+	"net/http"
+	"strings"
+)
+
+// DecodeError is returned by stores when a session cookie or record can't
+// be decoded. Kind is one of ErrMACInvalid, ErrExpired or ErrMalformed and
+// is matched by errors.Is; Err is the underlying error.
+type DecodeError struct {
+	Kind error
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the kind of e.
+func (e *DecodeError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// isDecodeError reports whether err is a decode error of a store.
+func isDecodeError(err error) bool {
+	return errors.Is(err, ErrMACInvalid) || errors.Is(err, ErrExpired) ||
+		errors.Is(err, ErrMalformed)
+}
+
+// decodeError classifies err, returned by a securecookie.Codec, as one of
+// the decode errors. Codecs of this package return them directly; others,
+// such as the ones of securecookie, are recognized by their message.
+func decodeError(err error) error {
+	if err == nil || isDecodeError(err) {
+		return err
+	}
+	msg := err.Error()
+	kind := ErrMalformed
+	switch {
+	case strings.Contains(msg, "expired"):
+		kind = ErrExpired
+	case strings.Contains(msg, "not valid"):
+		kind = ErrMACInvalid
+	}
+	return &DecodeError{Kind: kind, Err: err}
+}
+
+// malformed wraps err, returned while parsing a payload, as ErrMalformed.
+func malformed(err error) error {
+	if err == nil || isDecodeError(err) {
+		return err
+	}
+	return &DecodeError{Kind: ErrMalformed, Err: err}
+}
+
+// IssueFreshSession is the default Config.OnDecodeError: the unreadable
+// session is silently replaced by a new one.
+func IssueFreshSession(c *floki.Context, s *Session, err error) {
+	s.invalidate()
+}
+
+// LogDecodeError is a Config.OnDecodeError logging err before replacing
+// the unreadable session by a new one.
+func LogDecodeError(c *floki.Context, s *Session, err error) {
+	c.Logger().Println("error decoding session:", err)
+	s.invalidate()
+}
+
+// RejectInvalidSession is a Config.OnDecodeError aborting requests with an
+// unreadable session with 400 Bad Request. The cookie is expired and no new
+// session is saved.
+func RejectInvalidSession(c *floki.Context, s *Session, err error) {
+	reject(c, s, http.StatusBadRequest)
+}
@@ -0,0 +1,94 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotDetachable is returned by the DetachedSession of a store that can't
+// load and save sessions by ID, such as a CookieStore, whose values only
+// travel with responses.
+var ErrNotDetachable = errors.New("sessions: store can't load or save sessions outside a request")
+
+// ErrSessionNotFound is returned by DetachedSession.Load when the store has
+// no live record for the ID, because the session expired or was destroyed.
+var ErrSessionNotFound = errors.New("sessions: session not found")
+
+// DetachedSession is a session handle usable once its request is over, such
+// as in goroutines or queued jobs, see Session.Detach. It reads and writes
+// the record of the session directly, so it needs a server-side store like
+// MemoryStore or RediStore.
+//
+// Methods of Session taking a *floki.Context must not be called on it.
+type DetachedSession struct {
+	*Session
+}
+
+// Detach returns a handle on the saved record of the session, holding a
+// copy of its ID, options and values; changes made through one are not seen
+// by the other until they are saved and loaded. Sessions that were never
+// saved have no ID and can't be detached usefully.
+func (s *Session) Detach() *DetachedSession {
+	s.Load()
+	d := NewSession(s.store, s.name)
+	d.ID = s.ID
+	if s.Options != nil {
+		options := *s.Options
+		d.Options = &options
+	}
+	d.stringKeys = s.stringKeys
+	d.secrets = s.secrets
+	for k, v := range s.Values {
+		d.Values[k] = v
+	}
+	return &DetachedSession{d}
+}
+
+// Load replaces the values of the handle with the ones stored for its ID.
+// ctx is checked before the store is accessed.
+func (d *DetachedSession) Load(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	loader, ok := d.store.(sessionLoader)
+	if !ok {
+		return ErrNotDetachable
+	}
+	if d.ID == "" {
+		return ErrSessionNotFound
+	}
+	values := d.Values
+	d.Values = make(map[interface{}]interface{})
+	d.raw = nil
+	d.decodeErr = nil
+	found, err := loader.load(d.Session)
+	if err != nil {
+		d.Values = values
+		return err
+	}
+	if !found {
+		d.Values = values
+		return ErrSessionNotFound
+	}
+	return d.Session.Load()
+}
+
+// Save stores the values of the handle in the record of its ID, keeping its
+// lifetime of Options.MaxAge. ctx is checked before the store is accessed.
+func (d *DetachedSession) Save(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	saver, ok := d.store.(recordSaver)
+	if !ok {
+		return ErrNotDetachable
+	}
+	if d.ID == "" {
+		return ErrSessionNotFound
+	}
+	if err := saver.save(d.Session); err != nil {
+		return err
+	}
+	d.dirty = false
+	return nil
+}
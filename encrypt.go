@@ -0,0 +1,98 @@
+package sessions
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"github.com/gorilla/securecookie"
+)
+
+// Tag following payloadMagic in the header of encrypted payloads.
+const encryptedTag byte = 'e'
+
+// ErrDecrypt is returned when a stored payload can't be decrypted with any
+// of the configured keys.
+var ErrDecrypt = errors.New("sessions: payload could not be decrypted")
+
+// EnableEncryption makes store encrypt payloads with AES-GCM before they are
+// persisted, so the contents of a leaked Redis dump or session directory
+// can't be read. The store itself is changed, for every middleware and
+// handler using it.
+//
+// Keys must be 16, 24, or 32 bytes to select AES-128, AES-192, or AES-256.
+// The first key encrypts new payloads; all of them are tried when
+// decrypting, so a new key can be put in front of the old ones to rotate
+// without losing live sessions.
+//
+// Payloads without an encryption header, such as sessions written before
+// encryption was enabled, are read back unchanged. Calling it again, as
+// Strict does, replaces the keys of store rather than encrypting twice.
+//
+// It returns ErrNoPayloadStore if store does not implement PayloadStore.
+func EnableEncryption(store Store, keys ...[]byte) error {
+	ps, ok := store.(PayloadStore)
+	if !ok {
+		return ErrNoPayloadStore
+	}
+	t, err := newEncryptTransform(keys...)
+	if err != nil {
+		return err
+	}
+	ps.AddTransform(t)
+	return nil
+}
+
+// newEncryptTransform returns an encryptTransform for keys.
+func newEncryptTransform(keys ...[]byte) (*encryptTransform, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("sessions: encryption needs at least one key")
+	}
+	t := &encryptTransform{}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		t.aeads = append(t.aeads, aead)
+	}
+	return t, nil
+}
+
+// encryptTransform is the PayloadTransform installed by EnableEncryption.
+type encryptTransform struct {
+	aeads []cipher.AEAD
+}
+
+func (*encryptTransform) kind() byte { return encryptedTag }
+
+func (t *encryptTransform) Encode(b []byte) ([]byte, error) {
+	aead := t.aeads[0]
+	header := []byte{payloadMagic, encryptedTag}
+	nonce := securecookie.GenerateRandomKey(aead.NonceSize())
+	if nonce == nil {
+		return nil, errors.New("sessions: failed to generate nonce")
+	}
+	out := append(header, nonce...)
+	return aead.Seal(out, nonce, b, header), nil
+}
+
+func (t *encryptTransform) Decode(b []byte) ([]byte, error) {
+	if len(b) < 2 || b[0] != payloadMagic || b[1] != encryptedTag {
+		return b, nil
+	}
+	header, b := b[:2], b[2:]
+	for _, aead := range t.aeads {
+		n := aead.NonceSize()
+		if len(b) < n {
+			continue
+		}
+		if plain, err := aead.Open(nil, b[:n], b[n:], header); err == nil {
+			return plain, nil
+		}
+	}
+	return nil, ErrDecrypt
+}
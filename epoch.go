@@ -0,0 +1,90 @@
+package sessions
+
+import (
+	"github.com/garyburd/redigo/redis"
+	"github.com/go-floki/floki"
+	"sync/atomic"
+)
+
+// epochKey is the session key of the epoch a session was created in.
+const epochKey = "_epoch"
+
+// EpochSource holds the current epoch of sessions. Every session records
+// the epoch it was created in, and sessions of older epochs are replaced
+// by new ones, so bumping the epoch ends every outstanding session at once,
+// wherever it is stored: an emergency switch after a key leak. The
+// middleware consults it on every request when set in Config.Epoch.
+type EpochSource interface {
+	// Epoch returns the current epoch.
+	Epoch() (int64, error)
+
+	// BumpEpoch starts a new epoch and returns it.
+	BumpEpoch() (int64, error)
+}
+
+// checkEpoch invalidates s if it was created before the current epoch of
+// source, and records the epoch in new sessions. Sessions that don't know
+// their epoch belong to epoch 0.
+func checkEpoch(c *floki.Context, s *Session, source EpochSource) error {
+	epoch, err := source.Epoch()
+	if err != nil {
+		return err
+	}
+	stored, _ := int64Value(s.Get(epochKey))
+	if stored == epoch {
+		return nil
+	}
+	if !s.IsNew {
+		audit(c, s, AuditRevoked, "epoch bumped")
+		s.invalidate()
+	}
+	if epoch != 0 {
+		s.Set(epochKey, epoch)
+	}
+	return nil
+}
+
+// MemoryEpoch is an EpochSource kept in process memory.
+type MemoryEpoch struct {
+	epoch int64
+}
+
+// Epoch returns the current epoch.
+func (m *MemoryEpoch) Epoch() (int64, error) {
+	return atomic.LoadInt64(&m.epoch), nil
+}
+
+// BumpEpoch starts a new epoch and returns it.
+func (m *MemoryEpoch) BumpEpoch() (int64, error) {
+	return atomic.AddInt64(&m.epoch, 1), nil
+}
+
+// RedisEpoch is an EpochSource kept in redis, shared by every process
+// using the same server. Every request reads the epoch from redis.
+type RedisEpoch struct {
+	Pool *redis.Pool
+	Key  string // the redis key of the epoch, "sessions_epoch" by default
+}
+
+// NewRedisEpoch returns a RedisEpoch using pool.
+func NewRedisEpoch(pool *redis.Pool) *RedisEpoch {
+	return &RedisEpoch{Pool: pool, Key: "sessions_epoch"}
+}
+
+// Epoch returns the current epoch.
+func (r *RedisEpoch) Epoch() (int64, error) {
+	conn := r.Pool.Get()
+	defer conn.Close()
+	epoch, err := redis.Int64(conn.Do("GET", r.Key))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+	return epoch, err
+}
+
+// BumpEpoch starts a new epoch and returns it.
+func (r *RedisEpoch) BumpEpoch() (int64, error) {
+	conn := r.Pool.Get()
+	defer conn.Close()
+	return redis.Int64(conn.Do("INCR", r.Key))
+}
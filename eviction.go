@@ -0,0 +1,90 @@
+package sessions
+
+import (
+	"github.com/go-floki/floki"
+	"sort"
+)
+
+// EvictionPolicy orders the sessions of a user for eviction when the user
+// has more than Config.MaxSessionsPerUser of them: it reports whether a
+// should be evicted before b.
+type EvictionPolicy func(a, b *Session) bool
+
+// EvictOldest evicts the sessions created first. Sessions record their
+// creation time when a middleware has an AbsoluteTimeout, or on login
+// with a MaxSessionsPerUser.
+func EvictOldest(a, b *Session) bool {
+	ta, _ := unixTime(a.Get(createdAtKey))
+	tb, _ := unixTime(b.Get(createdAtKey))
+	return ta.Before(tb)
+}
+
+// EvictLeastRecentlyUsed evicts the sessions whose last request is the
+// oldest. The time of last activity is only recorded by middlewares with
+// an IdleTimeout; EvictOldest is used otherwise.
+func EvictLeastRecentlyUsed(a, b *Session) bool {
+	ta, oka := unixTime(a.Get(lastSeenKey))
+	tb, okb := unixTime(b.Get(lastSeenKey))
+	if !oka || !okb || ta.Equal(tb) {
+		return EvictOldest(a, b)
+	}
+	return ta.Before(tb)
+}
+
+// EvictLowestAuthLevel evicts the sessions with the lowest AuthLevel
+// first, and the oldest among them.
+func EvictLowestAuthLevel(a, b *Session) bool {
+	if la, lb := a.AuthLevel(), b.AuthLevel(); la != lb {
+		return la < lb
+	}
+	return EvictOldest(a, b)
+}
+
+// sessionLoader is implemented by the stores whose records can be loaded
+// by ID, outside of a request.
+type sessionLoader interface {
+	load(session *Session) (bool, error)
+}
+
+// evictSessions deletes the other sessions of the user of s, in the order
+// of policy, until the user has at most max sessions. The sessions of
+// stores that can't load records by ID are evicted in the order of the
+// user index.
+func evictSessions(c *floki.Context, s *Session, max int, policy EvictionPolicy) error {
+	userID := sessionUserID(s)
+	index, ok := s.store.(UserIndexer)
+	if !ok {
+		return ErrNoUserIndex
+	}
+	ids, err := index.UserSessionIDs(userID)
+	if err != nil || len(ids) <= max {
+		return err
+	}
+	loader, _ := s.store.(sessionLoader)
+	others := make([]*Session, 0, len(ids))
+	for _, id := range ids {
+		if id == s.ID {
+			continue
+		}
+		other := NewSession(s.store, s.name)
+		other.ID = id
+		if loader != nil {
+			if ok, err := loader.load(other); err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+		}
+		others = append(others, other)
+	}
+	sort.SliceStable(others, func(i, j int) bool {
+		return policy(others[i], others[j])
+	})
+	for i := 0; i < len(others)+1-max; i++ {
+		if err := index.DeleteID(others[i].ID); err != nil {
+			return err
+		}
+		audit(c, others[i], AuditEvicted, "too many sessions for the user")
+	}
+	return nil
+}
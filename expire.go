@@ -0,0 +1,63 @@
+package sessions
+
+import (
+	"sync"
+)
+
+// ExpireFunc is called with the ID and values of a session that expired,
+// to release the resources tied to it. Values is nil when the store can't
+// tell them, such as for redis records expired by the server.
+type ExpireFunc func(id string, values map[interface{}]interface{})
+
+// ExpireNotifier is implemented by stores calling functions when sessions
+// expire: when an expired record is found on load, when a janitor removes
+// it, or when the middleware expires a session after its IdleTimeout or
+// AbsoluteTimeout. MemoryStore, FilesystemStore and RediStore implement it;
+// RediStore can't tell expired records from deleted ones, see RediStore.
+type ExpireNotifier interface {
+	OnExpire(fn ExpireFunc)
+}
+
+// expireListeners implements ExpireNotifier for the built-in stores.
+type expireListeners struct {
+	mu  sync.RWMutex
+	fns []ExpireFunc
+}
+
+// OnExpire registers fn to be called when a session of the store expires.
+func (l *expireListeners) OnExpire(fn ExpireFunc) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.fns = append(l.fns, fn)
+}
+
+// listening reports whether functions are registered, so stores can skip
+// decoding expired records otherwise.
+func (l *expireListeners) listening() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.fns) > 0
+}
+
+// expired calls the registered functions for the session id.
+func (l *expireListeners) expired(id string, values map[interface{}]interface{}) {
+	l.mu.RLock()
+	fns := l.fns
+	l.mu.RUnlock()
+	for _, fn := range fns {
+		fn(id, values)
+	}
+}
+
+// expirer is implemented by stores embedding expireListeners.
+type expirer interface {
+	expired(id string, values map[interface{}]interface{})
+}
+
+// expireSession notifies the store of s that s expired.
+func expireSession(s *Session) {
+	if e, ok := s.store.(expirer); ok && s.ID != "" {
+		s.Load()
+		e.expired(s.ID, s.Values)
+	}
+}
@@ -0,0 +1,97 @@
+package sessions
+
+import (
+	"path"
+	"strings"
+)
+
+// pathFilter decides which requests the middleware handles, see Config.Skip
+// and Config.Only.
+type pathFilter struct {
+	skip, only []string
+}
+
+// matchPath reports whether the URL path p matches pattern: patterns
+// ending with a slash match the paths they prefix, such as "/static/";
+// patterns without slashes match the last element of the path, such as
+// "*.css"; other patterns match the whole path with path.Match, such as
+// "/healthz".
+func matchPath(pattern, p string) bool {
+	switch {
+	case strings.HasSuffix(pattern, "/"):
+		return strings.HasPrefix(p, pattern)
+	case !strings.Contains(pattern, "/"):
+		ok, _ := path.Match(pattern, path.Base(p))
+		return ok
+	}
+	ok, _ := path.Match(pattern, p)
+	return ok
+}
+
+// matchAny reports whether p matches one of patterns.
+func matchAny(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		if matchPath(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Route configures the session of the requests whose URL path matches
+// Pattern, with the syntax of Config.Skip, see Config.Routes.
+type Route struct {
+	Pattern string
+
+	// Name is the name of the session cookie. The default is the name of
+	// the middleware.
+	Name string
+
+	// Options are the cookie options of the session. The default is the
+	// options of the middleware.
+	Options *Options
+}
+
+// route is a Route with its defaults resolved.
+type route struct {
+	Route
+	override bool
+}
+
+// newRoutes resolves the defaults of routes with the name and options of
+// the middleware, panicking on invalid options like SessionsWithConfig.
+func newRoutes(routes []Route, name string, options *Options, override bool) []route {
+	resolved := make([]route, len(routes))
+	for i, r := range routes {
+		resolved[i] = route{Route: r, override: override || r.Options != nil}
+		if r.Name == "" {
+			resolved[i].Name = name
+		}
+		if r.Options == nil {
+			resolved[i].Options = options
+		}
+		resolved[i].Options = resolved[i].Options.forPrefix(resolved[i].Name)
+		if err := resolved[i].Options.validate(resolved[i].Name); err != nil {
+			panic(err)
+		}
+	}
+	return resolved
+}
+
+// matchRoute returns the first of routes matching the URL path p, or nil.
+func matchRoute(routes []route, p string) *route {
+	for i := range routes {
+		if matchPath(routes[i].Pattern, p) {
+			return &routes[i]
+		}
+	}
+	return nil
+}
+
+// bypass reports whether requests for the URL path p are left alone.
+func (f pathFilter) bypass(p string) bool {
+	if len(f.only) > 0 && !matchAny(f.only, p) {
+		return true
+	}
+	return matchAny(f.skip, p)
+}
@@ -0,0 +1,51 @@
+package sessions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/go-floki/floki"
+	"strings"
+)
+
+// fingerprintKey is the session key of the stored client fingerprint.
+const fingerprintKey = "_fp"
+
+// DefaultFingerprint lists the request headers commonly used to fingerprint
+// a client.
+var DefaultFingerprint = []string{"User-Agent", "Accept-Language"}
+
+// fingerprint returns the hashes of the given request headers, joined with
+// dots.
+func fingerprint(c *floki.Context, headers []string) string {
+	hashes := make([]string, len(headers))
+	for i, h := range headers {
+		sum := sha256.Sum256([]byte(c.Request.Header.Get(h)))
+		hashes[i] = hex.EncodeToString(sum[:8])
+	}
+	return strings.Join(hashes, ".")
+}
+
+// checkFingerprint compares the fingerprint stored in s with the one of the
+// current request, storing it if s has none yet. It reports false if more
+// than tolerance headers differ.
+func checkFingerprint(c *floki.Context, s *Session, headers []string, tolerance int) bool {
+	current := fingerprint(c, headers)
+	stored, ok := s.Get(fingerprintKey).(string)
+	if !ok {
+		s.Set(fingerprintKey, current)
+		return true
+	}
+	a, b := strings.Split(stored, "."), strings.Split(current, ".")
+	if len(a) != len(b) {
+		// The list of headers changed; start over with the new one.
+		s.Set(fingerprintKey, current)
+		return true
+	}
+	differ := 0
+	for i := range a {
+		if a[i] != b[i] {
+			differ++
+		}
+	}
+	return differ <= tolerance
+}
@@ -0,0 +1,145 @@
+package sessions
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/go-floki/floki"
+	"html/template"
+	"strings"
+)
+
+// Levels of flash messages, as used by the alert classes of CSS frameworks
+// such as Bootstrap. Other levels may be used too.
+const (
+	FlashSuccess = "success"
+	FlashInfo    = "info"
+	FlashWarning = "warning"
+	FlashError   = "error"
+)
+
+// flashLevelKey returns the key of the flashes of level.
+func flashLevelKey(level string) string {
+	return flashesKey + "." + level
+}
+
+// AddFlashLevel adds a flash message of the given level, such as
+// FlashSuccess, to the session.
+func (s *Session) AddFlashLevel(level string, value interface{}) {
+	s.AddFlash(value, flashLevelKey(level))
+}
+
+// FlashesByLevel returns and removes the flash messages added with
+// AddFlashLevel, grouped by level. Levels without messages are absent.
+func (s *Session) FlashesByLevel() map[string][]interface{} {
+	s.Load()
+	prefix := flashLevelKey("")
+	levels := make(map[string][]interface{})
+	for k := range s.Values {
+		if k, ok := k.(string); ok && strings.HasPrefix(k, prefix) {
+			levels[strings.TrimPrefix(k, prefix)] = s.Flashes(k)
+		}
+	}
+	return levels
+}
+
+// flashMessagesKey is the session key of the flashes added with
+// AddFlashMessage.
+const flashMessagesKey = flashesKey + "_messages"
+
+// Flash is a structured flash message.
+type Flash struct {
+	Level  string // such as FlashSuccess
+	Title  string
+	Body   string
+	Fields map[string]interface{} // extra data for the templates
+}
+
+func init() {
+	RegisterType(Flash{})
+}
+
+// AddFlashMessage adds the structured flash message f to the session.
+func (s *Session) AddFlashMessage(f Flash) {
+	s.AddFlash(f, flashMessagesKey)
+}
+
+// FlashMessages returns and removes the structured flash messages of the
+// session. Messages decoded by codecs that don't know Flash, such as
+// JSONCodec, are converted back.
+func (s *Session) FlashMessages() []Flash {
+	var messages []Flash
+	for _, v := range s.Flashes(flashMessagesKey) {
+		switch v := v.(type) {
+		case Flash:
+			messages = append(messages, v)
+		case *Flash:
+			messages = append(messages, *v)
+		default:
+			var f Flash
+			if b, err := json.Marshal(v); err == nil && json.Unmarshal(b, &f) == nil {
+				messages = append(messages, f)
+			}
+		}
+	}
+	return messages
+}
+
+// ExposedFlashes are the flashes of a session set in the context of the
+// request by Config.ExposeFlashes.
+type ExposedFlashes struct {
+	Messages   []interface{}            // added with AddFlash
+	Levels     map[string][]interface{} // added with AddFlashLevel
+	Structured []Flash                  // added with AddFlashMessage
+}
+
+// exposeFlashes pops the flashes of s into the context key name of c.
+func exposeFlashes(c *floki.Context, s *Session, name string) {
+	c.Set(name, ExposedFlashes{
+		Messages:   s.Flashes(),
+		Levels:     s.FlashesByLevel(),
+		Structured: s.FlashMessages(),
+	})
+}
+
+// flashTemplate renders structured flash messages as Bootstrap alerts.
+var flashTemplate = template.Must(template.New("flashes").Parse(
+	`{{range .}}<div class="alert alert-{{if eq .Level "error"}}danger{{else}}{{.Level}}{{end}}" role="alert">` +
+		`{{with .Title}}<strong>{{.}}</strong> {{end}}{{.Body}}</div>{{end}}`))
+
+// FlashFuncs returns the template functions consuming the structured flash
+// messages of a session, given the *floki.Context of the request or the
+// *Session itself:
+//
+//	flashes returns the messages, to be rendered by the template;
+//	renderFlashes renders them as Bootstrap alerts.
+//
+// Add them to the templates of the application:
+//
+//	tmpl := template.New("").Funcs(sessions.FlashFuncs())
+//
+//	{{range flashes .ctx}}<p class="{{.Level}}">{{.Body}}</p>{{end}}
+//	{{renderFlashes .ctx}}
+func FlashFuncs() template.FuncMap {
+	return template.FuncMap{
+		"flashes": templateFlashes,
+		"renderFlashes": func(v interface{}) (template.HTML, error) {
+			var buf bytes.Buffer
+			err := flashTemplate.Execute(&buf, templateFlashes(v))
+			return template.HTML(buf.String()), err
+		},
+	}
+}
+
+// templateFlashes returns the structured flash messages of the session of
+// v, a *floki.Context or a *Session.
+func templateFlashes(v interface{}) []Flash {
+	switch v := v.(type) {
+	case *Session:
+		return v.FlashMessages()
+	case *floki.Context:
+		if s, ok := TryGet(v); ok {
+			return s.FlashMessages()
+		}
+	}
+	return nil
+}
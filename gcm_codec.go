@@ -0,0 +1,127 @@
+package sessions
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"github.com/gorilla/securecookie"
+	"time"
+)
+
+// Version byte of the values written by GCMCodec.
+const gcmVersion byte = 1
+
+// Info string binding keys derived by GCMCodec to their purpose.
+const gcmKeyInfo = "floki sessions aes-gcm cookie key"
+
+// GCMCodec encrypts and authenticates values with AES-256-GCM, so cookie
+// stored sessions can't be read or modified by clients.
+//
+// The AES key is derived from a master secret with HKDF-SHA256, every value
+// is sealed with a fresh random nonce, and the cookie name and timestamp are
+// bound to the ciphertext as additional data, so a value can't be moved to
+// another cookie or have its age changed.
+//
+// GCMCodec implements securecookie.Codec and can be used in the Codecs field
+// of every store.
+type GCMCodec struct {
+	aead       cipher.AEAD
+	maxAge     int64
+	serializer Serializer
+}
+
+// NewGCMCodec returns a GCMCodec with a key derived from secret, which
+// should hold at least 32 random bytes.
+func NewGCMCodec(secret []byte) (*GCMCodec, error) {
+	if len(secret) == 0 {
+		return nil, errHashKeyNotSet
+	}
+	block, err := aes.NewCipher(deriveKey(secret, gcmKeyInfo))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &GCMCodec{
+		aead:       aead,
+		maxAge:     86400 * 30,
+		serializer: GobSerializer{},
+	}, nil
+}
+
+// MaxAge restricts the maximum age, in seconds, of decoded values.
+// Set it to 0 for no restriction. The default is 30 days.
+func (g *GCMCodec) MaxAge(v int) *GCMCodec {
+	g.maxAge = int64(v)
+	return g
+}
+
+// SetSerializer sets the serializer used for values. The default is
+// GobSerializer.
+func (g *GCMCodec) SetSerializer(sz Serializer) *GCMCodec {
+	g.serializer = sz
+	return g
+}
+
+// Encode serializes and seals value for the cookie called name.
+func (g *GCMCodec) Encode(name string, value interface{}) (string, error) {
+	plain, err := g.serializer.Serialize(value)
+	if err != nil {
+		return "", err
+	}
+	header := make([]byte, 9)
+	header[0] = gcmVersion
+	binary.BigEndian.PutUint64(header[1:], uint64(time.Now().UTC().Unix()))
+	nonce := securecookie.GenerateRandomKey(g.aead.NonceSize())
+	if nonce == nil {
+		return "", errors.New("sessions: failed to generate nonce")
+	}
+	b := append(append([]byte{}, header...), nonce...)
+	b = g.aead.Seal(b, nonce, plain, gcmAdditionalData(name, header))
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Decode opens value sealed by Encode for the cookie called name and
+// deserializes it into dst.
+func (g *GCMCodec) Decode(name, value string, dst interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return errMacInvalid
+	}
+	n := g.aead.NonceSize()
+	if len(b) < 9+n || b[0] != gcmVersion {
+		return errMacInvalid
+	}
+	header, nonce, sealed := b[:9], b[9:9+n], b[9+n:]
+	plain, err := g.aead.Open(nil, nonce, sealed, gcmAdditionalData(name, header))
+	if err != nil {
+		return errMacInvalid
+	}
+	t := int64(binary.BigEndian.Uint64(header[1:]))
+	if g.maxAge != 0 && t < time.Now().UTC().Unix()-g.maxAge {
+		return errTimestampOld
+	}
+	return g.serializer.Deserialize(plain, dst)
+}
+
+// gcmAdditionalData returns the data authenticated along with a value.
+func gcmAdditionalData(name string, header []byte) []byte {
+	return append([]byte(name+"|"), header...)
+}
+
+// deriveKey derives a 32-byte key for the given purpose from secret with
+// HKDF-SHA256 (RFC 5869) and an empty salt.
+func deriveKey(secret []byte, info string) []byte {
+	extract := hmac.New(sha256.New, make([]byte, sha256.Size))
+	extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte(info))
+	expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
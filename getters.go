@@ -0,0 +1,80 @@
+package sessions
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// The typed getters below convert values back to the type they were stored
+// with, whatever the codec of the store made of them: JSONCodec decodes
+// numbers as json.Number and times as strings, and other codecs may widen
+// integers or turn them into floats. They report false when the key is not
+// set or holds a value of another kind.
+
+// GetString returns the string stored under key.
+func (s *Session) GetString(key interface{}) (string, bool) {
+	switch v := s.Get(key).(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	}
+	return "", false
+}
+
+// GetInt returns the integer stored under key.
+func (s *Session) GetInt(key interface{}) (int, bool) {
+	n, ok := int64Value(s.Get(key))
+	if !ok || int64(int(n)) != n {
+		return 0, false
+	}
+	return int(n), true
+}
+
+// GetInt64 returns the integer stored under key.
+func (s *Session) GetInt64(key interface{}) (int64, bool) {
+	return int64Value(s.Get(key))
+}
+
+// GetBool returns the boolean stored under key.
+func (s *Session) GetBool(key interface{}) (bool, bool) {
+	b, ok := s.Get(key).(bool)
+	return b, ok
+}
+
+// GetFloat returns the number stored under key as a float64.
+func (s *Session) GetFloat(key interface{}) (float64, bool) {
+	switch v := s.Get(key).(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case nil:
+		return 0, false
+	default:
+		n, ok := int64Value(v)
+		return float64(n), ok
+	}
+}
+
+// GetTime returns the time stored under key, as a time.Time, an RFC 3339
+// string such as JSONCodec writes, or unix seconds.
+func (s *Session) GetTime(key interface{}) (time.Time, bool) {
+	switch v := s.Get(key).(type) {
+	case time.Time:
+		return v, true
+	case *time.Time:
+		if v != nil {
+			return *v, true
+		}
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return t, err == nil
+	default:
+		return unixTime(v)
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,92 @@
+package sessions
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// IDGenerator creates the IDs of new sessions in server-side stores.
+//
+// IDs are used in redis keys and file names, so they should only contain
+// URL and filename safe characters.
+type IDGenerator interface {
+	NewID() (string, error)
+}
+
+// IDGeneratorFunc adapts a function to the IDGenerator interface.
+type IDGeneratorFunc func() (string, error)
+
+// NewID calls f.
+func (f IDGeneratorFunc) NewID() (string, error) {
+	return f()
+}
+
+// DefaultIDGenerator is used by stores whose IDGenerator is nil. It returns
+// 32 random bytes encoded with unpadded base64url.
+var DefaultIDGenerator IDGenerator = RandomIDGenerator(32)
+
+// RandomIDGenerator returns size bytes from crypto/rand encoded with
+// unpadded base64url.
+type RandomIDGenerator int
+
+// NewID returns a new random ID.
+func (size RandomIDGenerator) NewID() (string, error) {
+	b := make([]byte, size)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ULIDGenerator returns ULIDs: a millisecond timestamp followed by 80
+// random bits, in Crockford's base32. ULIDs sort by creation time, which
+// makes stored sessions easier to inspect.
+type ULIDGenerator struct{}
+
+// crockford is the alphabet of Crockford's base32.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewID returns a new ULID.
+func (ULIDGenerator) NewID() (string, error) {
+	var b [16]byte
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	binary.BigEndian.PutUint16(b[0:], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[2:], uint32(ms))
+	if _, err := io.ReadFull(rand.Reader, b[6:]); err != nil {
+		return "", err
+	}
+	// 128 bits make 26 characters, the first one holding only 3 bits.
+	out := make([]byte, 26)
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out), nil
+}
+
+// PrefixedIDGenerator returns a generator prepending prefix to the IDs of
+// gen, or of DefaultIDGenerator if gen is nil, so the sessions of different
+// applications or environments can be told apart in a shared backend.
+func PrefixedIDGenerator(prefix string, gen IDGenerator) IDGenerator {
+	return IDGeneratorFunc(func() (string, error) {
+		id, err := idGeneratorOrDefault(gen).NewID()
+		if err != nil {
+			return "", err
+		}
+		return prefix + id, nil
+	})
+}
+
+// idGeneratorOrDefault returns gen, or DefaultIDGenerator if gen is nil.
+func idGeneratorOrDefault(gen IDGenerator) IDGenerator {
+	if gen == nil {
+		return DefaultIDGenerator
+	}
+	return gen
+}
@@ -0,0 +1,111 @@
+package sessions
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+)
+
+// Tag following payloadMagic in the header of checksummed payloads.
+const integrityTag byte = 'i'
+
+// ErrIntegrity is returned when the checksum of a stored payload does not
+// match, with the IntegrityDiscard policy.
+var ErrIntegrity = errors.New("sessions: payload integrity check failed")
+
+// IntegrityPolicy selects what IntegrityStore does with records failing
+// their integrity check.
+type IntegrityPolicy int
+
+const (
+	// IntegrityDiscard fails the load of the record with ErrIntegrity, a
+	// decode error: the middleware replaces the session according to
+	// Config.OnDecodeError.
+	IntegrityDiscard IntegrityPolicy = iota
+
+	// IntegrityFlag loads the record anyway and flags the session, see
+	// Session.IntegrityFailed.
+	IntegrityFlag
+)
+
+// IntegrityStore makes store keep an HMAC-SHA256 checksum, keyed with key,
+// of every payload it persists and verify it on load, which detects
+// corrupted records and records modified directly in the backend, or
+// copied from another session.
+//
+// Add it after EnableCompression and EnableEncryption so the checksum covers
+// the final payload. Records without a checksum, such as the ones written
+// before it was enabled, fail the check.
+//
+// It panics if store does not implement PayloadStore.
+func IntegrityStore(store Store, key []byte, policy IntegrityPolicy) Store {
+	ps, ok := store.(PayloadStore)
+	if !ok {
+		panic("sessions: IntegrityStore needs a PayloadStore")
+	}
+	ps.AddTransform(&integrityTransform{key: key, policy: policy})
+	return ps
+}
+
+// IntegrityFailed reports whether the record of the session failed the
+// integrity check of an IntegrityStore with the IntegrityFlag policy.
+func (s *Session) IntegrityFailed() bool {
+	s.loadLazy()
+	return s.integrityFailed
+}
+
+// integrityTransform is the PayloadTransform installed by IntegrityStore.
+type integrityTransform struct {
+	key    []byte
+	policy IntegrityPolicy
+}
+
+// sum returns the checksum of the payload b of the session with the given
+// ID.
+func (t *integrityTransform) sum(id string, b []byte) []byte {
+	h := hmac.New(sha256.New, t.key)
+	h.Write([]byte{payloadMagic, integrityTag})
+	h.Write([]byte(id))
+	h.Write([]byte{0})
+	h.Write(b)
+	return h.Sum(nil)
+}
+
+func (*integrityTransform) kind() byte { return integrityTag }
+
+func (t *integrityTransform) Encode(b []byte) ([]byte, error) {
+	return t.EncodeSession(nil, b)
+}
+
+func (t *integrityTransform) Decode(b []byte) ([]byte, error) {
+	return t.DecodeSession(nil, b)
+}
+
+func (t *integrityTransform) EncodeSession(s *Session, b []byte) ([]byte, error) {
+	var id string
+	if s != nil {
+		id = s.ID
+	}
+	out := append([]byte{payloadMagic, integrityTag}, t.sum(id, b)...)
+	return append(out, b...), nil
+}
+
+func (t *integrityTransform) DecodeSession(s *Session, b []byte) ([]byte, error) {
+	var id string
+	if s != nil {
+		id = s.ID
+	}
+	if len(b) >= 2+sha256.Size && b[0] == payloadMagic && b[1] == integrityTag {
+		mac, payload := b[2:2+sha256.Size], b[2+sha256.Size:]
+		if hmac.Equal(mac, t.sum(id, payload)) {
+			return payload, nil
+		}
+		b = payload
+	}
+	if t.policy == IntegrityDiscard || s == nil {
+		return nil, ErrIntegrity
+	}
+	s.integrityFailed = true
+	audit(nil, s, AuditDecodeFailure, ErrIntegrity.Error())
+	return b, nil
+}
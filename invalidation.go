@@ -0,0 +1,123 @@
+package sessions
+
+import (
+	"github.com/garyburd/redigo/redis"
+	"github.com/go-floki/floki"
+	"sync"
+	"time"
+)
+
+// invalidAfterKey is the session key of the time set with
+// Session.InvalidateAfter, in unix seconds.
+const invalidAfterKey = "_invalid_after"
+
+// loginTimeKey is the session key of the time of the last call to
+// SetAuthenticated with a user, in unix seconds.
+const loginTimeKey = "_login_time"
+
+// InvalidateAfter schedules the invalidation of the session: the first
+// request made with it after t gets a new, empty session instead.
+func (s *Session) InvalidateAfter(t time.Time) {
+	s.Set(invalidAfterKey, t.Unix())
+}
+
+// NotBeforeStore records, for each user, a time before which their logins
+// are no longer valid. Setting it when a user changes their password or
+// loses a device ends every older session of the user, wherever they are
+// stored, without enumerating them. The middleware consults it on load
+// when set in Config.NotBefore.
+type NotBeforeStore interface {
+	// SetNotBefore invalidates the sessions of userID logged in before t.
+	SetNotBefore(userID string, t time.Time) error
+
+	// NotBefore returns the time set for userID, or the zero time.
+	NotBefore(userID string) (time.Time, error)
+}
+
+// checkInvalidation invalidates s if the time set with InvalidateAfter has
+// passed, or if its user logged in before the time recorded for them in
+// store. Sessions logged in before login times were recorded count as
+// older than any time.
+func checkInvalidation(c *floki.Context, s *Session, store NotBeforeStore) error {
+	if t, ok := unixTime(s.Get(invalidAfterKey)); ok && time.Now().After(t) {
+		audit(c, s, AuditExpired, "scheduled invalidation")
+		expireSession(s)
+		s.invalidate()
+		s.loadResult = LoadExpired
+		return nil
+	}
+	userID := sessionUserID(s)
+	if store == nil || userID == "" {
+		return nil
+	}
+	notBefore, err := store.NotBefore(userID)
+	if err != nil || notBefore.IsZero() {
+		return err
+	}
+	// Login times are kept in seconds: a session logged in during the
+	// second the time was set stays valid.
+	if login, ok := unixTime(s.Get(loginTimeKey)); !ok || login.Unix() < notBefore.Unix() {
+		audit(c, s, AuditRevoked, "logged in before the user's not-before time")
+		s.invalidate()
+	}
+	return nil
+}
+
+// MemoryNotBeforeStore is a NotBeforeStore kept in process memory.
+type MemoryNotBeforeStore struct {
+	mu    sync.RWMutex
+	times map[string]time.Time
+}
+
+// NewMemoryNotBeforeStore returns an empty MemoryNotBeforeStore.
+func NewMemoryNotBeforeStore() *MemoryNotBeforeStore {
+	return &MemoryNotBeforeStore{times: make(map[string]time.Time)}
+}
+
+// SetNotBefore invalidates the sessions of userID logged in before t.
+func (m *MemoryNotBeforeStore) SetNotBefore(userID string, t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.times[userID] = t
+	return nil
+}
+
+// NotBefore returns the time set for userID, or the zero time.
+func (m *MemoryNotBeforeStore) NotBefore(userID string) (time.Time, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.times[userID], nil
+}
+
+// RedisNotBeforeStore is a NotBeforeStore kept in redis, shared by every
+// process using the same server.
+type RedisNotBeforeStore struct {
+	Pool   *redis.Pool
+	Prefix string // prefix of the redis keys, "not_before_" by default
+}
+
+// NewRedisNotBeforeStore returns a RedisNotBeforeStore using pool.
+func NewRedisNotBeforeStore(pool *redis.Pool) *RedisNotBeforeStore {
+	return &RedisNotBeforeStore{Pool: pool, Prefix: "not_before_"}
+}
+
+// SetNotBefore invalidates the sessions of userID logged in before t.
+func (r *RedisNotBeforeStore) SetNotBefore(userID string, t time.Time) error {
+	conn := r.Pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("SET", r.Prefix+userID, t.Unix())
+	return err
+}
+
+// NotBefore returns the time set for userID, or the zero time.
+func (r *RedisNotBeforeStore) NotBefore(userID string) (time.Time, error) {
+	conn := r.Pool.Get()
+	defer conn.Close()
+	sec, err := redis.Int64(conn.Do("GET", r.Prefix+userID))
+	if err == redis.ErrNil {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, 0), nil
+}
@@ -0,0 +1,145 @@
+package sessions
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ExpiredDeleter is implemented by stores whose expired sessions must be
+// removed by a Janitor. MemoryStore and FilesystemStore implement it;
+// redis expires its keys by itself.
+type ExpiredDeleter interface {
+	// DeleteExpired removes at most limit expired sessions, or all of
+	// them if limit is 0, and returns how many were removed.
+	DeleteExpired(ctx context.Context, limit int) (int, error)
+}
+
+// JanitorConfig configures a Janitor.
+type JanitorConfig struct {
+	// Interval is the time between two sweeps. The default is 10 minutes.
+	Interval time.Duration
+
+	// BatchSize is the number of sessions removed at once. A sweep
+	// removes batches until the store has no expired session left, so
+	// locks are held briefly even when many sessions expire together. The
+	// default is 1000.
+	BatchSize int
+
+	// Jitter is the maximum random delay added to every interval, so the
+	// sweeps of several processes sharing a store don't line up. The
+	// default is a tenth of Interval; set it to a negative value for none.
+	Jitter time.Duration
+
+	// OnError, if set, is called with the errors of the sweeps started by
+	// Start. Failed sweeps are retried at the next interval.
+	OnError func(err error)
+}
+
+// Janitor periodically removes the expired sessions of a store.
+//
+//	janitor := sessions.NewJanitor(store, sessions.JanitorConfig{})
+//	janitor.Start()
+//	defer janitor.Stop(context.Background())
+//
+// A Janitor is not tied to the floki application: nothing stops it when
+// the application shuts down, so call Stop alongside the graceful shutdown
+// of the HTTP server, or its goroutine keeps running until the process
+// exits.
+type Janitor struct {
+	store  ExpiredDeleter
+	config JanitorConfig
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewJanitor returns a Janitor for store. It does nothing until Start is
+// called, and runs until Stop is called.
+func NewJanitor(store ExpiredDeleter, config JanitorConfig) *Janitor {
+	if config.Interval <= 0 {
+		config.Interval = 10 * time.Minute
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 1000
+	}
+	if config.Jitter == 0 {
+		config.Jitter = config.Interval / 10
+	}
+	return &Janitor{store: store, config: config}
+}
+
+// Sweep removes the expired sessions of the store right away, batch by
+// batch, and returns how many were removed.
+func (j *Janitor) Sweep(ctx context.Context) (int, error) {
+	total := 0
+	for ctx.Err() == nil {
+		n, err := j.store.DeleteExpired(ctx, j.config.BatchSize)
+		total += n
+		if err != nil || n < j.config.BatchSize {
+			return total, err
+		}
+	}
+	return total, ctx.Err()
+}
+
+// Start sweeps the store every interval in a new goroutine until Stop is
+// called.
+func (j *Janitor) Start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	j.cancel, j.done = cancel, done
+
+	go func() {
+		defer close(done)
+		timer := time.NewTimer(j.next())
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+				if _, err := j.Sweep(ctx); err != nil && ctx.Err() == nil &&
+					j.config.OnError != nil {
+					j.config.OnError(err)
+				}
+				timer.Reset(j.next())
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// next returns the delay before the next sweep.
+func (j *Janitor) next() time.Duration {
+	d := j.config.Interval
+	if j.config.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(j.config.Jitter) + 1))
+	}
+	return d
+}
+
+// Stop stops the sweeps started by Start, interrupting the current one
+// between two batches, and waits for it to return or for ctx to be done.
+func (j *Janitor) Stop(ctx context.Context) error {
+	j.mu.Lock()
+	cancel, done := j.cancel, j.done
+	j.cancel, j.done = nil, nil
+	j.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,410 @@
+package sessions
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParseKeyPairs parses a list of key pairs, newest first, separated by
+// commas or whitespace. Each pair is a base64 hash key optionally followed
+// by a colon and a base64 block key:
+//
+//	hashKey2:blockKey2, hashKey1:blockKey1
+//
+// It is the format read by the environment, file and Vault key providers.
+func ParseKeyPairs(s string) ([]KeyPair, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	if len(fields) == 0 {
+		return nil, errHashKeyNotSet
+	}
+	pairs := make([]KeyPair, 0, len(fields))
+	for _, f := range fields {
+		var p KeyPair
+		parts := strings.SplitN(f, ":", 2)
+		var err error
+		if p.HashKey, err = decodeKey(parts[0]); err != nil {
+			return nil, err
+		}
+		if len(parts) == 2 {
+			if p.BlockKey, err = decodeKey(parts[1]); err != nil {
+				return nil, err
+			}
+		}
+		pairs = append(pairs, p)
+	}
+	return pairs, nil
+}
+
+// decodeKey decodes a key in standard or URL-safe base64, padded or not.
+func decodeKey(s string) ([]byte, error) {
+	for _, enc := range []*base64.Encoding{base64.StdEncoding,
+		base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(s); err == nil {
+			return b, nil
+		}
+	}
+	return nil, errors.New("sessions: key is not valid base64")
+}
+
+// keySet holds the key pairs of a KeyProvider and notifies its listeners
+// when they change.
+type keySet struct {
+	mu        sync.RWMutex
+	current   KeyPair
+	previous  []KeyPair
+	listeners []func(KeyPair)
+}
+
+// Current returns the key pair new values are encoded with.
+func (k *keySet) Current() KeyPair {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+// Previous returns the older key pairs still accepted, newest first.
+func (k *keySet) Previous() []KeyPair {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return append([]KeyPair(nil), k.previous...)
+}
+
+// OnRotate registers fn to be called every time the key pairs change.
+func (k *keySet) OnRotate(fn func(current KeyPair)) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.listeners = append(k.listeners, fn)
+}
+
+// set replaces the key pairs with pairs, newest first, and notifies the
+// listeners if they changed.
+func (k *keySet) set(pairs []KeyPair) {
+	k.mu.Lock()
+	changed := !sameKeyPairs(append([]KeyPair{k.current}, k.previous...), pairs)
+	k.current, k.previous = pairs[0], append([]KeyPair(nil), pairs[1:]...)
+	listeners := append(([]func(KeyPair))(nil), k.listeners...)
+	k.mu.Unlock()
+	if changed {
+		for _, fn := range listeners {
+			fn(pairs[0])
+		}
+	}
+}
+
+// sameKeyPairs reports whether a and b hold the same key pairs.
+func sameKeyPairs(a, b []KeyPair) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i].HashKey, b[i].HashKey) ||
+			!bytes.Equal(a[i].BlockKey, b[i].BlockKey) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewEnvKeyProvider returns a KeyProvider with the key pairs held by the
+// environment variable name, in the format of ParseKeyPairs. The keys are
+// read once; restart the process to change them.
+func NewEnvKeyProvider(name string) (KeyProvider, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil, fmt.Errorf("sessions: environment variable %s is not set", name)
+	}
+	pairs, err := ParseKeyPairs(v)
+	if err != nil {
+		return nil, err
+	}
+	k := &keySet{}
+	k.set(pairs)
+	return k, nil
+}
+
+// FileKeyProvider is a KeyProvider reading key pairs from a file, in the
+// format of ParseKeyPairs, such as a mounted Kubernetes or Docker secret.
+// Once Watch is called, the file is reloaded every time it changes.
+type FileKeyProvider struct {
+	keySet
+	path    string
+	watcher *fsnotify.Watcher
+
+	// OnError, if set, is called with the errors of reloads started by
+	// Watch. The previous keys stay in use when a reload fails.
+	OnError func(err error)
+}
+
+// NewFileKeyProvider returns a FileKeyProvider reading the file at path.
+func NewFileKeyProvider(path string) (*FileKeyProvider, error) {
+	f := &FileKeyProvider{path: path}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload reads the key pairs from the file again.
+func (f *FileKeyProvider) Reload() error {
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	pairs, err := ParseKeyPairs(string(b))
+	if err != nil {
+		return err
+	}
+	f.set(pairs)
+	return nil
+}
+
+// Watch reloads the file whenever it changes, until Close is called.
+//
+// The directory of the file is watched rather than the file itself, so
+// files replaced by a rename, as secret mounts do, are picked up too.
+func (f *FileKeyProvider) Watch() error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := w.Add(filepath.Dir(f.path)); err != nil {
+		w.Close()
+		return err
+	}
+	f.mu.Lock()
+	if f.watcher != nil {
+		f.mu.Unlock()
+		w.Close()
+		return nil
+	}
+	f.watcher = w
+	f.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case _, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				// Events for other files of the directory are cheap to
+				// handle, and symlinked mounts change the path of the
+				// target, so reload on every event.
+				if err := f.Reload(); err != nil && !os.IsNotExist(err) {
+					f.error(err)
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				f.error(err)
+			}
+		}
+	}()
+	return nil
+}
+
+// error reports err to OnError, if set.
+func (f *FileKeyProvider) error(err error) {
+	if f.OnError != nil {
+		f.OnError(err)
+	}
+}
+
+// Close stops watching the file.
+func (f *FileKeyProvider) Close() error {
+	f.mu.Lock()
+	w := f.watcher
+	f.watcher = nil
+	f.mu.Unlock()
+	if w == nil {
+		return nil
+	}
+	return w.Close()
+}
+
+// VaultKeyProvider is a KeyProvider reading key pairs from a HashiCorp
+// Vault KV version 2 secret, in the format of ParseKeyPairs.
+type VaultKeyProvider struct {
+	keySet
+
+	// Addr is the address of the Vault server, such as
+	// "https://vault.example.com:8200".
+	Addr string
+
+	// Token authenticates the requests to Vault.
+	Token string
+
+	// Path is the API path of the secret, such as "secret/data/sessions".
+	Path string
+
+	// Field is the field of the secret holding the key pairs. The default
+	// is "keys".
+	Field string
+
+	// Client is the HTTP client used for the requests. The default is
+	// http.DefaultClient.
+	Client *http.Client
+
+	// OnError, if set, is called with the errors of refreshes started by
+	// Start. The previous keys stay in use when a refresh fails.
+	OnError func(err error)
+
+	stop chan struct{}
+}
+
+// NewVaultKeyProvider returns a VaultKeyProvider for the secret at path,
+// reading its key pairs right away.
+func NewVaultKeyProvider(ctx context.Context, addr, token, path string) (*VaultKeyProvider, error) {
+	v := &VaultKeyProvider{Addr: addr, Token: token, Path: path}
+	if err := v.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Refresh reads the key pairs from Vault again.
+func (v *VaultKeyProvider) Refresh(ctx context.Context) error {
+	url := strings.TrimRight(v.Addr, "/") + "/v1/" + strings.TrimLeft(v.Path, "/")
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Vault-Token", v.Token)
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("sessions: vault returned %s for %s", res.Status, v.Path)
+	}
+	var secret struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&secret); err != nil {
+		return err
+	}
+	field := v.Field
+	if field == "" {
+		field = "keys"
+	}
+	keys, ok := secret.Data.Data[field].(string)
+	if !ok {
+		return fmt.Errorf("sessions: vault secret %s has no field %q", v.Path, field)
+	}
+	pairs, err := ParseKeyPairs(keys)
+	if err != nil {
+		return err
+	}
+	v.set(pairs)
+	return nil
+}
+
+// Start refreshes the key pairs every interval in a new goroutine until
+// Stop is called. Failed refreshes keep the previous keys and are retried
+// at the next interval.
+func (v *VaultKeyProvider) Start(interval time.Duration) {
+	v.mu.Lock()
+	if v.stop != nil {
+		v.mu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	v.stop = stop
+	v.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				if err := v.Refresh(ctx); err != nil {
+					v.error(err)
+				}
+				cancel()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// error reports err to OnError, if set.
+func (v *VaultKeyProvider) error(err error) {
+	if v.OnError != nil {
+		v.OnError(err)
+	}
+}
+
+// Stop stops the refreshes started by Start.
+func (v *VaultKeyProvider) Stop() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.stop != nil {
+		close(v.stop)
+		v.stop = nil
+	}
+}
+
+// KMSDecrypter decrypts data keys encrypted by a key management service.
+// A thin adapter over the Decrypt call of the AWS KMS client, or of any
+// other KMS, satisfies it.
+type KMSDecrypter interface {
+	Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
+}
+
+// Info strings binding the keys derived from KMS data keys to their purpose.
+const (
+	kmsHashKeyInfo  = "floki sessions kms hash key"
+	kmsBlockKeyInfo = "floki sessions kms block key"
+)
+
+// NewKMSKeyProvider returns a KeyProvider whose key pairs are derived from
+// data keys encrypted by a KMS, such as the CiphertextBlob of AWS KMS
+// GenerateDataKey, ordered from newest to oldest. Only the encrypted data
+// keys need to be shipped with the application; they are decrypted with
+// kms once, and a 32-byte hash key and block key are derived from each one
+// with HKDF-SHA256.
+func NewKMSKeyProvider(ctx context.Context, kms KMSDecrypter, dataKeys ...[]byte) (KeyProvider, error) {
+	if len(dataKeys) == 0 {
+		return nil, errHashKeyNotSet
+	}
+	pairs := make([]KeyPair, 0, len(dataKeys))
+	for _, blob := range dataKeys {
+		plain, err := kms.Decrypt(ctx, blob)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, KeyPair{
+			HashKey:  deriveKey(plain, kmsHashKeyInfo),
+			BlockKey: deriveKey(plain, kmsBlockKeyInfo),
+		})
+	}
+	k := &keySet{}
+	k.set(pairs)
+	return k, nil
+}
@@ -0,0 +1,311 @@
+package sessions
+
+import (
+	"errors"
+	"github.com/gorilla/securecookie"
+	"sync"
+	"time"
+)
+
+// KeyPair is a key used to sign values and an optional key used to encrypt
+// them. See NewCookieStore() for the key requirements.
+type KeyPair struct {
+	HashKey  []byte
+	BlockKey []byte
+}
+
+// KeyRing signs and encrypts values with the newest of an ordered list of
+// key pairs and verifies them against all of them, so operators can rotate
+// secrets without invalidating every live session.
+//
+// KeyRing implements securecookie.Codec and is safe for concurrent use; put
+// it alone in the Codecs field of a store:
+//
+//	ring, err := sessions.NewKeyRing(newest, previous)
+//	store.Codecs = []securecookie.Codec{ring}
+type KeyRing struct {
+	mu     sync.RWMutex
+	codecs []*SecureCodec
+	maxAge int
+}
+
+// NewKeyRing returns a KeyRing for pairs, ordered from newest to oldest.
+func NewKeyRing(pairs ...KeyPair) (*KeyRing, error) {
+	if len(pairs) == 0 {
+		return nil, errHashKeyNotSet
+	}
+	k := &KeyRing{maxAge: 86400 * 30}
+	for _, p := range pairs {
+		codec, err := k.newCodec(p)
+		if err != nil {
+			return nil, err
+		}
+		k.codecs = append(k.codecs, codec)
+	}
+	return k, nil
+}
+
+// newCodec returns a SecureCodec for p configured like the ring.
+func (k *KeyRing) newCodec(p KeyPair) (*SecureCodec, error) {
+	codec, err := NewSecureCodec(p.HashKey, p.BlockKey)
+	if err != nil {
+		return nil, err
+	}
+	return codec.MaxAge(k.maxAge).MaxLength(0), nil
+}
+
+// Rotate makes p the newest key pair, keeping at most keep pairs in
+// total; the oldest ones are dropped. If keep is 0 no pair is dropped.
+func (k *KeyRing) Rotate(p KeyPair, keep int) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	codec, err := k.newCodec(p)
+	if err != nil {
+		return err
+	}
+	k.codecs = append([]*SecureCodec{codec}, k.codecs...)
+	if keep > 0 && len(k.codecs) > keep {
+		k.codecs = k.codecs[:keep]
+	}
+	return nil
+}
+
+// Reset replaces the key pairs of the ring with pairs, ordered from newest
+// to oldest.
+func (k *KeyRing) Reset(pairs ...KeyPair) error {
+	if len(pairs) == 0 {
+		return errHashKeyNotSet
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	codecs := make([]*SecureCodec, 0, len(pairs))
+	for _, p := range pairs {
+		codec, err := k.newCodec(p)
+		if err != nil {
+			return err
+		}
+		codecs = append(codecs, codec)
+	}
+	k.codecs = codecs
+	return nil
+}
+
+// MaxAge restricts the maximum age, in seconds, of decoded values for every
+// key pair. Set it to 0 for no restriction. The default is 30 days.
+func (k *KeyRing) MaxAge(v int) *KeyRing {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.maxAge = v
+	// Replace the codecs rather than changing them: Encode and Decode use
+	// them once the lock is released.
+	codecs := make([]*SecureCodec, len(k.codecs))
+	for i, codec := range k.codecs {
+		copied := *codec
+		copied.maxAge = int64(v)
+		codecs[i] = &copied
+	}
+	k.codecs = codecs
+	return k
+}
+
+// Encode encodes value with the newest key pair.
+func (k *KeyRing) Encode(name string, value interface{}) (string, error) {
+	k.mu.RLock()
+	codec := k.codecs[0]
+	k.mu.RUnlock()
+	return codec.Encode(name, value)
+}
+
+// Decode decodes value with the first key pair that verifies it.
+func (k *KeyRing) Decode(name, value string, dst interface{}) error {
+	k.mu.RLock()
+	codecs := k.codecs
+	k.mu.RUnlock()
+	err := errors.New("sessions: no key pair in the ring")
+	for _, codec := range codecs {
+		if err = codec.Decode(name, value, dst); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// KeyProvider supplies the key pairs used to sign and encrypt sessions, so
+// they can come from files, the environment or a secret manager and change
+// while the application runs.
+type KeyProvider interface {
+	// Current returns the key pair new values are encoded with.
+	Current() KeyPair
+
+	// Previous returns the older key pairs still accepted when decoding,
+	// newest first.
+	Previous() []KeyPair
+
+	// OnRotate registers fn to be called with the new current key pair
+	// every time it changes.
+	OnRotate(fn func(current KeyPair))
+}
+
+// errorReporter is implemented by the KeyProviders of this package, which
+// report the errors of their background updates to their OnError hook.
+type errorReporter interface {
+	error(err error)
+}
+
+// NewProviderKeyRing returns a KeyRing holding the key pairs of p, which is
+// updated every time p rotates its keys. When the new key pairs can't be
+// used, the ring keeps the previous ones and the error is reported to the
+// OnError hook of p, if it has one.
+func NewProviderKeyRing(p KeyProvider) (*KeyRing, error) {
+	k, err := NewKeyRing(append([]KeyPair{p.Current()}, p.Previous()...)...)
+	if err != nil {
+		return nil, err
+	}
+	p.OnRotate(func(current KeyPair) {
+		err := k.Reset(append([]KeyPair{current}, p.Previous()...)...)
+		if r, ok := p.(errorReporter); ok && err != nil {
+			r.error(err)
+		}
+	})
+	return k, nil
+}
+
+// RandomKeyPair returns a key pair with a 64-byte hash key and a 32-byte
+// block key generated with securecookie.GenerateRandomKey.
+func RandomKeyPair() (KeyPair, error) {
+	p := KeyPair{
+		HashKey:  securecookie.GenerateRandomKey(64),
+		BlockKey: securecookie.GenerateRandomKey(32),
+	}
+	if p.HashKey == nil || p.BlockKey == nil {
+		return KeyPair{}, errors.New("sessions: failed to generate key pair")
+	}
+	return p, nil
+}
+
+// RotatingKeyProvider is a KeyProvider replacing its current key pair with
+// a newly generated one at a fixed interval.
+//
+// Generated keys only live in memory, so it is meant for single-process
+// deployments or for generate functions fetching keys from shared storage.
+type RotatingKeyProvider struct {
+	mu        sync.RWMutex
+	current   KeyPair
+	previous  []KeyPair
+	keep      int
+	interval  time.Duration
+	generate  func() (KeyPair, error)
+	listeners []func(KeyPair)
+	stop      chan struct{}
+
+	// OnError, if set, is called with the errors of rotations started by
+	// Start. The previous keys stay in use when a rotation fails.
+	OnError func(err error)
+}
+
+// NewRotatingKeyProvider returns a RotatingKeyProvider calling generate
+// for a new key pair every interval once started, and accepting the keep
+// most recent previous pairs. If generate is nil RandomKeyPair is used.
+func NewRotatingKeyProvider(interval time.Duration, keep int,
+	generate func() (KeyPair, error)) (*RotatingKeyProvider, error) {
+	if generate == nil {
+		generate = RandomKeyPair
+	}
+	current, err := generate()
+	if err != nil {
+		return nil, err
+	}
+	return &RotatingKeyProvider{
+		current:  current,
+		keep:     keep,
+		interval: interval,
+		generate: generate,
+	}, nil
+}
+
+// Current returns the key pair new values are encoded with.
+func (r *RotatingKeyProvider) Current() KeyPair {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current
+}
+
+// Previous returns the older key pairs still accepted, newest first.
+func (r *RotatingKeyProvider) Previous() []KeyPair {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]KeyPair(nil), r.previous...)
+}
+
+// OnRotate registers fn to be called after every rotation.
+func (r *RotatingKeyProvider) OnRotate(fn func(current KeyPair)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners = append(r.listeners, fn)
+}
+
+// Rotate replaces the current key pair with a newly generated one right
+// away and notifies the listeners.
+func (r *RotatingKeyProvider) Rotate() error {
+	next, err := r.generate()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.previous = append([]KeyPair{r.current}, r.previous...)
+	if len(r.previous) > r.keep {
+		r.previous = r.previous[:r.keep]
+	}
+	r.current = next
+	listeners := append(([]func(KeyPair))(nil), r.listeners...)
+	r.mu.Unlock()
+	for _, fn := range listeners {
+		fn(next)
+	}
+	return nil
+}
+
+// Start rotates the keys every interval in a new goroutine until Stop is
+// called. Failed rotations are retried at the next interval.
+func (r *RotatingKeyProvider) Start() {
+	r.mu.Lock()
+	if r.stop != nil {
+		r.mu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	r.stop = stop
+	r.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := r.Rotate(); err != nil {
+					r.error(err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// error reports err to OnError, if set.
+func (r *RotatingKeyProvider) error(err error) {
+	if r.OnError != nil {
+		r.OnError(err)
+	}
+}
+
+// Stop stops the rotation started by Start.
+func (r *RotatingKeyProvider) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+}
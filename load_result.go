@@ -0,0 +1,65 @@
+package sessions
+
+import (
+	"errors"
+)
+
+// LoadResult tells what became of the session presented by a request.
+type LoadResult int
+
+const (
+	// LoadNew means the request presented no session: it is new.
+	LoadNew LoadResult = iota
+
+	// LoadFound means the session presented by the request was loaded.
+	LoadFound
+
+	// LoadNotFound means the request presented a session ID the store
+	// has no record of, such as one deleted on logout. Stores expiring
+	// records by themselves, like redis, report expired sessions this
+	// way too.
+	LoadNotFound
+
+	// LoadExpired means the session presented by the request had expired:
+	// its cookie or record, or one of the timeouts of the middleware.
+	LoadExpired
+
+	// LoadInvalid means the session presented by the request could not be
+	// decoded, because it was tampered with, signed with unknown keys or
+	// corrupted.
+	LoadInvalid
+)
+
+var loadResultNames = []string{"new", "found", "not found", "expired", "invalid"}
+
+func (r LoadResult) String() string {
+	if r < 0 || int(r) >= len(loadResultNames) {
+		return "unknown"
+	}
+	return loadResultNames[r]
+}
+
+// LoadResult returns what became of the session presented by the request,
+// so handlers can tell users their session expired, or log tampering.
+// The session of any result other than LoadFound is new.
+func (s *Session) LoadResult() LoadResult {
+	s.loadLazy()
+	return s.loadResult
+}
+
+// setLoadResult records the result of loading s, presented by the request
+// if presented is true, with the error err of the store. Results already
+// set by the store, such as LoadExpired by MemoryStore, are kept.
+func (s *Session) setLoadResult(presented bool, err error) {
+	switch {
+	case s.loadResult != LoadNew:
+	case errors.Is(err, ErrExpired):
+		s.loadResult = LoadExpired
+	case isDecodeError(err):
+		s.loadResult = LoadInvalid
+	case !s.IsNew:
+		s.loadResult = LoadFound
+	case presented:
+		s.loadResult = LoadNotFound
+	}
+}
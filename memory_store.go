@@ -0,0 +1,381 @@
+package sessions
+
+import (
+	"context"
+	"github.com/go-floki/floki"
+	"github.com/gorilla/securecookie"
+	"hash/fnv"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Default number of shards used by NewMemoryStore.
+const defaultMemoryShards = 64
+
+// MemoryStore stores sessions in process memory.
+//
+// Records are spread over a fixed number of shards keyed by a hash of the
+// session ID, each guarded by its own lock, so concurrent requests for
+// different sessions rarely contend with each other.
+type MemoryStore struct {
+	Codecs        []securecookie.Codec
+	Options       *Options    // default configuration
+	DefaultMaxAge int         // default TTL for a MaxAge == 0 session
+	ValueCodec    Codec       // serializes Values; gob when nil
+	LazyDecode    bool        // defer decoding Values until first use, see Session.Load
+	IDs           IDGenerator // creates session IDs; DefaultIDGenerator when nil
+
+	// ExpiryGrace is how long after their expiry records can still be
+	// loaded. Sessions loaded within it are saved again at the end of the
+	// request, which renews them, so parallel requests or clock skew right
+	// at the expiry don't log users out.
+	ExpiryGrace time.Duration
+
+	maxLength  int
+	shards     []*memoryShard
+	transforms transformChain
+
+	expireListeners
+
+	// users indexes the IDs of the sessions of each user.
+	usersMu sync.Mutex
+	users   map[string]map[string]struct{}
+}
+
+// memoryShard is a lock-protected subset of a MemoryStore's records.
+type memoryShard struct {
+	sync.RWMutex
+	records map[string]memoryRecord
+}
+
+// memoryRecord is the encoded session values and their expiration time.
+type memoryRecord struct {
+	data    []byte
+	expires time.Time
+	userID  string
+}
+
+// NewMemoryStore returns a new MemoryStore.
+//
+// See NewCookieStore() for a description of the keyPairs parameter.
+func NewMemoryStore(keyPairs ...[]byte) *MemoryStore {
+	return NewMemoryStoreWithShards(defaultMemoryShards, keyPairs...)
+}
+
+// NewMemoryStoreWithShards returns a new MemoryStore that spreads its records
+// over n shards. Values of n lower than 1 are treated as 1.
+func NewMemoryStoreWithShards(n int, keyPairs ...[]byte) *MemoryStore {
+	if n < 1 {
+		n = 1
+	}
+	shards := make([]*memoryShard, n)
+	for i := range shards {
+		shards[i] = &memoryShard{records: make(map[string]memoryRecord)}
+	}
+	return &MemoryStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &Options{
+			Path:     "/",
+			MaxAge:   sessionExpire,
+			SameSite: http.SameSiteLaxMode,
+		},
+		DefaultMaxAge: 60 * 20,
+		shards:        shards,
+		users:         make(map[string]map[string]struct{}),
+	}
+}
+
+// SetMaxLength restricts the maximum length of new sessions to l; Save
+// returns ErrSessionTooLarge for longer ones. If l is 0, which is the
+// default, there is no limit to the size of a session.
+func (s *MemoryStore) SetMaxLength(l int) {
+	if l >= 0 {
+		s.maxLength = l
+	}
+}
+
+// AddTransform appends t to the transforms applied to stored records.
+func (s *MemoryStore) AddTransform(t PayloadTransform) {
+	s.transforms.add(t)
+}
+
+// shard returns the shard holding the record for id.
+func (s *MemoryStore) shard(id string) *memoryShard {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+// Get returns a session for the given name after adding it to the registry.
+//
+// See CookieStore.Get().
+func (s *MemoryStore) Get(c *floki.Context, name string) (*Session, error) {
+	return GetRegistry(c).Get(s, name)
+}
+
+// New returns a session for the given name without adding it to the registry.
+//
+// See CookieStore.New().
+func (s *MemoryStore) New(c *floki.Context, name string) (*Session, error) {
+	session := NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+	var err error
+	if cookie, errCookie := c.Request.Cookie(name); errCookie == nil {
+		err = decodeError(securecookie.DecodeMulti(name, cookie.Value, &session.ID, s.Codecs...))
+		if err == nil {
+			var ok bool
+			ok, err = s.load(session)
+			session.IsNew = !(err == nil && ok)
+		}
+	}
+	return session, err
+}
+
+// Save adds a single session to the response.
+func (s *MemoryStore) Save(c *floki.Context, session *Session) error {
+	// Marked for deletion.
+	if session.Options.MaxAge < 0 {
+		s.delete(session)
+		http.SetCookie(c.Writer, NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+	if session.ID == "" {
+		id, err := idGeneratorOrDefault(s.IDs).NewID()
+		if err != nil {
+			return err
+		}
+		session.ID = id
+	}
+	if err := s.save(session); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID,
+		s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(c.Writer, NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// save encodes session.Values and stores them in the session's shard.
+func (s *MemoryStore) save(session *Session) error {
+	if err := session.Load(); err != nil {
+		return err
+	}
+	data, err := codecOrGob(s.ValueCodec).Marshal(session.Values)
+	if err != nil {
+		return err
+	}
+	data, err = s.transforms.encode(session, data)
+	if err != nil {
+		return err
+	}
+	if s.maxLength != 0 && len(data) > s.maxLength {
+		return ErrSessionTooLarge
+	}
+	age := session.Options.MaxAge
+	if age == 0 {
+		age = s.DefaultMaxAge
+	}
+	record := memoryRecord{
+		data:    data,
+		expires: time.Now().Add(time.Duration(age) * time.Second),
+		userID:  sessionUserID(session),
+	}
+	shard := s.shard(session.ID)
+	shard.Lock()
+	if old, ok := shard.records[session.ID]; ok && old.userID != record.userID {
+		s.unindex(old.userID, session.ID)
+	}
+	shard.records[session.ID] = record
+	s.index(record.userID, session.ID)
+	shard.Unlock()
+	return nil
+}
+
+// index adds id to the sessions of userID, if not empty.
+func (s *MemoryStore) index(userID, id string) {
+	if userID == "" {
+		return
+	}
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+	ids := s.users[userID]
+	if ids == nil {
+		ids = make(map[string]struct{})
+		s.users[userID] = ids
+	}
+	ids[id] = struct{}{}
+}
+
+// unindex removes id from the sessions of userID, if not empty.
+func (s *MemoryStore) unindex(userID, id string) {
+	if userID == "" {
+		return
+	}
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+	if ids := s.users[userID]; ids != nil {
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(s.users, userID)
+		}
+	}
+}
+
+// DeleteByUserID deletes every session of userID, which also removes the
+// user from the index.
+func (s *MemoryStore) DeleteByUserID(ctx context.Context, userID string) error {
+	ids, _ := s.UserSessionIDs(userID)
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.DeleteID(id)
+	}
+	return nil
+}
+
+// UserSessionIDs returns the IDs of the sessions of userID.
+func (s *MemoryStore) UserSessionIDs(userID string) ([]string, error) {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+	ids := make([]string, 0, len(s.users[userID]))
+	for id := range s.users[userID] {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// load decodes the values stored for session.ID into session.Values.
+// It returns false if there is no live record for the ID.
+func (s *MemoryStore) load(session *Session) (bool, error) {
+	shard := s.shard(session.ID)
+	shard.RLock()
+	record, ok := shard.records[session.ID]
+	shard.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	now := time.Now()
+	if now.After(record.expires.Add(s.ExpiryGrace)) {
+		shard.Lock()
+		r, ok := shard.records[session.ID]
+		if ok && now.After(r.expires.Add(s.ExpiryGrace)) {
+			delete(shard.records, session.ID)
+			s.unindex(r.userID, session.ID)
+		}
+		shard.Unlock()
+		if ok {
+			s.expireRecord(session.ID, r)
+		}
+		session.loadResult = LoadExpired
+		return false, nil
+	}
+	if now.After(record.expires) {
+		// Within the grace period: saving the session renews it.
+		session.dirty = true
+	}
+	data, err := s.transforms.decode(session, record.data)
+	if err != nil {
+		return false, malformed(err)
+	}
+	if s.LazyDecode {
+		session.setRaw(data, codecOrGob(s.ValueCodec))
+		return true, nil
+	}
+	return true, malformed(codecOrGob(s.ValueCodec).Unmarshal(data, session.Values))
+}
+
+// Touch extends the lifetime of the record of session and reissues its
+// cookie. A record that expired in the meantime is saved again.
+func (s *MemoryStore) Touch(c *floki.Context, session *Session) error {
+	age := session.Options.MaxAge
+	if age == 0 {
+		age = s.DefaultMaxAge
+	}
+	shard := s.shard(session.ID)
+	shard.Lock()
+	record, ok := shard.records[session.ID]
+	if ok {
+		record.expires = time.Now().Add(time.Duration(age) * time.Second)
+		shard.records[session.ID] = record
+	}
+	shard.Unlock()
+	if !ok {
+		if err := s.save(session); err != nil {
+			return err
+		}
+	}
+	return writeIDCookie(c, session, s.Codecs...)
+}
+
+// expireRecord notifies the expiry of the record of the session id, which
+// is decoded if functions are registered with OnExpire.
+func (s *MemoryStore) expireRecord(id string, record memoryRecord) {
+	if !s.listening() {
+		return
+	}
+	session := NewSession(s, "")
+	session.ID = id
+	var values map[interface{}]interface{}
+	if data, err := s.transforms.decode(session, record.data); err == nil &&
+		codecOrGob(s.ValueCodec).Unmarshal(data, session.Values) == nil {
+		values = session.Values
+	}
+	s.expired(id, values)
+}
+
+// DeleteExpired removes at most limit expired records, or all of them if
+// limit is 0, and returns how many were removed. See Janitor.
+func (s *MemoryStore) DeleteExpired(ctx context.Context, limit int) (int, error) {
+	now := time.Now()
+	n := 0
+	for _, shard := range s.shards {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		expired := make(map[string]memoryRecord)
+		shard.Lock()
+		for id, r := range shard.records {
+			if limit > 0 && n+len(expired) >= limit {
+				break
+			}
+			if now.After(r.expires.Add(s.ExpiryGrace)) {
+				delete(shard.records, id)
+				s.unindex(r.userID, id)
+				expired[id] = r
+			}
+		}
+		shard.Unlock()
+		for id, r := range expired {
+			s.expireRecord(id, r)
+		}
+		n += len(expired)
+		if limit > 0 && n >= limit {
+			break
+		}
+	}
+	return n, nil
+}
+
+// delete removes the record stored for session.ID.
+func (s *MemoryStore) delete(session *Session) {
+	s.DeleteID(session.ID)
+}
+
+// DeleteID removes the record stored for the session ID.
+func (s *MemoryStore) DeleteID(id string) error {
+	shard := s.shard(id)
+	shard.Lock()
+	if r, ok := shard.records[id]; ok {
+		delete(shard.records, id)
+		s.unindex(r.userID, id)
+	}
+	shard.Unlock()
+	return nil
+}
@@ -0,0 +1,42 @@
+package sessions
+
+import (
+	"time"
+)
+
+// saveCountKey is the session key of the number of times the session was
+// saved by the middleware, for Config.TrackAccess.
+const saveCountKey = "_saves"
+
+// trackAccess records the creation time of s if it has none yet, and the
+// current request as its last access.
+func trackAccess(s *Session) {
+	now := time.Now()
+	if _, ok := unixTime(s.Get(createdAtKey)); !ok {
+		s.Set(createdAtKey, now.Unix())
+	}
+	touchLastSeen(s, now)
+}
+
+// CreatedAt returns when the session was created, to the second, or the
+// zero time if it was not recorded. It is recorded with Config.TrackAccess
+// and Config.AbsoluteTimeout.
+func (s *Session) CreatedAt() time.Time {
+	t, _ := unixTime(s.Get(createdAtKey))
+	return t
+}
+
+// LastAccessedAt returns when the last request was made with the session,
+// to the second, or the zero time if it was not recorded. It is recorded
+// with Config.TrackAccess and Config.IdleTimeout.
+func (s *Session) LastAccessedAt() time.Time {
+	t, _ := unixTime(s.Get(lastSeenKey))
+	return t
+}
+
+// SaveCount returns how many times the middleware saved the session, with
+// Config.TrackAccess. The current request is counted once it is saved.
+func (s *Session) SaveCount() int {
+	n, _ := int64Value(s.Get(saveCountKey))
+	return int(n)
+}
@@ -0,0 +1,55 @@
+package sessions
+
+import (
+	"github.com/go-floki/floki"
+)
+
+// namedSessionKey returns the context key of the session called name.
+func namedSessionKey(name string) string {
+	return "_session." + name
+}
+
+// NamedSession is one of the sessions of SessionsNamed.
+type NamedSession struct {
+	Name   string
+	Store  Store
+	Config Config
+}
+
+// SessionsNamed returns a middleware handling every session of sessions,
+// such as an "auth" session in redis next to a long-lived "prefs" one in a
+// cookie. Handlers get them with GetNamed; Get, Remember and Forget use the
+// first one, whose values are the "session" context value. Each session
+// keeps the Tombstones and RememberMe of its own Config.
+func SessionsNamed(sessions ...NamedSession) floki.HandlerFunc {
+	handlers := make([]func(*floki.Context) bool, len(sessions))
+	for i, ns := range sessions {
+		handlers[i] = newHandler(ns.Name, ns.Store, ns.Config, i == 0)
+	}
+	return func(c *floki.Context) {
+		for _, handler := range handlers {
+			if !handler(c) {
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// GetNamed returns the session of the request registered under name, by
+// SessionsNamed or any other middleware of the package. It panics if there
+// is none; see TryGetNamed.
+func GetNamed(c *floki.Context, name string) *Session {
+	return c.MustGet(namedSessionKey(name)).(*Session)
+}
+
+// TryGetNamed returns the session of the request registered under name, or
+// false if there is none.
+func TryGetNamed(c *floki.Context, name string) (*Session, bool) {
+	v, err := c.Get(namedSessionKey(name))
+	if err != nil {
+		return nil, false
+	}
+	s, ok := v.(*Session)
+	return s, ok && s != nil
+}
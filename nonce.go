@@ -0,0 +1,39 @@
+package sessions
+
+import (
+	"crypto/subtle"
+)
+
+// noncePrefix prefixes the session keys of the nonces issued by IssueNonce.
+const noncePrefix = "_nonce."
+
+// IssueNonce returns a new random nonce for purpose, such as "oauth-state"
+// or "confirm-email", and stores it in the session, replacing any previous
+// nonce for the same purpose. It returns ErrReadOnly for read-only
+// sessions, which can't store it.
+func (s *Session) IssueNonce(purpose string) (string, error) {
+	s.loadLazy()
+	if s.readOnly {
+		return "", ErrReadOnly
+	}
+	nonce, err := RandomIDGenerator(32).NewID()
+	if err != nil {
+		return "", err
+	}
+	s.Set(noncePrefix+purpose, nonce)
+	return nonce, nil
+}
+
+// ConsumeNonce reports whether value is the nonce issued for purpose. The
+// nonce is removed from the session whatever the outcome, so it can't be
+// replayed or guessed by repeated attempts. Nonces can't be removed from
+// read-only sessions, so ConsumeNonce always reports false for them.
+func (s *Session) ConsumeNonce(purpose, value string) bool {
+	stored, ok := s.Get(noncePrefix + purpose).(string)
+	if !ok || s.readOnly {
+		return false
+	}
+	s.Delete(noncePrefix + purpose)
+	return value != "" &&
+		subtle.ConstantTimeCompare([]byte(stored), []byte(value)) == 1
+}
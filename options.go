@@ -0,0 +1,108 @@
+package sessions
+
+import (
+	"github.com/go-floki/floki"
+	"time"
+)
+
+// DefaultName is the name of the session cookie of the middleware returned
+// by New without WithName.
+const DefaultName = "session"
+
+// middleware is what the Options of New configure.
+type middleware struct {
+	name   string
+	config Config
+}
+
+// Option configures the middleware returned by New.
+type Option func(m *middleware)
+
+// New returns the sessions middleware for store, configured by opts:
+//
+//	f.Use(sessions.New(store,
+//		sessions.WithName("my_session"),
+//		sessions.WithIdleTimeout(30*time.Minute),
+//	))
+//
+// It is the same as SessionsWithConfig; new behaviors get new options
+// rather than new parameters. WithConfig reaches the fields of Config that
+// have no option of their own.
+func New(store Store, opts ...Option) floki.HandlerFunc {
+	m := &middleware{name: DefaultName}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return SessionsWithConfig(m.name, store, m.config)
+}
+
+// WithName sets the name of the session cookie. The default is DefaultName.
+func WithName(name string) Option {
+	return func(m *middleware) {
+		m.name = name
+	}
+}
+
+// WithCookieOptions sets Config.Options.
+func WithCookieOptions(options Options) Option {
+	return func(m *middleware) {
+		m.config.Options = &options
+	}
+}
+
+// WithIdleTimeout sets Config.IdleTimeout.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(m *middleware) {
+		m.config.IdleTimeout = timeout
+	}
+}
+
+// WithAbsoluteTimeout sets Config.AbsoluteTimeout.
+func WithAbsoluteTimeout(timeout time.Duration) Option {
+	return func(m *middleware) {
+		m.config.AbsoluteTimeout = timeout
+	}
+}
+
+// WithErrorHandler sets Config.OnError.
+func WithErrorHandler(fn func(c *floki.Context, err error) ErrAction) Option {
+	return func(m *middleware) {
+		m.config.OnError = fn
+	}
+}
+
+// WithSaveErrorHandler sets Config.OnSaveError.
+func WithSaveErrorHandler(fn func(c *floki.Context, s *Session, err error)) Option {
+	return func(m *middleware) {
+		m.config.OnSaveError = fn
+	}
+}
+
+// WithSkip adds patterns to Config.Skip.
+func WithSkip(patterns ...string) Option {
+	return func(m *middleware) {
+		m.config.Skip = append(m.config.Skip, patterns...)
+	}
+}
+
+// WithOnly adds patterns to Config.Only.
+func WithOnly(patterns ...string) Option {
+	return func(m *middleware) {
+		m.config.Only = append(m.config.Only, patterns...)
+	}
+}
+
+// WithLazyLoad sets Config.LazyLoad.
+func WithLazyLoad() Option {
+	return func(m *middleware) {
+		m.config.LazyLoad = true
+	}
+}
+
+// WithConfig calls fn with the Config of the middleware, for the fields
+// without an option of their own.
+func WithConfig(fn func(config *Config)) Option {
+	return func(m *middleware) {
+		fn(&m.config)
+	}
+}
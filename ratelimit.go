@@ -0,0 +1,137 @@
+package sessions
+
+import (
+	"github.com/garyburd/redigo/redis"
+	"github.com/go-floki/floki"
+	"net"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits how often an action is allowed for a key, such as
+// the creation of sessions by a client. See Config.CreationLimiter.
+type RateLimiter interface {
+	// Allow records an attempt for key and reports whether it is allowed.
+	Allow(key string) (bool, error)
+}
+
+// LimitByIP returns the IP address of the client, the default key of
+// Config.CreationLimiter. Behind a proxy, use a key function reading the
+// header the proxy sets instead.
+func LimitByIP(c *floki.Context) string {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		return c.Request.RemoteAddr
+	}
+	return host
+}
+
+// LimitByFingerprint returns a hash of the IP address and of the
+// DefaultFingerprint headers of the client, so clients sharing an address
+// are limited separately.
+func LimitByFingerprint(c *floki.Context) string {
+	return LimitByIP(c) + "." + fingerprint(c, DefaultFingerprint)
+}
+
+// allowCreation reports whether the new session s may be saved, consulting
+// the creation limiter of config. Limiter errors are logged and let the
+// session through.
+func allowCreation(c *floki.Context, s *Session, config *Config) bool {
+	if config.CreationLimiter == nil || !s.IsNew || !(s.dirty || s.rotate) {
+		return true
+	}
+	ok, err := config.CreationLimiter.Allow(config.CreationLimitKey(c))
+	if err != nil {
+		c.Logger().Println("error limiting session creation:", err)
+		return true
+	}
+	return ok
+}
+
+// MemoryRateLimiter is a RateLimiter kept in process memory, allowing a
+// fixed number of attempts per key in each window of time.
+type MemoryRateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	buckets map[string]*rateBucket
+	swept   time.Time
+}
+
+// rateBucket counts the attempts of a key in the current window.
+type rateBucket struct {
+	count int
+	reset time.Time
+}
+
+// NewMemoryRateLimiter returns a MemoryRateLimiter allowing limit attempts
+// per key every window.
+func NewMemoryRateLimiter(limit int, window time.Duration) *MemoryRateLimiter {
+	return &MemoryRateLimiter{
+		limit:   limit,
+		window:  window,
+		buckets: make(map[string]*rateBucket),
+	}
+}
+
+// Allow records an attempt for key and reports whether it is allowed.
+func (l *MemoryRateLimiter) Allow(key string) (bool, error) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.buckets[key]
+	if b == nil || now.After(b.reset) {
+		if now.Sub(l.swept) > l.window {
+			l.sweep(now)
+		}
+		b = &rateBucket{reset: now.Add(l.window)}
+		l.buckets[key] = b
+	}
+	b.count++
+	return b.count <= l.limit, nil
+}
+
+// sweep drops the buckets whose window is over, once per window at most,
+// so clients that stop coming don't pile up.
+func (l *MemoryRateLimiter) sweep(now time.Time) {
+	l.swept = now
+	for key, b := range l.buckets {
+		if now.After(b.reset) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RedisRateLimiter is a RateLimiter shared by every process using the same
+// redis server, allowing Limit attempts per key in each Window.
+type RedisRateLimiter struct {
+	Pool   *redis.Pool
+	Limit  int
+	Window time.Duration
+	// Prefix is prepended to keys. The default is "ratelimit_".
+	Prefix string
+}
+
+// NewRedisRateLimiter returns a RedisRateLimiter using pool, allowing
+// limit attempts per key every window.
+func NewRedisRateLimiter(pool *redis.Pool, limit int, window time.Duration) *RedisRateLimiter {
+	return &RedisRateLimiter{Pool: pool, Limit: limit, Window: window, Prefix: "ratelimit_"}
+}
+
+// Allow records an attempt for key and reports whether it is allowed.
+func (l *RedisRateLimiter) Allow(key string) (bool, error) {
+	conn := l.Pool.Get()
+	defer conn.Close()
+	n, err := redis.Int(conn.Do("INCR", l.Prefix+key))
+	if err != nil {
+		return false, err
+	}
+	if n == 1 {
+		// First attempt of the window; start it.
+		seconds := int((l.Window + time.Second - 1) / time.Second)
+		if _, err := conn.Do("EXPIRE", l.Prefix+key, seconds); err != nil {
+			return false, err
+		}
+	}
+	return n <= l.Limit, nil
+}
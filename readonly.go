@@ -0,0 +1,42 @@
+package sessions
+
+import (
+	"errors"
+	"github.com/go-floki/floki"
+)
+
+// ErrReadOnly is returned by Save for read-only sessions, see SetReadOnly.
+var ErrReadOnly = errors.New("sessions: session is read-only")
+
+// SetReadOnly makes the session read-only, or writable again, for the rest
+// of the request. The values of a read-only session can be read, but Set,
+// Delete and the other methods writing them do nothing, Save returns
+// ErrReadOnly and neither the middleware nor Registry.Save save it, so
+// neither its cookie nor its record are touched: Destroy and SetOptions
+// return ErrReadOnly and SetMaxAge does nothing. IssueNonce returns
+// ErrReadOnly and ConsumeNonce rejects every nonce, as they can't be
+// recorded.
+func (s *Session) SetReadOnly(readOnly bool) {
+	s.readOnly = readOnly
+}
+
+// IsReadOnly reports whether the session is read-only, see SetReadOnly.
+func (s *Session) IsReadOnly() bool {
+	return s.readOnly
+}
+
+// ReadOnly returns a handler making the session of the request read-only,
+// for the routes it is registered with:
+//
+//	f.GET("/api/profile", sessions.ReadOnly(), profile)
+//
+// With SessionsNamed, the first session is made read-only; use
+// GetNamed(c, name).SetReadOnly for the others.
+func ReadOnly() floki.HandlerFunc {
+	return func(c *floki.Context) {
+		if s, ok := TryGet(c); ok {
+			s.SetReadOnly(true)
+		}
+		c.Next()
+	}
+}
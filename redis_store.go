@@ -5,16 +5,12 @@
 package sessions
 
 import (
-	"bytes"
-	"encoding/base32"
-	"encoding/gob"
-	"errors"
+	"context"
 	"fmt"
 	"github.com/garyburd/redigo/redis"
 	"github.com/go-floki/floki"
 	"github.com/gorilla/securecookie"
 	"net/http"
-	"strings"
 	"time"
 )
 
@@ -22,12 +18,30 @@ import (
 var sessionExpire = 86400 * 30
 
 // RediStore stores sessions in a redis backend.
+//
+// Redis drops expired records itself, so the store can't tell an expired
+// session from a deleted one: the functions registered with OnExpire are
+// called, with nil values, whenever a request presents the cookie of a
+// session that has no record, including sessions deleted with DeleteID,
+// DeleteByUserID or Destroy. They are not called for sessions that are
+// never presented again.
 type RediStore struct {
 	Pool          *redis.Pool
 	Codecs        []securecookie.Codec
-	Options       *Options // default configuration
-	DefaultMaxAge int      // default Redis TTL for a MaxAge == 0 session
+	Options       *Options    // default configuration
+	DefaultMaxAge int         // default Redis TTL for a MaxAge == 0 session
+	ValueCodec    Codec       // serializes Values; gob when nil
+	LazyDecode    bool        // defer decoding Values until first use, see Session.Load
+	IDs           IDGenerator // creates session IDs; DefaultIDGenerator when nil
 	maxLength     int
+	transforms    transformChain
+
+	expireListeners
+}
+
+// AddTransform appends t to the transforms applied to values stored in redis.
+func (s *RediStore) AddTransform(t PayloadTransform) {
+	s.transforms.add(t)
 }
 
 // SetMaxLength sets RediStore.maxLength if the `l` argument is greater or equal 0
@@ -53,13 +67,18 @@ func (s *RediStore) SetMaxLength(l int) {
 // Because we use `MaxAge` also in SecureCookie crypting algorithm you should
 // use this function to change `MaxAge` value.
 func (s *RediStore) SetMaxAge(v int) {
-	var c *securecookie.SecureCookie
-	var ok bool
 	s.Options.MaxAge = v
 	for i := range s.Codecs {
-		if c, ok = s.Codecs[i].(*securecookie.SecureCookie); ok {
+		switch c := s.Codecs[i].(type) {
+		case *securecookie.SecureCookie:
+			c.MaxAge(v)
+		case *SecureCodec:
+			c.MaxAge(v)
+		case *GCMCodec:
 			c.MaxAge(v)
-		} else {
+		case *KeyRing:
+			c.MaxAge(v)
+		default:
 			fmt.Printf("Can't change MaxAge on codec %t\n", s.Codecs[i])
 		}
 	}
@@ -121,8 +140,9 @@ func NewRediStoreWithPool(pool *redis.Pool, keyPairs ...[]byte) (*RediStore, err
 		Pool:   pool,
 		Codecs: securecookie.CodecsFromPairs(keyPairs...),
 		Options: &Options{
-			Path:   "/",
-			MaxAge: sessionExpire,
+			Path:     "/",
+			MaxAge:   sessionExpire,
+			SameSite: http.SameSiteLaxMode,
 		},
 		DefaultMaxAge: 60 * 20, // 20 minutes seems like a reasonable default
 		maxLength:     4096,
@@ -154,9 +174,10 @@ func (s *RediStore) New(c *floki.Context, name string) (*Session, error) {
 	session.Options = &options
 	session.IsNew = true
 	if cookie, errCookie := c.Request.Cookie(name); errCookie == nil {
-		err = securecookie.DecodeMulti(name, cookie.Value, &session.ID, s.Codecs...)
+		err = decodeError(securecookie.DecodeMulti(name, cookie.Value, &session.ID, s.Codecs...))
 		if err == nil {
-			ok, err := s.load(session)
+			var ok bool
+			ok, err = s.load(session)
 			session.IsNew = !(err == nil && ok) // not new if no error and data available
 		}
 	}
@@ -177,9 +198,12 @@ func (s *RediStore) Save(c *floki.Context, session *Session) error {
 		}
 		http.SetCookie(c.Writer, NewCookie(session.Name(), "", session.Options))
 	} else {
-		// Build an alphanumeric key for the redis store.
 		if session.ID == "" {
-			session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+			id, err := idGeneratorOrDefault(s.IDs).NewID()
+			if err != nil {
+				return err
+			}
+			session.ID = id
 		}
 		if err := s.save(session); err != nil {
 			return err
@@ -227,15 +251,19 @@ func (s *RediStore) ping() (bool, error) {
 
 // save stores the session in redis.
 func (s *RediStore) save(session *Session) error {
-	buf := new(bytes.Buffer)
-	enc := gob.NewEncoder(buf)
-	err := enc.Encode(session.Values)
+	if err := session.Load(); err != nil {
+		return err
+	}
+	b, err := codecOrGob(s.ValueCodec).Marshal(session.Values)
+	if err != nil {
+		return err
+	}
+	b, err = s.transforms.encode(session, b)
 	if err != nil {
 		return err
 	}
-	b := buf.Bytes()
 	if s.maxLength != 0 && len(b) > s.maxLength {
-		return errors.New("SessionStore: the value to store is too big")
+		return ErrSessionTooLarge
 	}
 
 	conn := s.Pool.Get()
@@ -247,7 +275,121 @@ func (s *RediStore) save(session *Session) error {
 	if age == 0 {
 		age = s.DefaultMaxAge
 	}
-	_, err = conn.Do("SETEX", "session_"+session.ID, age, b)
+	if _, err = conn.Do("SETEX", "session_"+session.ID, age, b); err != nil {
+		return err
+	}
+	// Move the session out of the index of the user it was saved for
+	// before, on logout or when another user logs in with it.
+	userID := sessionUserID(session)
+	owner, err := redis.String(conn.Do("GET", ownerKey(session.ID)))
+	if err != nil && err != redis.ErrNil {
+		return err
+	}
+	if owner != "" && owner != userID {
+		if _, err = conn.Do("SREM", "user_sessions_"+owner, session.ID); err != nil {
+			return err
+		}
+		if userID == "" {
+			if _, err = conn.Do("DEL", ownerKey(session.ID)); err != nil {
+				return err
+			}
+		}
+	}
+	if userID != "" {
+		key := "user_sessions_" + userID
+		if _, err = conn.Do("SADD", key, session.ID); err != nil {
+			return err
+		}
+		if _, err = conn.Do("SETEX", ownerKey(session.ID), age, userID); err != nil {
+			return err
+		}
+		// Keep the index at least as long as the session.
+		ttl, err := redis.Int(conn.Do("TTL", key))
+		if err != nil {
+			return err
+		}
+		if ttl < age {
+			if _, err = conn.Do("EXPIRE", key, age); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ownerKey returns the redis key of the user whose index lists the session
+// id.
+func ownerKey(id string) string {
+	return "user_of_session_" + id
+}
+
+// Touch refreshes the TTL of the record of session with EXPIRE and
+// reissues its cookie. A record that expired in the meantime is saved again.
+func (s *RediStore) Touch(c *floki.Context, session *Session) error {
+	age := session.Options.MaxAge
+	if age == 0 {
+		age = s.DefaultMaxAge
+	}
+	conn := s.Pool.Get()
+	defer conn.Close()
+	refreshed, err := redis.Bool(conn.Do("EXPIRE", "session_"+session.ID, age))
+	if err != nil {
+		return err
+	}
+	if !refreshed {
+		if err := s.save(session); err != nil {
+			return err
+		}
+	} else if _, err := conn.Do("EXPIRE", ownerKey(session.ID), age); err != nil {
+		return err
+	}
+	return writeIDCookie(c, session, s.Codecs...)
+}
+
+// UserSessionIDs returns the IDs of the sessions of userID, dropping the
+// ones that expired from the index.
+func (s *RediStore) UserSessionIDs(userID string) ([]string, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+	key := "user_sessions_" + userID
+	ids, err := redis.Strings(conn.Do("SMEMBERS", key))
+	if err != nil {
+		return nil, err
+	}
+	live := ids[:0]
+	for _, id := range ids {
+		exists, err := redis.Bool(conn.Do("EXISTS", "session_"+id))
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			live = append(live, id)
+		} else if _, err := conn.Do("SREM", key, id); err != nil {
+			return nil, err
+		}
+	}
+	return live, nil
+}
+
+// DeleteByUserID deletes every session of userID and the index of the
+// user.
+func (s *RediStore) DeleteByUserID(ctx context.Context, userID string) error {
+	conn := s.Pool.Get()
+	defer conn.Close()
+	key := "user_sessions_" + userID
+	ids, err := redis.Strings(conn.Do("SMEMBERS", key))
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := conn.Do("DEL", "session_"+id, ownerKey(id)); err != nil {
+			return err
+		}
+	}
+	_, err = conn.Do("DEL", key)
 	return err
 }
 
@@ -264,22 +406,46 @@ func (s *RediStore) load(session *Session) (bool, error) {
 		return false, err
 	}
 	if data == nil {
-		return false, nil // no data was associated with this key
+		// No data was associated with this key: redis expired it, or
+		// it was deleted.
+		s.expired(session.ID, nil)
+		return false, nil
 	}
 	b, err := redis.Bytes(data, err)
 	if err != nil {
 		return false, err
 	}
-	dec := gob.NewDecoder(bytes.NewBuffer(b))
-	return true, dec.Decode(&session.Values)
+	if b, err = s.transforms.decode(session, b); err != nil {
+		return false, malformed(err)
+	}
+	if s.LazyDecode {
+		session.setRaw(b, codecOrGob(s.ValueCodec))
+		return true, nil
+	}
+	return true, malformed(codecOrGob(s.ValueCodec).Unmarshal(b, session.Values))
 }
 
 // delete removes keys from redis if MaxAge<0
 func (s *RediStore) delete(session *Session) error {
+	return s.DeleteID(session.ID)
+}
+
+// DeleteID removes the redis key of the session ID.
+func (s *RediStore) DeleteID(id string) error {
 	conn := s.Pool.Get()
 	defer conn.Close()
-	if _, err := conn.Do("DEL", "session_"+session.ID); err != nil {
+	if _, err := conn.Do("DEL", "session_"+id); err != nil {
 		return err
 	}
-	return nil
+	owner, err := redis.String(conn.Do("GET", ownerKey(id)))
+	if err == redis.ErrNil {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if _, err := conn.Do("SREM", "user_sessions_"+owner, id); err != nil {
+		return err
+	}
+	_, err = conn.Do("DEL", ownerKey(id))
+	return err
 }
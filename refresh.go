@@ -0,0 +1,42 @@
+package sessions
+
+import (
+	"github.com/go-floki/floki"
+	"time"
+)
+
+// cookieIssuedKey is the session key of the time the middleware last
+// issued the session cookie, in unix seconds, for
+// Config.CookieRefreshAfter.
+const cookieIssuedKey = "_cookie_issued"
+
+// recordSaver is implemented by the stores keeping sessions server-side,
+// whose records can be saved without reissuing the cookie.
+type recordSaver interface {
+	save(session *Session) error
+}
+
+// saveRecordOnly saves s, changed by the middleware but not by the
+// handlers, without reissuing its cookie when it was issued less than
+// after of its MaxAge ago. It reports whether s was saved; sessions of
+// stores keeping the values in the cookie never are. Save errors are passed
+// to onSaveError.
+func saveRecordOnly(c *floki.Context, s *Session, after float64, onSaveError func(*floki.Context, *Session, error)) bool {
+	if s.IsNew || s.rotate || s.Options.MaxAge <= 0 {
+		return false
+	}
+	saver, ok := s.store.(recordSaver)
+	if !ok {
+		return false
+	}
+	lifetime := time.Duration(s.Options.MaxAge) * time.Second
+	issued, ok := unixTime(s.Get(cookieIssuedKey))
+	if !ok || time.Since(issued) >= time.Duration(after*float64(lifetime)) {
+		return false
+	}
+	if err := saver.save(s); err != nil {
+		onSaveError(c, s, err)
+	}
+	s.dirty = false
+	return true
+}
@@ -0,0 +1,298 @@
+package sessions
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"github.com/garyburd/redigo/redis"
+	"github.com/go-floki/floki"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rememberedKey is the session key marking sessions re-created from a
+// remember-me token.
+const rememberedKey = "_remembered"
+
+// ErrNoRememberMe is returned by Remember and Forget when the middleware
+// has no Config.RememberMe, or is not installed for the route. With
+// SessionsNamed, the Config of the first session applies.
+var ErrNoRememberMe = errors.New("sessions: remember-me is not configured")
+
+// RememberToken is a persistent login token, stored apart from sessions.
+//
+// Its cookie holds the selector, which identifies the token and never
+// changes, and a validator replaced every time the token is used. Only a
+// hash of the validator is stored. A cookie whose selector is known but
+// whose validator does not match is a copy of the token used after the
+// legitimate one, so the whole token is revoked.
+type RememberToken struct {
+	Selector      string
+	ValidatorHash []byte
+	UserID        string
+	Expires       time.Time
+}
+
+// RememberStore persists remember-me tokens.
+type RememberStore interface {
+	// Save creates or replaces the token t.
+	Save(t *RememberToken) error
+
+	// Get returns the token with the given selector, or nil if there is
+	// none.
+	Get(selector string) (*RememberToken, error)
+
+	// Delete removes the token with the given selector.
+	Delete(selector string) error
+}
+
+// RememberConfig configures the remember-me tokens of the middleware. See
+// Config.RememberMe.
+type RememberConfig struct {
+	// Store persists the tokens.
+	Store RememberStore
+
+	// CookieName is the name of the token cookie. The default is
+	// "remember".
+	CookieName string
+
+	// Options are the options of the token cookie. The default is like
+	// the session cookie, but lasting 30 days; MaxAge is the lifetime of
+	// the tokens.
+	Options *Options
+
+	// OnTheft is called when a stolen token is detected, after the token
+	// is revoked, with the user it belonged to. The user should be warned
+	// and their other sessions destroyed, for instance with
+	// DestroyAllForUser.
+	OnTheft func(c *floki.Context, userID string)
+}
+
+// Remember issues a remember-me token for userID and sets its cookie. Call
+// it on login when the user asks to stay signed in; the middleware then
+// re-creates the session from the token once it expires.
+func Remember(c *floki.Context, userID string) error {
+	config := contextRemember(c)
+	if config == nil {
+		return ErrNoRememberMe
+	}
+	selector, err := RandomIDGenerator(16).NewID()
+	if err != nil {
+		return err
+	}
+	t := &RememberToken{
+		Selector: selector,
+		UserID:   userID,
+		Expires:  time.Now().Add(time.Duration(config.Options.MaxAge) * time.Second),
+	}
+	return config.issue(c, t)
+}
+
+// Forget revokes the remember-me token of the request, if any, and removes
+// its cookie. Call it on logout.
+func Forget(c *floki.Context) error {
+	config := contextRemember(c)
+	if config == nil {
+		return ErrNoRememberMe
+	}
+	return config.forget(c)
+}
+
+// contextRemember returns the RememberConfig of the session of the request,
+// or nil if it has none.
+func contextRemember(c *floki.Context) *RememberConfig {
+	s, ok := TryGet(c)
+	if !ok {
+		return nil
+	}
+	s.loadLazy()
+	return s.remember
+}
+
+// forget revokes the token of the request, if any, and removes its cookie.
+func (rc *RememberConfig) forget(c *floki.Context) error {
+	rc.clear(c)
+	if selector, _, ok := rc.cookie(c); ok {
+		return rc.Store.Delete(selector)
+	}
+	return nil
+}
+
+// cookie returns the selector and validator of the token cookie of the
+// request.
+func (rc *RememberConfig) cookie(c *floki.Context) (selector, validator string, ok bool) {
+	cookie, err := c.Request.Cookie(rc.CookieName)
+	if err != nil {
+		return "", "", false
+	}
+	parts := strings.SplitN(cookie.Value, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// issue gives t a new validator, saves it and sets its cookie.
+func (rc *RememberConfig) issue(c *floki.Context, t *RememberToken) error {
+	validator, err := RandomIDGenerator(32).NewID()
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(validator))
+	t.ValidatorHash = sum[:]
+	if err := rc.Store.Save(t); err != nil {
+		return err
+	}
+	options := *rc.Options
+	options.MaxAge = int(time.Until(t.Expires) / time.Second)
+	http.SetCookie(c.Writer, NewCookie(rc.CookieName, t.Selector+":"+validator, &options))
+	return nil
+}
+
+// clear removes the token cookie.
+func (rc *RememberConfig) clear(c *floki.Context) {
+	options := *rc.Options
+	options.MaxAge = -1
+	http.SetCookie(c.Writer, NewCookie(rc.CookieName, "", &options))
+}
+
+// restore re-creates the session s of a request without a logged in user
+// from its remember-me token, rotating the token. Store errors are logged
+// and leave the session anonymous.
+func (rc *RememberConfig) restore(c *floki.Context, s *Session) {
+	if sessionUserID(s) != "" {
+		return
+	}
+	selector, validator, ok := rc.cookie(c)
+	if !ok {
+		return
+	}
+	t, err := rc.Store.Get(selector)
+	if err != nil {
+		c.Logger().Println("error loading remember-me token:", err)
+		return
+	}
+	if t == nil || time.Now().After(t.Expires) {
+		rc.clear(c)
+		return
+	}
+	sum := sha256.Sum256([]byte(validator))
+	if subtle.ConstantTimeCompare(sum[:], t.ValidatorHash) != 1 {
+		if err := rc.Store.Delete(selector); err != nil {
+			c.Logger().Println("error revoking remember-me token:", err)
+		}
+		rc.clear(c)
+		audit(c, s, AuditBindingViolation, "remember-me token reuse")
+		if rc.OnTheft != nil {
+			rc.OnTheft(c, t.UserID)
+		}
+		return
+	}
+	if err := rc.issue(c, t); err != nil {
+		c.Logger().Println("error rotating remember-me token:", err)
+		return
+	}
+	s.SetAuthenticated(t.UserID)
+	s.Set(rememberedKey, true)
+}
+
+// Remembered reports whether the session was re-created from a remember-me
+// token rather than by a login. Such sessions should not be trusted for
+// sensitive operations without re-authentication, see StepUp.
+func (s *Session) Remembered() bool {
+	remembered, _ := s.Get(rememberedKey).(bool)
+	return remembered
+}
+
+// MemoryRememberStore is a RememberStore kept in process memory.
+type MemoryRememberStore struct {
+	mu     sync.RWMutex
+	tokens map[string]RememberToken
+}
+
+// NewMemoryRememberStore returns an empty MemoryRememberStore.
+func NewMemoryRememberStore() *MemoryRememberStore {
+	return &MemoryRememberStore{tokens: make(map[string]RememberToken)}
+}
+
+// Save creates or replaces the token t.
+func (m *MemoryRememberStore) Save(t *RememberToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[t.Selector] = *t
+	return nil
+}
+
+// Get returns the token with the given selector, or nil if there is none.
+func (m *MemoryRememberStore) Get(selector string) (*RememberToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.tokens[selector]
+	if !ok {
+		return nil, nil
+	}
+	return &t, nil
+}
+
+// Delete removes the token with the given selector.
+func (m *MemoryRememberStore) Delete(selector string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.tokens, selector)
+	return nil
+}
+
+// RedisRememberStore is a RememberStore keeping tokens in redis as JSON,
+// expiring with them.
+type RedisRememberStore struct {
+	Pool *redis.Pool
+	// Prefix is prepended to selectors to build keys. The default is
+	// "remember_".
+	Prefix string
+}
+
+// NewRedisRememberStore returns a RedisRememberStore using pool.
+func NewRedisRememberStore(pool *redis.Pool) *RedisRememberStore {
+	return &RedisRememberStore{Pool: pool, Prefix: "remember_"}
+}
+
+// Save creates or replaces the token t.
+func (r *RedisRememberStore) Save(t *RememberToken) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	seconds := int(time.Until(t.Expires)/time.Second) + 1
+	conn := r.Pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("SETEX", r.Prefix+t.Selector, seconds, b)
+	return err
+}
+
+// Get returns the token with the given selector, or nil if there is none.
+func (r *RedisRememberStore) Get(selector string) (*RememberToken, error) {
+	conn := r.Pool.Get()
+	defer conn.Close()
+	b, err := redis.Bytes(conn.Do("GET", r.Prefix+selector))
+	if err == redis.ErrNil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	t := &RememberToken{}
+	if err := json.Unmarshal(b, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Delete removes the token with the given selector.
+func (r *RedisRememberStore) Delete(selector string) error {
+	conn := r.Pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("DEL", r.Prefix+selector)
+	return err
+}
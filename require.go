@@ -0,0 +1,107 @@
+package sessions
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrRequired is matched, with errors.Is, by the errors of the Require
+// accessors.
+var ErrRequired = errors.New("sessions: required value missing")
+
+// RequiredError is returned by the Require accessors when a mandatory value
+// is not set, or when Type is not empty, is not of that type. Handlers
+// usually answer it with 401 or 400.
+type RequiredError struct {
+	Key  interface{}
+	Type string
+}
+
+func (e *RequiredError) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("sessions: required value %v is missing", e.Key)
+	}
+	return fmt.Sprintf("sessions: required value %v is not a %s", e.Key, e.Type)
+}
+
+// Is reports whether target is ErrRequired.
+func (e *RequiredError) Is(target error) bool {
+	return target == ErrRequired
+}
+
+// required returns the RequiredError of key, expected to be of type typ.
+func (s *Session) required(key interface{}, typ string) error {
+	if !s.Has(key) {
+		typ = ""
+	}
+	return &RequiredError{Key: key, Type: typ}
+}
+
+// Require returns the value stored under key, or a RequiredError if it is
+// not set.
+func (s *Session) Require(key interface{}) (interface{}, error) {
+	v := s.Get(key)
+	if v == nil {
+		return nil, s.required(key, "")
+	}
+	return v, nil
+}
+
+// RequireString is like Require for the values of GetString.
+func (s *Session) RequireString(key interface{}) (string, error) {
+	if v, ok := s.GetString(key); ok {
+		return v, nil
+	}
+	return "", s.required(key, "string")
+}
+
+// RequireInt is like Require for the values of GetInt.
+func (s *Session) RequireInt(key interface{}) (int, error) {
+	if v, ok := s.GetInt(key); ok {
+		return v, nil
+	}
+	return 0, s.required(key, "integer")
+}
+
+// RequireInt64 is like Require for the values of GetInt64.
+func (s *Session) RequireInt64(key interface{}) (int64, error) {
+	if v, ok := s.GetInt64(key); ok {
+		return v, nil
+	}
+	return 0, s.required(key, "integer")
+}
+
+// RequireBool is like Require for the values of GetBool.
+func (s *Session) RequireBool(key interface{}) (bool, error) {
+	if v, ok := s.GetBool(key); ok {
+		return v, nil
+	}
+	return false, s.required(key, "boolean")
+}
+
+// RequireFloat is like Require for the values of GetFloat.
+func (s *Session) RequireFloat(key interface{}) (float64, error) {
+	if v, ok := s.GetFloat(key); ok {
+		return v, nil
+	}
+	return 0, s.required(key, "number")
+}
+
+// RequireTime is like Require for the values of GetTime.
+func (s *Session) RequireTime(key interface{}) (time.Time, error) {
+	if v, ok := s.GetTime(key); ok {
+		return v, nil
+	}
+	return time.Time{}, s.required(key, "time")
+}
+
+// RequireUserID returns the user set by SetAuthenticated, or a
+// RequiredError if the session is anonymous.
+func (s *Session) RequireUserID() (string, error) {
+	s.Load()
+	if id := sessionUserID(s); id != "" {
+		return id, nil
+	}
+	return "", s.required(userIDKey, "")
+}
@@ -0,0 +1,124 @@
+package sessions
+
+import (
+	"encoding/base32"
+	"github.com/garyburd/redigo/redis"
+	"github.com/go-floki/floki"
+	"github.com/gorilla/securecookie"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jtiKey is the session key of the random identifier given to sessions
+// without a server-side ID, such as cookie-stored ones.
+const jtiKey = "_jti"
+
+// RevocationList records revoked sessions, so sessions stored in cookies can
+// be invalidated before they expire. The middleware consults it on load
+// when set in Config.Revocations.
+type RevocationList interface {
+	// Revoke marks the session identified by id as revoked for ttl, which
+	// should be at least the remaining lifetime of the session.
+	Revoke(id string, ttl time.Duration) error
+
+	// IsRevoked reports whether the session identified by id is revoked.
+	IsRevoked(id string) (bool, error)
+}
+
+// RevocationID returns the identifier under which s is known to a
+// RevocationList: its ID for server-side stores, or the random identifier
+// the middleware stores in it otherwise. It is empty for a session that has
+// neither yet.
+func (s *Session) RevocationID() string {
+	s.loadLazy()
+	if s.ID != "" {
+		return s.ID
+	}
+	jti, _ := s.Get(jtiKey).(string)
+	return jti
+}
+
+// checkRevoked invalidates s if it is revoked in list, and gives sessions of
+// stores without server-side records a random identifier.
+func checkRevoked(c *floki.Context, s *Session, list RevocationList) error {
+	if id := s.RevocationID(); id != "" {
+		revoked, err := list.IsRevoked(id)
+		if err != nil {
+			return err
+		}
+		if !revoked {
+			return nil
+		}
+		audit(c, s, AuditRevoked, "")
+		s.invalidate()
+	}
+	if _, ok := s.store.(Deleter); !ok && s.Get(jtiKey) == nil {
+		s.Set(jtiKey, strings.TrimRight(
+			base32.StdEncoding.EncodeToString(
+				securecookie.GenerateRandomKey(16)), "="))
+	}
+	return nil
+}
+
+// MemoryRevocationList is a RevocationList kept in process memory.
+type MemoryRevocationList struct {
+	mu      sync.RWMutex
+	revoked map[string]time.Time
+}
+
+// NewMemoryRevocationList returns an empty MemoryRevocationList.
+func NewMemoryRevocationList() *MemoryRevocationList {
+	return &MemoryRevocationList{revoked: make(map[string]time.Time)}
+}
+
+// Revoke marks id as revoked for ttl. Expired entries are dropped on the
+// way.
+func (l *MemoryRevocationList) Revoke(id string, ttl time.Duration) error {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k, expires := range l.revoked {
+		if now.After(expires) {
+			delete(l.revoked, k)
+		}
+	}
+	l.revoked[id] = now.Add(ttl)
+	return nil
+}
+
+// IsRevoked reports whether id is revoked.
+func (l *MemoryRevocationList) IsRevoked(id string) (bool, error) {
+	l.mu.RLock()
+	expires, ok := l.revoked[id]
+	l.mu.RUnlock()
+	return ok && time.Now().Before(expires), nil
+}
+
+// RedisRevocationList is a RevocationList kept in redis, shared by every
+// process using the same server.
+type RedisRevocationList struct {
+	Pool   *redis.Pool
+	Prefix string // prefix of the redis keys, "revoked_" by default
+}
+
+// NewRedisRevocationList returns a RedisRevocationList using pool.
+func NewRedisRevocationList(pool *redis.Pool) *RedisRevocationList {
+	return &RedisRevocationList{Pool: pool, Prefix: "revoked_"}
+}
+
+// Revoke marks id as revoked for ttl, rounded up to the second.
+func (l *RedisRevocationList) Revoke(id string, ttl time.Duration) error {
+	conn := l.Pool.Get()
+	defer conn.Close()
+	seconds := int((ttl + time.Second - 1) / time.Second)
+	_, err := conn.Do("SETEX", l.Prefix+id, seconds, 1)
+	return err
+}
+
+// IsRevoked reports whether id is revoked.
+func (l *RedisRevocationList) IsRevoked(id string) (bool, error) {
+	conn := l.Pool.Get()
+	defer conn.Close()
+	return redis.Bool(conn.Do("EXISTS", l.Prefix+id))
+}
@@ -0,0 +1,68 @@
+package sessions
+
+import (
+	"sort"
+	"strings"
+)
+
+// Scope is a view of the values of a session whose keys share a prefix, so
+// independent features, such as authentication, a cart or a wizard, can
+// share one session without their keys colliding.
+type Scope struct {
+	session *Session
+	prefix  string
+}
+
+// Scope returns the view of the values of s stored under keys prefixed
+// with name and a dot: Scope("cart").Set("items", v) stores v under
+// "cart.items".
+func (s *Session) Scope(name string) *Scope {
+	return &Scope{session: s, prefix: name + "."}
+}
+
+// Scope returns a view nested in sc.
+func (sc *Scope) Scope(name string) *Scope {
+	return &Scope{session: sc.session, prefix: sc.prefix + name + "."}
+}
+
+// Get returns the value stored under key in the scope.
+func (sc *Scope) Get(key string) interface{} {
+	return sc.session.Get(sc.prefix + key)
+}
+
+// Set stores val under key in the scope.
+func (sc *Scope) Set(key string, val interface{}) {
+	sc.session.Set(sc.prefix+key, val)
+}
+
+// Delete removes the value stored under key in the scope.
+func (sc *Scope) Delete(key string) {
+	sc.session.Delete(sc.prefix + key)
+}
+
+// Has reports whether a value is stored under key in the scope.
+func (sc *Scope) Has(key string) bool {
+	return sc.session.Has(sc.prefix + key)
+}
+
+// Keys returns the sorted keys of the scope, without its prefix. Keys of
+// nested scopes are included with their own prefix.
+func (sc *Scope) Keys() []string {
+	sc.session.Load()
+	var keys []string
+	for k := range sc.session.Values {
+		if k, ok := k.(string); ok && strings.HasPrefix(k, sc.prefix) {
+			keys = append(keys, strings.TrimPrefix(k, sc.prefix))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Clear removes every value of the scope, including the ones of nested
+// scopes, leaving the rest of the session alone.
+func (sc *Scope) Clear() {
+	for _, k := range sc.Keys() {
+		sc.Delete(k)
+	}
+}
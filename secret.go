@@ -0,0 +1,70 @@
+package sessions
+
+import (
+	"encoding/base64"
+	"errors"
+)
+
+// ErrNoSecretKeys is returned by Session.SetSecret and Session.GetSecret
+// when the middleware has no Config.SecretKeys.
+var ErrNoSecretKeys = errors.New("sessions: no secret keys configured")
+
+// secretValue is the envelope sealed by SetSecret, so values keep their
+// type through gob.
+type secretValue struct {
+	V interface{}
+}
+
+func init() {
+	RegisterType(secretValue{})
+}
+
+// SetSecret stores val under key encrypted with AES-GCM, using the keys of
+// Config.SecretKeys, so it stays confidential when the rest of the session
+// is stored or logged in plaintext. Only the marked values pay the cost of
+// encryption; read them back with GetSecret.
+//
+// Values are serialized with gob, so their types must be registered with
+// RegisterType like for the default codec.
+func (s *Session) SetSecret(key, val interface{}) error {
+	s.loadLazy()
+	if s.secrets == nil {
+		return ErrNoSecretKeys
+	}
+	plain, err := GobSerializer{}.Serialize(secretValue{val})
+	if err != nil {
+		return err
+	}
+	sealed, err := s.secrets.Encode(plain)
+	if err != nil {
+		return err
+	}
+	s.Set(key, base64.RawURLEncoding.EncodeToString(sealed))
+	return nil
+}
+
+// GetSecret returns the value stored under key by SetSecret, or nil if
+// there is none.
+func (s *Session) GetSecret(key interface{}) (interface{}, error) {
+	s.loadLazy()
+	if s.secrets == nil {
+		return nil, ErrNoSecretKeys
+	}
+	v, ok := s.Get(key).(string)
+	if !ok {
+		return nil, nil
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil || len(sealed) < 2 || sealed[0] != payloadMagic {
+		return nil, ErrDecrypt
+	}
+	plain, err := s.secrets.Decode(sealed)
+	if err != nil {
+		return nil, err
+	}
+	var sv secretValue
+	if err := (GobSerializer{}).Deserialize(plain, &sv); err != nil {
+		return nil, err
+	}
+	return sv.V, nil
+}
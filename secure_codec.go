@@ -0,0 +1,321 @@
+package sessions
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/securecookie"
+	"golang.org/x/crypto/blake2b"
+	"hash"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errHashKeyNotSet  = errors.New("sessions: hash key is not set")
+	errValueTooLong   = errors.New("sessions: the value is too long")
+	errMacInvalid     = ErrMACInvalid
+	errTimestampValue = errors.New("sessions: invalid timestamp")
+	errTimestampNew   = errors.New("sessions: timestamp is too new")
+	errTimestampOld   = ErrExpired
+	errDecryption     = errors.New("sessions: the value could not be decrypted")
+)
+
+// Serializer encodes and decodes the values signed by SecureCodec.
+type Serializer interface {
+	Serialize(src interface{}) ([]byte, error)
+	Deserialize(src []byte, dst interface{}) error
+}
+
+// GobSerializer serializes values with encoding/gob, like securecookie does
+// by default.
+type GobSerializer struct{}
+
+func (GobSerializer) Serialize(src interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(src); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobSerializer) Deserialize(src []byte, dst interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(src)).Decode(dst)
+}
+
+// JSONSerializer serializes values with encoding/json. It can't encode the
+// map[interface{}]interface{} of Session.Values; pair it with a store
+// ValueCodec such as JSONCodec, which hands it plain bytes.
+type JSONSerializer struct{}
+
+func (JSONSerializer) Serialize(src interface{}) ([]byte, error) {
+	return json.Marshal(src)
+}
+
+func (JSONSerializer) Deserialize(src []byte, dst interface{}) error {
+	return json.Unmarshal(src, dst)
+}
+
+// MACAlgorithm is a hash function SecureCodec signs values with, using HMAC.
+type MACAlgorithm int
+
+// MAC algorithms supported by SecureCodec.
+const (
+	MACSHA256 MACAlgorithm = iota
+	MACSHA512_256
+	MACBLAKE2b
+)
+
+// macIDs identify the MAC algorithms in signed values. Values signed with
+// MACSHA256 carry no identifier, like the ones of securecookie.
+var macIDs = map[MACAlgorithm]string{
+	MACSHA512_256: "s5",
+	MACBLAKE2b:    "b2",
+}
+
+// hash returns the hash function of a.
+func (a MACAlgorithm) hash() func() hash.Hash {
+	switch a {
+	case MACSHA512_256:
+		return sha512.New512_256
+	case MACBLAKE2b:
+		return func() hash.Hash {
+			h, _ := blake2b.New256(nil)
+			return h
+		}
+	}
+	return sha256.New
+}
+
+// SecureCodec signs values with HMAC and optionally encrypts them with AES,
+// in the same format as gorilla/securecookie, so values encoded by either
+// can be decoded by the other when both use the same serializer.
+//
+// SecureCodec implements securecookie.Codec and can be used in the Codecs
+// field of every store.
+type SecureCodec struct {
+	hashKey    []byte
+	alg        MACAlgorithm
+	block      cipher.Block
+	maxAge     int64
+	minAge     int64
+	maxLength  int
+	serializer Serializer
+}
+
+// NewSecureCodec returns a SecureCodec signing with hashKey and, if blockKey
+// is not nil, encrypting with it.
+//
+// It is recommended to use a hash key of 32 or 64 bytes. The block key, if
+// set, must be either 16, 24, or 32 bytes to select AES-128, AES-192, or
+// AES-256.
+func NewSecureCodec(hashKey, blockKey []byte) (*SecureCodec, error) {
+	if hashKey == nil {
+		return nil, errHashKeyNotSet
+	}
+	s := &SecureCodec{
+		hashKey:    hashKey,
+		maxAge:     86400 * 30,
+		maxLength:  4096,
+		serializer: GobSerializer{},
+	}
+	if blockKey != nil {
+		block, err := aes.NewCipher(blockKey)
+		if err != nil {
+			return nil, err
+		}
+		s.block = block
+	}
+	return s, nil
+}
+
+// SecureCodecsFromPairs returns a SecureCodec for each hash and block key
+// pair, in the same way as securecookie.CodecsFromPairs.
+func SecureCodecsFromPairs(keyPairs ...[]byte) ([]securecookie.Codec, error) {
+	codecs := make([]securecookie.Codec, 0, len(keyPairs)/2+len(keyPairs)%2)
+	for i := 0; i < len(keyPairs); i += 2 {
+		var blockKey []byte
+		if i+1 < len(keyPairs) {
+			blockKey = keyPairs[i+1]
+		}
+		codec, err := NewSecureCodec(keyPairs[i], blockKey)
+		if err != nil {
+			return nil, err
+		}
+		codecs = append(codecs, codec)
+	}
+	return codecs, nil
+}
+
+// MaxAge restricts the maximum age, in seconds, of decoded values.
+// Set it to 0 for no restriction. The default is 30 days.
+func (s *SecureCodec) MaxAge(v int) *SecureCodec {
+	s.maxAge = int64(v)
+	return s
+}
+
+// MinAge restricts the minimum age, in seconds, of decoded values.
+// Set it to 0 for no restriction, which is the default.
+func (s *SecureCodec) MinAge(v int) *SecureCodec {
+	s.minAge = int64(v)
+	return s
+}
+
+// MaxLength restricts the maximum length, in bytes, of encoded values.
+// Set it to 0 for no restriction. The default is 4096.
+func (s *SecureCodec) MaxLength(n int) *SecureCodec {
+	s.maxLength = n
+	return s
+}
+
+// SetMAC sets the algorithm new values are signed with. The default is
+// MACSHA256. Values signed with any of the algorithms can be decoded, as
+// they identify the one used.
+func (s *SecureCodec) SetMAC(alg MACAlgorithm) *SecureCodec {
+	s.alg = alg
+	return s
+}
+
+// SetSerializer sets the serializer used for values. The default is
+// GobSerializer.
+func (s *SecureCodec) SetSerializer(sz Serializer) *SecureCodec {
+	s.serializer = sz
+	return s
+}
+
+// Encode serializes, optionally encrypts, and signs value for the cookie
+// or record called name, returning a URL-safe string.
+func (s *SecureCodec) Encode(name string, value interface{}) (string, error) {
+	b, err := s.serializer.Serialize(value)
+	if err != nil {
+		return "", err
+	}
+	if s.block != nil {
+		if b, err = encryptCTR(s.block, b); err != nil {
+			return "", err
+		}
+	}
+	b = encodeBase64(b)
+	var prefix string
+	if id, ok := macIDs[s.alg]; ok {
+		prefix = id + "."
+	}
+	b = []byte(fmt.Sprintf("%s|%s%d|%s|", name, prefix, time.Now().UTC().Unix(), b))
+	mac := s.mac(s.alg, b[:len(b)-1])
+	b = append(b, mac...)[len(name)+1:]
+	b = encodeBase64(b)
+	if s.maxLength != 0 && len(b) > s.maxLength {
+		return "", ErrSessionTooLarge
+	}
+	return string(b), nil
+}
+
+// Decode verifies, optionally decrypts, and deserializes value into dst.
+func (s *SecureCodec) Decode(name, value string, dst interface{}) error {
+	if s.maxLength != 0 && len(value) > s.maxLength {
+		return errValueTooLong
+	}
+	b, err := decodeBase64([]byte(value))
+	if err != nil {
+		return err
+	}
+	parts := bytes.SplitN(b, []byte("|"), 3)
+	if len(parts) != 3 {
+		return errMacInvalid
+	}
+	alg, date := MACSHA256, string(parts[0])
+	if i := strings.IndexByte(date, '.'); i >= 0 {
+		if alg, err = macAlgorithm(date[:i]); err != nil {
+			return err
+		}
+		date = date[i+1:]
+	}
+	signed := append([]byte(name+"|"), b[:len(b)-len(parts[2])-1]...)
+	if !hmac.Equal(parts[2], s.mac(alg, signed)) {
+		return errMacInvalid
+	}
+	t1, err := strconv.ParseInt(date, 10, 64)
+	if err != nil {
+		return errTimestampValue
+	}
+	t2 := time.Now().UTC().Unix()
+	if s.minAge != 0 && t1 > t2-s.minAge {
+		return errTimestampNew
+	}
+	if s.maxAge != 0 && t1 < t2-s.maxAge {
+		return errTimestampOld
+	}
+	if b, err = decodeBase64(parts[1]); err != nil {
+		return err
+	}
+	if s.block != nil {
+		if b, err = decryptCTR(s.block, b); err != nil {
+			return err
+		}
+	}
+	return s.serializer.Deserialize(b, dst)
+}
+
+// macAlgorithm returns the MAC algorithm identified by id.
+func macAlgorithm(id string) (MACAlgorithm, error) {
+	for alg, v := range macIDs {
+		if v == id {
+			return alg, nil
+		}
+	}
+	return 0, errMacInvalid
+}
+
+// mac returns the HMAC of value with alg.
+func (s *SecureCodec) mac(alg MACAlgorithm, value []byte) []byte {
+	h := hmac.New(alg.hash(), s.hashKey)
+	h.Write(value)
+	return h.Sum(nil)
+}
+
+// encryptCTR encrypts value with AES in CTR mode, prepending a random IV.
+func encryptCTR(block cipher.Block, value []byte) ([]byte, error) {
+	iv := securecookie.GenerateRandomKey(block.BlockSize())
+	if iv == nil {
+		return nil, errors.New("sessions: failed to generate random iv")
+	}
+	out := make([]byte, len(value))
+	cipher.NewCTR(block, iv).XORKeyStream(out, value)
+	return append(iv, out...), nil
+}
+
+// decryptCTR reverses encryptCTR.
+func decryptCTR(block cipher.Block, value []byte) ([]byte, error) {
+	size := block.BlockSize()
+	if len(value) <= size {
+		return nil, errDecryption
+	}
+	iv, value := value[:size], value[size:]
+	out := make([]byte, len(value))
+	cipher.NewCTR(block, iv).XORKeyStream(out, value)
+	return out, nil
+}
+
+func encodeBase64(value []byte) []byte {
+	encoded := make([]byte, base64.URLEncoding.EncodedLen(len(value)))
+	base64.URLEncoding.Encode(encoded, value)
+	return encoded
+}
+
+func decodeBase64(value []byte) ([]byte, error) {
+	decoded := make([]byte, base64.URLEncoding.DecodedLen(len(value)))
+	n, err := base64.URLEncoding.Decode(decoded, value)
+	if err != nil {
+		return nil, errMacInvalid
+	}
+	return decoded[:n], nil
+}
@@ -0,0 +1,100 @@
+package sessions
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// redactedValue replaces the values of sensitive keys in the JSON form of
+// sessions.
+const redactedValue = "[redacted]"
+
+// sensitiveKeys holds the keys registered with RegisterSensitive, starting
+// with the credentials the package stores in sessions.
+var (
+	sensitiveMu   sync.RWMutex
+	sensitiveKeys = map[string]bool{
+		jtiKey:            true,
+		channelBindingKey: true,
+	}
+)
+
+// RegisterSensitive marks the values stored under keys as sensitive, such
+// as tokens or personal data: MarshalJSON replaces them with "[redacted]".
+// Values stored with SetSecret are written encrypted, so they need not be
+// registered. The credentials kept by the package, such as revocation
+// identifiers, channel bindings, nonces and the csrf secret, are sensitive
+// by default.
+func RegisterSensitive(keys ...string) {
+	sensitiveMu.Lock()
+	defer sensitiveMu.Unlock()
+	for _, k := range keys {
+		sensitiveKeys[k] = true
+	}
+}
+
+// Redact, if set, is called by MarshalJSON with the key and value of every
+// value not already redacted, and returns the value to write in their
+// place, for redaction rules RegisterSensitive can't express.
+var Redact func(key string, value interface{}) interface{}
+
+// sessionJSON is the JSON form of a session.
+type sessionJSON struct {
+	Name   string                     `json:"name"`
+	IsNew  bool                       `json:"is_new"`
+	Values map[string]json.RawMessage `json:"values"`
+}
+
+// MarshalJSON encodes the session for debug endpoints, logs and support
+// tools: its name, whether it is new, and its values under their keys
+// converted with fmt.Sprint. The ID, a credential for server-side stores,
+// is left out, and sensitive values are redacted, see RegisterSensitive.
+// Values JSON can't encode are written as the string form of fmt.Sprint.
+func (s *Session) MarshalJSON() ([]byte, error) {
+	s.Load()
+	out := sessionJSON{Name: s.name, IsNew: s.IsNew, Values: make(map[string]json.RawMessage, len(s.Values))}
+	sensitiveMu.RLock()
+	defer sensitiveMu.RUnlock()
+	for k, v := range s.Values {
+		key := fmt.Sprint(k)
+		if sensitiveKeys[key] || strings.HasPrefix(key, noncePrefix) {
+			v = redactedValue
+		} else if Redact != nil {
+			v = Redact(key, v)
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			b, _ = json.Marshal(fmt.Sprint(v))
+		}
+		out.Values[key] = b
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON sets the values of the session from the JSON form written
+// by MarshalJSON, for tests and support tools. Keys are strings, numbers
+// are decoded as json.Number like with JSONCodec, and redacted values hold
+// "[redacted]". The session is marked modified.
+func (s *Session) UnmarshalJSON(data []byte) error {
+	var in struct {
+		Values map[string]interface{} `json:"values"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&in); err != nil {
+		return err
+	}
+	s.loadLazy()
+	if s.Values == nil {
+		s.Values = make(map[interface{}]interface{}, len(in.Values))
+	}
+	s.clearValues()
+	for k, v := range in.Values {
+		s.Values[k] = v
+	}
+	s.dirty = true
+	return nil
+}
@@ -6,15 +6,31 @@ package sessions
 
 import (
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"github.com/go-floki/floki"
 	"net/http"
+	"reflect"
+	"sort"
+	"strings"
 	"time"
 )
 
 // Default flashes key.
 const flashesKey = "_flash"
 
+// Keys under which session metadata is kept in Values.
+const (
+	userIDKey = "_uid"
+	maxAgeKey = "_maxage"
+)
+
+// Cookie name prefixes restricting the attributes browsers accept.
+const (
+	hostPrefix   = "__Host-"
+	securePrefix = "__Secure-"
+)
+
 // Options --------------------------------------------------------------------
 
 // Options stores configuration for a session or session store.
@@ -29,54 +45,684 @@ type Options struct {
 	MaxAge   int
 	Secure   bool
 	HttpOnly bool
+	// SameSite restricts sending the cookie along with cross-site requests.
+	// The zero value means http.SameSiteLaxMode. SameSiteNoneMode requires
+	// Secure.
+	SameSite http.SameSite
+	// Partitioned emits the Partitioned attribute (CHIPS), which browsers
+	// require for third-party cookies of embedded sites. It requires Secure.
+	Partitioned bool
+	// BrowserSession omits the 'Max-Age' and 'Expires' attributes of
+	// cookies that are not being deleted, so browsers drop them when they
+	// close, while MaxAge still limits the lifetime of the server-side
+	// record and of the cookie signature.
+	BrowserSession bool
 }
 
-func flushSession(c *floki.Context) {
-	s := c.MustGet("_session").(*Session)
+// Validate reports combinations of options browsers reject or that can't
+// be emitted as a cookie, so they can be caught at startup rather than
+// producing broken cookies.
+func (o *Options) Validate() error {
+	if o.SameSite == http.SameSiteNoneMode && !o.Secure {
+		return ErrSameSiteNoneInsecure
+	}
+	if o.Partitioned && !o.Secure {
+		return ErrPartitionedInsecure
+	}
+	if strings.ContainsAny(o.Domain, ":/; ") {
+		return ErrDomainInvalid
+	}
+	if o.Path != "" && (o.Path[0] != '/' || strings.ContainsAny(o.Path, "; ")) {
+		return ErrPathInvalid
+	}
+	return nil
+}
 
-	if s.dirty {
-		err := s.Save(c)
-		if err != nil {
-			c.Logger().Fatalln("error saving session:", err)
-		}
+// forPrefix returns o with the attributes the prefix of the cookie name
+// requires: Secure for __Secure- and __Host-, plus Path=/ and no Domain for
+// __Host-. o is copied rather than modified when it lacks any of them.
+func (o *Options) forPrefix(name string) *Options {
+	host := strings.HasPrefix(name, hostPrefix)
+	if !host && !strings.HasPrefix(name, securePrefix) {
+		return o
+	}
+	if o.Secure && (!host || o.Path == "/" && o.Domain == "") {
+		return o
+	}
+	options := *o
+	options.Secure = true
+	if host {
+		options.Path = "/"
+		options.Domain = ""
+	}
+	return &options
+}
+
+// validate reports a configuration error in o for the cookie called name.
+func (o *Options) validate(name string) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	if strings.HasPrefix(name, hostPrefix) &&
+		(!o.Secure || o.Path != "/" || o.Domain != "") {
+		return fmt.Errorf("sessions: cookie %q requires Secure, Path=/ and no Domain", name)
 	}
+	if strings.HasPrefix(name, securePrefix) && !o.Secure {
+		return fmt.Errorf("sessions: cookie %q requires Secure", name)
+	}
+	return nil
+}
+
+// flushSession saves the session s of the request if it was modified,
+// passing save errors to onSaveError.
+func flushSession(c *floki.Context, s *Session, onSaveError func(*floki.Context, *Session, error)) {
+	isNew := s.IsNew
+	var err error
+	if s.rotate {
+		s.rotate = false
+		err = s.RegenerateID(c)
+	} else if s.dirty {
+		err = s.Save(c)
+	} else {
+		return
+	}
+	if err != nil {
+		onSaveError(c, s, err)
+		return
+	}
+	if isNew {
+		audit(c, s, AuditCreated, "")
+	}
+}
+
+// Config configures the middleware returned by SessionsWithConfig.
+type Config struct {
+	// Options are the cookie options of new and loaded sessions. Handlers
+	// may override them per session, see Session.SetMaxAge. When nil, the
+	// Options of the store are used. Whichever are used, cookies named with
+	// the __Secure- or __Host- prefix get the attributes it requires:
+	// Secure, plus Path=/ and no Domain for __Host-.
+	Options *Options
+
+	// Skip lists the URL paths of the requests the middleware leaves
+	// without a session, such as "/static/", "/healthz" or "*.css", and
+	// Only, if not empty, the only ones it handles. Patterns ending with a
+	// slash match the paths they prefix, patterns without slashes the last
+	// element of the path, and other patterns the whole path, with the
+	// syntax of path.Match. Use TryGet in handlers shared with skipped
+	// routes.
+	Skip []string
+	Only []string
+
+	// Routes give the sessions of some routes another cookie name or
+	// other Options, such as a short-lived strict session for "/admin/"
+	// next to a long-lived lax one for the rest of the site. The first
+	// route matching the URL path of a request applies; other requests
+	// use the name and Options of the middleware.
+	Routes []Route
+
+	// ReadOnly lists the URL paths, with the patterns of Skip, of the
+	// requests whose session is read-only, such as GET-only APIs and
+	// cacheable pages: writes to it are ignored and it is never saved.
+	// See Session.SetReadOnly to decide in handlers.
+	ReadOnly []string
+
+	// ExposeFlashes, if not empty, is the context key the flashes of the
+	// session are moved to before the handlers run, as ExposedFlashes, so
+	// templates can display the messages of a redirect without code in
+	// every handler. For instance "flashes".
+	ExposeFlashes string
+
+	// Types are values whose types are registered with RegisterType when
+	// the middleware is created, so they can be stored in the session.
+	Types []interface{}
+
+	// StringKeys makes the session convert every key to a string, so
+	// Values only ever holds string keys. See Session.UseStringKeys.
+	StringKeys bool
+
+	// Fingerprint lists request headers, such as DefaultFingerprint, whose
+	// hashes are stored in the session and compared on every request. Nil
+	// disables the check.
+	Fingerprint []string
+
+	// FingerprintTolerance is the number of Fingerprint headers allowed to
+	// differ from the stored ones.
+	FingerprintTolerance int
+
+	// OnFingerprintMismatch is called when more Fingerprint headers than
+	// tolerated differ, before the handlers run. It may challenge the client,
+	// for instance by redirecting to a login page and aborting the request.
+	// It defaults to invalidating the session.
+	OnFingerprintMismatch func(c *floki.Context, s *Session)
+
+	// ChannelBinding, such as ClientCertificateBinding, binds sessions to
+	// the TLS channel they are created on. Requests presenting a session
+	// over another channel, or without TLS, are passed to
+	// OnChannelBindingMismatch.
+	ChannelBinding ChannelBinding
+
+	// OnChannelBindingMismatch is called for the requests rejected by
+	// ChannelBinding, before the handlers run. It defaults to invalidating
+	// the session and aborting with 403 Forbidden.
+	OnChannelBindingMismatch func(c *floki.Context, s *Session)
+
+	// Anomaly, if set, is called for every request with an existing
+	// session, with the metadata of the previous request, so impossible
+	// travel or new devices can be detected. Its Decision is applied
+	// before the handlers run.
+	Anomaly func(c *floki.Context, s *Session, meta SessionMeta) Decision
+
+	// Country returns the country of the client, such as from a header
+	// set by a CDN, recorded for Anomaly. Optional.
+	Country func(c *floki.Context) string
+
+	// Revocations, if set, is consulted on every request and revoked
+	// sessions are replaced by new ones. See Session.RevocationID.
+	Revocations RevocationList
+
+	// Tombstones, if set, records the sessions ended by Session.Destroy
+	// for TombstoneTTL. Requests still presenting them, such as ones sent
+	// concurrently with a logout, are passed to OnTombstone rather than
+	// silently given a new anonymous session.
+	Tombstones RevocationList
+
+	// TombstoneTTL is how long destroyed sessions are recorded in
+	// Tombstones. The default is 30 seconds.
+	TombstoneTTL time.Duration
+
+	// OnTombstone is called for the requests presenting a destroyed
+	// session, before the handlers run. The session is then new. It
+	// defaults to aborting with 401 Unauthorized.
+	OnTombstone func(c *floki.Context, s *Session)
+
+	// NotBefore, if set, is consulted on every request with a logged in
+	// user, and sessions logged in before the time recorded for their
+	// user are replaced by new ones. Sessions are also invalidated once
+	// the time set with Session.InvalidateAfter has passed.
+	NotBefore NotBeforeStore
+
+	// Epoch, if set, is consulted on every request, and sessions created
+	// before its current epoch are replaced by new ones. See EpochSource.
+	Epoch EpochSource
+
+	// TrackAccess records the creation time of sessions, the time of
+	// their last request and the number of times they were saved, read
+	// with Session.CreatedAt, LastAccessedAt and SaveCount, for "active
+	// devices" pages and anomaly detection. The last access is updated at
+	// most once a second, but it still saves the session on most requests.
+	TrackAccess bool
+
+	// AbsoluteTimeout, if not 0, is the maximum lifetime of a session from
+	// its creation, however often its cookie is refreshed. Older sessions
+	// are replaced by new ones.
+	AbsoluteTimeout time.Duration
+
+	// IdleTimeout, if not 0, expires sessions not used by any request for
+	// that long. Every request refreshes the time of last activity.
+	IdleTimeout time.Duration
+
+	// ExpiryGrace extends IdleTimeout and AbsoluteTimeout: sessions that
+	// expired less than ExpiryGrace ago are still loaded, so requests
+	// racing at the expiry, such as from parallel tabs, or clock skew
+	// between servers don't log users out. Idle sessions loaded within it
+	// are renewed by the request; the absolute lifetime is only extended
+	// by the grace. See also MemoryStore.ExpiryGrace.
+	ExpiryGrace time.Duration
+
+	// ExpiryWarning, if not 0, flags the requests whose session expires
+	// within that long under AbsoluteTimeout or IdleTimeout, see
+	// ExpiringSoon, and calls OnExpiryWarning for them.
+	ExpiryWarning time.Duration
+
+	// OnExpiryWarning, if set, is called before the handlers run with the
+	// sessions expiring within ExpiryWarning and the time they have left.
+	OnExpiryWarning func(c *floki.Context, s *Session, left time.Duration)
+
+	// RenewOnActivity extends the cookie expiry and the record TTL of
+	// sessions on every request they are used in, so MaxAge counts from
+	// the last activity rather than from the last change. Unchanged
+	// sessions are renewed with Session.Touch.
+	RenewOnActivity bool
+
+	// RenewAfter, between 0 and 1, only renews sessions once that
+	// fraction of their MaxAge has elapsed since their last renewal,
+	// saving most writes. With 0, sessions are renewed on every request.
+	RenewAfter float64
+
+	// RotateIDEvery, if not 0, regenerates the ID of sessions whose
+	// current ID was issued longer ago, at the end of the request, so a
+	// stolen cookie stops working soon. See Session.RegenerateID.
+	RotateIDEvery time.Duration
+
+	// CookieRefreshAfter, between 0 and 1, avoids reissuing the cookie of
+	// sessions the handlers did not modify, such as on read-only GET
+	// requests, until that fraction of its MaxAge has elapsed since it was
+	// last issued. The records of server-side stores are still updated
+	// with the values recorded by the middleware, such as the time of
+	// last activity. With 0, the cookie is reissued on every save.
+	CookieRefreshAfter float64
+
+	// SecretKeys are the AES keys of the values stored with
+	// Session.SetSecret, of 16, 24, or 32 bytes. The first key encrypts
+	// new values; all of them are tried when decrypting.
+	SecretKeys [][]byte
+
+	// OnDecodeError is called when the session cookie or record of the
+	// request can't be decoded, with an error matching ErrMACInvalid,
+	// ErrExpired or ErrMalformed, before the handlers run. The session is
+	// then empty. It defaults to IssueFreshSession; RejectInvalidSession
+	// and LogDecodeError are the other choices. Other store errors go to
+	// OnError.
+	OnDecodeError func(c *floki.Context, s *Session, err error)
+
+	// OnError is called when the store fails to load the session of the
+	// request for another reason than a decode error, such as a redis
+	// outage, or when Revocations, Tombstones, NotBefore or Epoch fail to
+	// check it, and returns what to do with the request. It defaults to
+	// LogStoreError, which serves it with an empty session that is never
+	// saved.
+	OnError func(c *floki.Context, err error) ErrAction
+
+	// OnSaveError is called when the session modified by the request
+	// can't be saved at the end of it. It defaults to LogSaveError. The
+	// response may already be written when it is called.
+	OnSaveError func(c *floki.Context, s *Session, err error)
+
+	// LazyLoad defers loading the session of a request until a handler
+	// first uses it, saving a store round trip for the requests that
+	// don't, such as static assets and health checks. The checks of the
+	// middleware then run on first use: the ones aborting requests, such
+	// as RejectInvalidSession, take effect once the handler returns, and
+	// the "session" context value and ExposeFlashes are only set then.
+	// Read the fields of the session, such as ID, after calling Load.
+	LazyLoad bool
+
+	// LazyCreation keeps new sessions in memory until a handler writes
+	// to them: sessions only read, or only holding the values recorded by
+	// the middleware itself, such as fingerprints or timestamps, get no
+	// cookie and no server-side record. Responses to anonymous visitors
+	// then stay cacheable and don't set cookies before consent.
+	LazyCreation bool
+
+	// CreationLimiter, if set, limits how many new sessions a client may
+	// create, against floods of sessions filling the store. New sessions
+	// over the limit are not saved by the middleware and get no cookie;
+	// the request itself is still served.
+	CreationLimiter RateLimiter
+
+	// CreationLimitKey identifies the client for CreationLimiter. It
+	// defaults to LimitByIP.
+	CreationLimitKey func(c *floki.Context) string
+
+	// RememberMe, if set, enables remember-me tokens: sessions without a
+	// logged in user are re-created from the token cookie issued by
+	// Remember. See RememberToken.
+	RememberMe *RememberConfig
+
+	// MaxSessionsPerUser, if not 0, is the maximum number of sessions of a
+	// user, in stores implementing UserIndexer. When a login exceeds it,
+	// other sessions of the user are evicted in the order of Eviction;
+	// the login itself always succeeds.
+	MaxSessionsPerUser int
+
+	// Eviction orders the sessions evicted for MaxSessionsPerUser. It
+	// defaults to EvictOldest.
+	Eviction EvictionPolicy
 }
 
 // Sessions is a Middleware that maps a session.Session service into the Floki handler chain.
 // Sessions can use a number of storage solutions with the given store.
 func Sessions(name string, store Store, options *Options) floki.HandlerFunc {
-	if options == nil {
-		options = &Options{
+	return SessionsWithConfig(name, store, Config{Options: options})
+}
+
+// SessionsWithConfig is like Sessions but takes the complete middleware
+// configuration.
+func SessionsWithConfig(name string, store Store, config Config) floki.HandlerFunc {
+	handler := newHandler(name, store, config, true)
+	return func(c *floki.Context) {
+		if handler(c) {
+			c.Next()
+		}
+	}
+}
+
+// newHandler returns the work of the middleware for the session called
+// name, short of calling the next handlers, which it reports whether to do.
+// The primary session is the one returned by Get and exported under the
+// "session" context key.
+func newHandler(name string, store Store, config Config, primary bool) func(c *floki.Context) bool {
+	key := namedSessionKey(name)
+
+	// Overriding the Options of the store is only wanted when set.
+	overrideOptions := config.Options != nil
+	if config.Options == nil {
+		config.Options = &Options{
 			Path:     "/",
 			MaxAge:   3600,
 			Secure:   false,
 			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
 		}
 	}
-
-	return func(c *floki.Context) {
-		// Map to the Session interface
-		s, err := GetRegistry(c).Get(store, name)
-		if err != nil {
+	config.Options = config.Options.forPrefix(name)
+	if err := config.Options.validate(name); err != nil {
+		panic(err)
+	}
+	for _, v := range config.Types {
+		RegisterType(v)
+	}
+	if config.OnChannelBindingMismatch == nil {
+		config.OnChannelBindingMismatch = rejectChannelBinding
+	}
+	if config.OnDecodeError == nil {
+		config.OnDecodeError = IssueFreshSession
+	}
+	if config.OnError == nil {
+		config.OnError = LogStoreError
+	}
+	if config.OnSaveError == nil {
+		config.OnSaveError = LogSaveError
+	}
+	var tomb *tombstones
+	if config.Tombstones != nil {
+		if config.TombstoneTTL <= 0 {
+			config.TombstoneTTL = 30 * time.Second
+		}
+		if config.OnTombstone == nil {
+			config.OnTombstone = rejectTombstone
+		}
+		tomb = &tombstones{list: config.Tombstones, ttl: config.TombstoneTTL}
+	}
+	if config.CreationLimitKey == nil {
+		config.CreationLimitKey = LimitByIP
+	}
+	if config.Eviction == nil {
+		config.Eviction = EvictOldest
+	}
+	if rm := config.RememberMe; rm != nil {
+		rm := *rm
+		if rm.CookieName == "" {
+			rm.CookieName = "remember"
+		}
+		if rm.Options == nil {
+			options := *config.Options
+			options.MaxAge = 86400 * 30
+			options.BrowserSession = false
+			rm.Options = &options
+		}
+		rm.Options = rm.Options.forPrefix(rm.CookieName)
+		config.RememberMe = &rm
+	}
+	var secrets *encryptTransform
+	if len(config.SecretKeys) > 0 {
+		var err error
+		if secrets, err = newEncryptTransform(config.SecretKeys...); err != nil {
 			panic(err)
 		}
+	}
+	if config.OnFingerprintMismatch == nil {
+		config.OnFingerprintMismatch = func(c *floki.Context, s *Session) {
+			s.invalidate()
+			s.Set(fingerprintKey, fingerprint(c, config.Fingerprint))
+		}
+	}
 
-		c.Set("_session", s)
+	filter := pathFilter{skip: config.Skip, only: config.Only}
+	routes := newRoutes(config.Routes, name, config.Options, overrideOptions)
 
-		// export session values to the request context
-		c.Set("session", s.Values)
+	return func(c *floki.Context) bool {
+		if filter.bypass(c.Request.URL.Path) {
+			return true
+		}
+		name, routeOptions, override := name, config.Options, overrideOptions
+		if r := matchRoute(routes, c.Request.URL.Path); r != nil {
+			name, routeOptions, override = r.Name, r.Options, r.override
+		}
+		var (
+			s       *Session
+			pending bool
+			userID  string
+		)
+
+		// load reads the session of the request and applies the checks of
+		// config to it. It reports whether the request may go on.
+		load := func() bool {
+			// Map to the Session interface
+			var err error
+			s, err = GetRegistry(c).Get(store, name)
+			s.tombstones, s.remember = tomb, config.RememberMe
+			if err == nil {
+				// Payloads decoded lazily fail here rather than later,
+				// with the values already replaced.
+				err = s.Load()
+			}
+			if err != nil && !isDecodeError(err) {
+				if !handleStoreError(c, s, err, config.OnError) {
+					return false
+				}
+			} else if err != nil {
+				audit(c, s, AuditDecodeFailure, err.Error())
+				config.OnDecodeError(c, s, err)
+				if s.rejected {
+					return false
+				}
+			}
+			if config.StringKeys {
+				s.UseStringKeys()
+			}
+			if override {
+				options := *routeOptions
+				s.Options = &options
+			} else if s.Options != nil {
+				s.Options = s.Options.forPrefix(name)
+			}
+			if maxAge, ok := int64Value(s.Get(maxAgeKey)); ok {
+				options := *s.Options
+				options.MaxAge = int(maxAge)
+				s.Options = &options
+			}
+			s.secrets = secrets
+			if config.Fingerprint != nil &&
+				!checkFingerprint(c, s, config.Fingerprint, config.FingerprintTolerance) {
+				audit(c, s, AuditBindingViolation, "fingerprint mismatch")
+				config.OnFingerprintMismatch(c, s)
+			}
+			if config.ChannelBinding != nil && !checkChannelBinding(c, s, config.ChannelBinding) {
+				audit(c, s, AuditBindingViolation, "TLS channel mismatch")
+				config.OnChannelBindingMismatch(c, s)
+				if s.rejected {
+					return false
+				}
+			}
+			if config.Revocations != nil {
+				if err := checkRevoked(c, s, config.Revocations); err != nil {
+					return handleStoreError(c, s, err, config.OnError)
+				}
+			}
+			if tomb != nil {
+				buried, err := tomb.buried(s)
+				if err != nil {
+					return handleStoreError(c, s, err, config.OnError)
+				}
+				if buried {
+					audit(c, s, AuditRevoked, "destroyed session")
+					s.invalidate()
+					config.OnTombstone(c, s)
+				}
+			}
+			if err := checkInvalidation(c, s, config.NotBefore); err != nil {
+				return handleStoreError(c, s, err, config.OnError)
+			}
+			if config.Epoch != nil {
+				if err := checkEpoch(c, s, config.Epoch); err != nil {
+					return handleStoreError(c, s, err, config.OnError)
+				}
+			}
+			// The timeouts are checked before Anomaly records the time of
+			// the request, which IdleTimeout compares with.
+			var meta SessionMeta
+			if config.Anomaly != nil {
+				meta = sessionMeta(s)
+			}
+			if config.AbsoluteTimeout > 0 {
+				checkAbsoluteTimeout(c, s, config.AbsoluteTimeout+config.ExpiryGrace)
+			}
+			if config.IdleTimeout > 0 {
+				checkIdleTimeout(c, s, config.IdleTimeout+config.ExpiryGrace)
+			}
+			if config.Anomaly != nil {
+				checkAnomaly(c, s, meta, &config)
+				if s.rejected {
+					return false
+				}
+			}
+			if config.TrackAccess {
+				trackAccess(s)
+			}
+			if config.RotateIDEvery > 0 {
+				checkIDRotation(s, config.RotateIDEvery)
+			}
+			if config.ExpiryWarning > 0 {
+				checkExpiryWarning(c, s, &config)
+			}
+			if config.RememberMe != nil {
+				config.RememberMe.restore(c, s)
+			}
+			if config.ExposeFlashes != "" {
+				exposeFlashes(c, s, config.ExposeFlashes)
+			}
 
-		c.BeforeDestroy(flushSession)
+			// Tell the values recorded above by the middleware itself from
+			// the ones written by the handlers: with LazyCreation, they don't
+			// make a new session worth saving, and with CookieRefreshAfter
+			// they don't reissue the cookie.
+			if config.LazyCreation && s.IsNew || config.CookieRefreshAfter > 0 {
+				pending, s.dirty = s.dirty, false
+			}
+			userID = sessionUserID(s)
+			s.checkpoint()
+			s.readOnly = s.readOnly || matchAny(config.ReadOnly, c.Request.URL.Path)
 
-		c.Next()
+			// export session values to the request context
+			if primary {
+				c.Set("session", s.Values)
+			}
+			return true
+		}
 
+		if config.LazyLoad {
+			// Hand out a session loading itself on first use, and taking
+			// the place of the loaded one in the registry.
+			proxy := NewSession(store, name)
+			proxy.lazy = func() {
+				s.readOnly = proxy.readOnly
+				load()
+				*proxy = *s
+				s = proxy
+				registry := GetRegistry(c)
+				info := registry.sessions[name]
+				info.s = proxy
+				registry.sessions[name] = info
+			}
+			s = proxy
+		} else if !load() {
+			return false
+		}
+		if primary {
+			c.Set("_session", s)
+		}
+		c.Set(key, s)
+
+		// save saves the session once, right before the response is
+		// written or at the end of the request.
+		var saved bool
+		save := func() {
+			if saved {
+				return
+			}
+			saved = true
+			s.syncWatched()
+			if s.lazy != nil || s.loadErr != nil || s.readOnly || s.rejected {
+				return
+			}
+			if config.LazyCreation && s.IsNew && !s.dirty && !s.rotate {
+				return
+			}
+			written := s.dirty
+			s.dirty = s.dirty || pending
+			if !allowCreation(c, s, &config) {
+				return
+			}
+			// Sessions logged in by this request may evict others.
+			login := config.MaxSessionsPerUser > 0 &&
+				sessionUserID(s) != "" && sessionUserID(s) != userID
+			if login && s.Get(createdAtKey) == nil {
+				s.Set(createdAtKey, time.Now().Unix())
+			}
+			if config.RenewOnActivity {
+				renewSession(c, s, config.RenewAfter)
+			}
+			if config.TrackAccess && (s.dirty || s.rotate) {
+				s.Set(saveCountKey, int64(s.SaveCount()+1))
+			}
+			if config.CookieRefreshAfter > 0 && (s.dirty || s.rotate) {
+				if !written && saveRecordOnly(c, s, config.CookieRefreshAfter, config.OnSaveError) {
+					return
+				}
+				s.Set(cookieIssuedKey, time.Now().Unix())
+			}
+			flushSession(c, s, config.OnSaveError)
+			if login {
+				if err := evictSessions(c, s, config.MaxSessionsPerUser, config.Eviction); err != nil {
+					c.Logger().Println("error evicting sessions:", err)
+				}
+			}
+		}
+		c.Writer = &saveWriter{ResponseWriter: c.Writer, before: func() {
+			save()
+			s.dirty = false
+		}}
+
+		c.BeforeDestroy(func(c *floki.Context) {
+			if !saved {
+				save()
+				return
+			}
+			// The response is written: changes made since can only reach
+			// the records of server-side stores, and the ID can't change.
+			s.syncWatched()
+			if s.lazy != nil || s.loadErr != nil || s.decodeErr != nil || s.readOnly || s.ID == "" || !s.dirty {
+				return
+			}
+			if saver, ok := s.store.(recordSaver); ok {
+				if err := saver.save(s); err != nil {
+					config.OnSaveError(c, s, err)
+				}
+			}
+		})
+		return true
 	}
 }
 
+// Get returns the session of the request. It panics if the middleware is
+// not installed for the route; see TryGet.
 func Get(c *floki.Context) *Session {
 	return c.MustGet("_session").(*Session)
 }
 
+// TryGet returns the session of the request, or false if the middleware is
+// not installed for the route.
+func TryGet(c *floki.Context) (*Session, bool) {
+	v, err := c.Get("_session")
+	if err != nil {
+		return nil, false
+	}
+	s, ok := v.(*Session)
+	return s, ok && s != nil
+}
+
 // Session --------------------------------------------------------------------
 
 // NewSession is called by session stores to create a new session instance.
@@ -89,6 +735,10 @@ func NewSession(store Store, name string) *Session {
 }
 
 // Session stores the values and optional configuration for a session.
+//
+// Options are used when the session is saved, so handlers can replace them
+// for a single response; see SetMaxAge to change the lifetime of a session
+// for good.
 type Session struct {
 	ID      string
 	Values  map[interface{}]interface{}
@@ -97,6 +747,197 @@ type Session struct {
 	store   Store
 	name    string
 	dirty   bool
+
+	// stringKeys is set by UseStringKeys.
+	stringKeys bool
+
+	// rotate is set by SetAuthenticated so the ID is regenerated on save.
+	rotate bool
+
+	// raw is the payload of a session that has not been decoded yet, and
+	// codec the Codec decoding it. See Load.
+	raw   []byte
+	codec Codec
+
+	// decodeErr is the error decoding raw failed with; the session is then
+	// never saved, so the stored record is not overwritten.
+	decodeErr error
+
+	// secrets encrypts the values of SetSecret.
+	secrets *encryptTransform
+
+	// integrityFailed is set by IntegrityStore, see IntegrityFailed.
+	integrityFailed bool
+
+	// loadResult is what became of the session presented by the request.
+	loadResult LoadResult
+
+	// saved is the state restored by Discard.
+	saved *sessionState
+
+	// loadErr is the store error the session was emptied for, see
+	// Config.OnError.
+	loadErr error
+
+	// lazy loads the session on first use, see Config.LazyLoad.
+	lazy func()
+
+	// readOnly is set by SetReadOnly and Config.ReadOnly.
+	readOnly bool
+
+	// tombstones and remember are the Config.Tombstones and
+	// Config.RememberMe of the middleware handling the session.
+	tombstones *tombstones
+	remember   *RememberConfig
+
+	// rejected is set by reject: the request was aborted and the session
+	// must not be saved.
+	rejected bool
+
+	// watched reports, by key, whether values handed out by reference,
+	// such as the payload of TypedSession.Data, were changed in place.
+	watched map[interface{}]func() bool
+}
+
+// watch registers changed to report whether the value under key was
+// changed in place, replacing any previous one for key.
+func (s *Session) watch(key interface{}, changed func() bool) {
+	if s.watched == nil {
+		s.watched = make(map[interface{}]func() bool)
+	}
+	s.watched[key] = changed
+}
+
+// syncWatched marks the session modified if a watched value changed.
+func (s *Session) syncWatched() {
+	for _, changed := range s.watched {
+		if changed() {
+			s.dirty = true
+		}
+	}
+}
+
+// loadLazy loads the session if its loading was deferred by
+// Config.LazyLoad.
+func (s *Session) loadLazy() {
+	if fn := s.lazy; fn != nil {
+		s.lazy = nil
+		fn()
+	}
+}
+
+// sessionState is a copy of the state of a session the handlers may change.
+type sessionState struct {
+	values  map[interface{}]interface{}
+	raw     []byte
+	options *Options
+	rotate  bool
+	dirty   bool
+}
+
+// state returns a copy of the state of the session. Sessions that are not
+// decoded yet keep their payload instead of their values, so copying them
+// doesn't defeat lazy decoding.
+func (s *Session) state() *sessionState {
+	state := &sessionState{raw: s.raw, options: s.Options, rotate: s.rotate, dirty: s.dirty}
+	if s.raw == nil {
+		state.values = make(map[interface{}]interface{}, len(s.Values))
+		for k, v := range s.Values {
+			state.values[k] = v
+		}
+	}
+	return state
+}
+
+// restore brings the session back to state.
+func (s *Session) restore(state *sessionState) {
+	for k := range s.Values {
+		delete(s.Values, k)
+	}
+	for k, v := range state.values {
+		s.Values[k] = v
+	}
+	s.raw = state.raw
+	s.decodeErr = nil
+	s.watched = nil
+	s.Options = state.options
+	s.rotate = state.rotate
+	s.dirty = state.dirty
+}
+
+// checkpoint records the state of the session for Discard.
+func (s *Session) checkpoint() {
+	s.saved = s.state()
+}
+
+// Load decodes the payload of a session read by a store with lazy decoding
+// enabled, such as a RediStore with LazyDecode set. It does nothing for
+// sessions that are already decoded.
+//
+// Every accessor calls Load, so it only needs to be called directly before
+// reading Values or to check for decode errors. A payload that fails to
+// decode leaves Values empty, and Save then returns the error, as saving
+// would replace the stored session with an empty one; the middleware passes
+// the error to Config.OnDecodeError. Load also loads the sessions deferred
+// by Config.LazyLoad.
+func (s *Session) Load() error {
+	s.loadLazy()
+	if s.raw == nil {
+		return s.decodeErr
+	}
+	raw := s.raw
+	s.raw = nil
+	if err := malformed(s.codec.Unmarshal(raw, s.Values)); err != nil {
+		for k := range s.Values {
+			delete(s.Values, k)
+		}
+		s.decodeErr = err
+	}
+	return s.decodeErr
+}
+
+// clearValues empties the session, forgetting its payload and the error
+// decoding it.
+func (s *Session) clearValues() {
+	s.raw = nil
+	s.decodeErr = nil
+	s.watched = nil
+	for k := range s.Values {
+		delete(s.Values, k)
+	}
+}
+
+// setRaw stores the payload of a session for Load to decode later.
+func (s *Session) setRaw(raw []byte, codec Codec) {
+	s.raw = raw
+	s.codec = codec
+}
+
+// UseStringKeys switches the session to string-keyed mode: keys passed to
+// Get, Set and Delete are converted to strings with fmt.Sprint, and keys
+// already in Values are converted once. Values then only holds string keys,
+// which makes it safe to encode with JSONCodec and removes the bugs caused
+// by looking a value up with a key of a different type than it was stored
+// with.
+func (s *Session) UseStringKeys() {
+	s.Load()
+	s.stringKeys = true
+	for k, v := range s.Values {
+		if _, ok := k.(string); !ok {
+			delete(s.Values, k)
+			s.Values[fmt.Sprint(k)] = v
+		}
+	}
+}
+
+// key returns key as it's stored in Values.
+func (s *Session) key(key interface{}) interface{} {
+	if s.stringKeys {
+		if _, ok := key.(string); !ok {
+			return fmt.Sprint(key)
+		}
+	}
+	return key
 }
 
 // Flashes returns a slice of flash messages from the session.
@@ -109,6 +950,7 @@ func (s *Session) Flashes(vars ...string) []interface{} {
 	if len(vars) > 0 {
 		key = vars[0]
 	}
+	s.Load()
 	if v, ok := s.Values[key]; ok {
 		// Drop the flashes and return it.
 		delete(s.Values, key)
@@ -118,6 +960,18 @@ func (s *Session) Flashes(vars ...string) []interface{} {
 	return flashes
 }
 
+// PeekFlashes returns the flash messages of the session like Flashes, but
+// leaves them in the session to be consumed later.
+func (s *Session) PeekFlashes(vars ...string) []interface{} {
+	key := flashesKey
+	if len(vars) > 0 {
+		key = vars[0]
+	}
+	s.Load()
+	flashes, _ := s.Values[key].([]interface{})
+	return append([]interface{}(nil), flashes...)
+}
+
 // AddFlash adds a flash message to the session.
 //
 // A single variadic argument is accepted, and it is optional: it defines
@@ -127,6 +981,10 @@ func (s *Session) AddFlash(value interface{}, vars ...string) {
 	if len(vars) > 0 {
 		key = vars[0]
 	}
+	s.Load()
+	if s.readOnly {
+		return
+	}
 	var flashes []interface{}
 	if v, ok := s.Values[key]; ok {
 		flashes = v.([]interface{})
@@ -138,9 +996,242 @@ func (s *Session) AddFlash(value interface{}, vars ...string) {
 // Save is a convenience method to save this session. It is the same as calling
 // store.Save(request, response, session)
 func (s *Session) Save(c *floki.Context) error {
+	s.loadLazy()
+	if s.loadErr != nil {
+		return ErrSessionUnavailable
+	}
+	if err := s.Load(); err != nil {
+		return err
+	}
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if err := s.Options.validate(s.name); err != nil {
+		return err
+	}
 	return s.store.Save(c, s)
 }
 
+// SetMaxAge sets the MaxAge of the cookie and of the record of the session,
+// in seconds, such as for a "remember me" checkbox. Unlike replacing
+// Options, it is recorded in the session and applied on every later
+// request, with precedence over the Options of the middleware and store.
+// It does nothing for read-only sessions.
+func (s *Session) SetMaxAge(maxAge int) {
+	s.loadLazy()
+	if s.readOnly {
+		return
+	}
+	options := *s.Options
+	options.MaxAge = maxAge
+	s.Options = &options
+	s.Set(maxAgeKey, int64(maxAge))
+}
+
+// SetOptions replaces the cookie options of the session for the rest of the
+// request, and marks it modified so its cookie is issued again with them.
+// Unlike SetMaxAge, the options are not stored in the session. Options
+// rejected by Validate, or by the prefix of the cookie name, are returned
+// as errors and leave the session unchanged, as does ErrReadOnly for
+// read-only sessions.
+func (s *Session) SetOptions(options Options) error {
+	s.loadLazy()
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if err := options.validate(s.name); err != nil {
+		return err
+	}
+	s.Options = &options
+	s.dirty = true
+	return nil
+}
+
+// Touch extends the lifetime of the session, for sliding expiration. Stores
+// implementing Toucher only refresh the TTL of the record and the cookie;
+// with other stores, and for modified or new sessions, Touch is the same as
+// Save.
+func (s *Session) Touch(c *floki.Context) error {
+	s.loadLazy()
+	t, ok := s.store.(Toucher)
+	if !ok || s.dirty || s.ID == "" || s.IsNew {
+		return s.Save(c)
+	}
+	if err := s.Options.validate(s.name); err != nil {
+		return err
+	}
+	return t.Touch(c, s)
+}
+
+// RegenerateID saves the session under a new ID, keeping its values and
+// reissuing its cookie, then removes the record of the old ID if the store
+// implements Deleter. Call it whenever the privilege level of the session
+// changes, such as on login, to prevent session fixation.
+func (s *Session) RegenerateID(c *floki.Context) error {
+	if err := s.Load(); err != nil {
+		return err
+	}
+	old := s.ID
+	s.ID = ""
+	if s.Get(idIssuedKey) != nil {
+		s.Set(idIssuedKey, time.Now().Unix())
+	}
+	if err := s.Save(c); err != nil {
+		s.ID = old
+		return err
+	}
+	if old != "" {
+		audit(c, s, AuditRegenerated, "previous "+auditHash(old))
+	}
+	if d, ok := s.store.(Deleter); ok && old != "" && old != s.ID {
+		return d.DeleteID(old)
+	}
+	return nil
+}
+
+// SetAuthenticated records userID as the user the session belongs to and
+// marks a privilege transition: the middleware regenerates the session ID
+// when it saves the session at the end of the request, so every login or
+// elevation rotates the ID. Pass an empty userID on logout, which also
+// forgets the authentication recorded by RecordAuth and the claims of
+// SetUser.
+//
+// The time of login is recorded for Config.NotBefore.
+func (s *Session) SetAuthenticated(userID string) {
+	if userID == "" {
+		s.Delete(userIDKey)
+		s.Delete(loginTimeKey)
+		s.Delete(claimsKey)
+		s.Delete(authTimeKey)
+		s.Delete(authLevelKey)
+	} else {
+		s.Set(userIDKey, userID)
+		s.Set(loginTimeKey, time.Now().Unix())
+	}
+	s.rotate = true
+}
+
+// invalidate turns s into a new, empty session: the record of its ID is
+// removed if the store implements Deleter, and a new ID and cookie are
+// issued when it's saved.
+func (s *Session) invalidate() error {
+	var err error
+	if d, ok := s.store.(Deleter); ok && s.ID != "" {
+		err = d.DeleteID(s.ID)
+	}
+	s.clearValues()
+	s.ID = ""
+	s.IsNew = true
+	s.dirty = true
+	return err
+}
+
+// reject ends s and aborts the request with code, for the checks of the
+// middleware refusing a session: the record of s is deleted and its cookie
+// expired, but no new session is saved, so rejected requests leave nothing
+// behind in the store.
+func reject(c *floki.Context, s *Session, code int) {
+	s.invalidate()
+	expired := *s.Options
+	expired.MaxAge = -1
+	writeChunkedCookie(c, s.name, "", &expired, 0)
+	s.dirty = false
+	s.rejected = true
+	c.Abort(code)
+}
+
+// Destroy ends the session, such as on logout: its values are cleared, its
+// record is deleted if the store implements Deleter, and its cookie is
+// expired. The session is then new; values set afterwards, such as a flash
+// message, are saved in a new session. With Config.Tombstones, the ended
+// session is also recorded there. Read-only sessions are left alone, and
+// ErrReadOnly is returned.
+func (s *Session) Destroy(c *floki.Context) error {
+	s.loadLazy()
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	var err error
+	if d, ok := s.store.(Deleter); ok && s.ID != "" {
+		err = d.DeleteID(s.ID)
+	}
+	if t := s.tombstones; t != nil {
+		if id := s.RevocationID(); id != "" {
+			if terr := t.list.Revoke(id, t.ttl); err == nil {
+				err = terr
+			}
+		}
+	}
+	s.clearValues()
+	expired := *s.Options
+	expired.MaxAge = -1
+	writeChunkedCookie(c, s.name, "", &expired, 0)
+	s.ID = ""
+	s.IsNew = true
+	s.dirty = false
+	s.rotate = false
+	return err
+}
+
+// Discard drops the changes made to the session by the handlers of the
+// request and marks it unmodified, so a handler failing halfway through can
+// make sure none of its changes are saved. Values, Options and a pending ID
+// regeneration are restored; values changed in place, such as the elements
+// of a stored slice, and saves already made, are not undone.
+//
+// Sessions not obtained from the middleware have no recorded state; Discard
+// only marks them unmodified.
+func (s *Session) Discard() {
+	if s.saved != nil {
+		s.restore(s.saved)
+	}
+	s.dirty = false
+}
+
+// IsDirty reports whether the session was modified by the handlers of the
+// request, or has an ID regeneration pending, and will be saved at the end
+// of it.
+func (s *Session) IsDirty() bool {
+	s.syncWatched()
+	return s.dirty || s.rotate
+}
+
+// ChangedKeys returns the keys set, changed or deleted by the handlers of
+// the request, sorted like Keys. Values are compared with reflect.DeepEqual,
+// so values changed in place are not reported.
+//
+// Sessions not obtained from the middleware have no recorded state; all of
+// their keys are returned if IsDirty.
+func (s *Session) ChangedKeys() []interface{} {
+	var keys []interface{}
+	if s.saved == nil {
+		if s.dirty {
+			keys = s.Keys()
+		}
+		return keys
+	}
+	if s.raw != nil {
+		return nil
+	}
+	before := s.saved.values
+	if s.saved.raw != nil {
+		before = make(map[interface{}]interface{})
+		s.codec.Unmarshal(s.saved.raw, before)
+	}
+	for k, v := range s.Values {
+		if old, ok := before[k]; !ok || !reflect.DeepEqual(old, v) {
+			keys = append(keys, k)
+		}
+	}
+	for k := range before {
+		if _, ok := s.Values[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sortKeys(keys)
+	return keys
+}
+
 // Name returns the name used to register the session.
 func (s *Session) Name() string {
 	return s.name
@@ -152,19 +1243,228 @@ func (s *Session) Store() Store {
 }
 
 func (s *Session) Get(key interface{}) interface{} {
-	return s.Values[key]
+	s.Load()
+	return s.Values[s.key(key)]
 }
 
 func (s *Session) Set(key interface{}, val interface{}) {
-	s.Values[key] = val
+	s.Load()
+	if s.readOnly {
+		return
+	}
+	s.Values[s.key(key)] = val
 	s.dirty = true
 }
 
 func (s *Session) Delete(key interface{}) {
-	delete(s.Values, key)
+	s.Load()
+	if s.readOnly {
+		return
+	}
+	delete(s.Values, s.key(key))
+	s.dirty = true
+}
+
+// SetMany stores every value of values under its key, such as the fields
+// of a form wizard step.
+func (s *Session) SetMany(values map[string]interface{}) {
+	s.Load()
+	if s.readOnly {
+		return
+	}
+	for k, v := range values {
+		s.Values[s.key(k)] = v
+	}
+	s.dirty = true
+}
+
+// DeleteMany removes the values stored under keys.
+func (s *Session) DeleteMany(keys ...interface{}) {
+	s.Load()
+	if s.readOnly {
+		return
+	}
+	for _, k := range keys {
+		delete(s.Values, s.key(k))
+	}
+	s.dirty = true
+}
+
+// Has reports whether a value is stored under key, even a nil one. It
+// doesn't mark the session as modified.
+func (s *Session) Has(key interface{}) bool {
+	s.Load()
+	_, ok := s.Values[s.key(key)]
+	return ok
+}
+
+// GetDefault returns the value stored under key, or fallback if there is
+// none or it is nil.
+func (s *Session) GetDefault(key interface{}, fallback interface{}) interface{} {
+	if v := s.Get(key); v != nil {
+		return v
+	}
+	return fallback
+}
+
+// Pop returns the value stored under key and removes it, such as a URL to
+// redirect to after login. It returns nil, leaving the session unmodified,
+// if no value is stored under key. Read-only sessions keep the value.
+func (s *Session) Pop(key interface{}) interface{} {
+	s.Load()
+	k := s.key(key)
+	v, ok := s.Values[k]
+	if ok && !s.readOnly {
+		delete(s.Values, k)
+		s.dirty = true
+	}
+	return v
+}
+
+// GetOrSet returns the value stored under key or, if there is none, stores
+// and returns the value computed by compute, such as lazily initialized
+// per-user state. Compute is not called for keys holding a nil value. Like
+// the other methods of Session, it is not safe for concurrent use.
+func (s *Session) GetOrSet(key interface{}, compute func() interface{}) interface{} {
+	s.Load()
+	k := s.key(key)
+	if v, ok := s.Values[k]; ok {
+		return v
+	}
+	v := compute()
+	if !s.readOnly {
+		s.Values[k] = v
+		s.dirty = true
+	}
+	return v
+}
+
+// Increment adds delta to the integer stored under key, such as a count of
+// attempts, and returns the result, which is stored as an int64 whatever
+// the codec decoded the previous value as. Missing keys, and keys holding
+// something else than an integer, count from 0. Read-only sessions are
+// left alone, and Increment returns the stored value for them.
+func (s *Session) Increment(key interface{}, delta int64) int64 {
+	n, _ := int64Value(s.Get(key))
+	if s.readOnly {
+		return n
+	}
+	n += delta
+	s.Set(key, n)
+	return n
+}
+
+// Decrement subtracts delta from the integer stored under key and returns
+// the result. See Increment.
+func (s *Session) Decrement(key interface{}, delta int64) int64 {
+	return s.Increment(key, -delta)
+}
+
+// bookkeepingKeys are the keys of the values recorded by the middleware to
+// enforce timeouts and bindings, which Clear keeps.
+var bookkeepingKeys = []interface{}{
+	createdAtKey, renewedKey, lastSeenKey, idIssuedKey, cookieIssuedKey,
+	invalidAfterKey, epochKey, fingerprintKey, channelBindingKey, jtiKey,
+	lastIPKey, lastCountryKey, maxAgeKey, saveCountKey,
+}
+
+// Clear removes every value of the session but the ones stored under the
+// keys of keep, such as "_flash" to keep the pending flashes, and marks it
+// as modified. The user and authentication recorded in the session are
+// removed too; the values the middleware records to enforce timeouts and
+// bindings are kept, so clearing a session doesn't extend its lifetime.
+func (s *Session) Clear(keep ...interface{}) {
+	s.Load()
+	if s.readOnly {
+		return
+	}
+	kept := make(map[interface{}]bool, len(bookkeepingKeys)+len(keep))
+	for _, k := range append(bookkeepingKeys, keep...) {
+		kept[s.key(k)] = true
+	}
+	for k := range s.Values {
+		if !kept[k] {
+			delete(s.Values, k)
+		}
+	}
 	s.dirty = true
 }
 
+// Keys returns the keys of the values stored in the session, sorted by
+// their string form. The slice is a copy, so the session may be modified
+// while ranging over it. Keys starting with an underscore are used by this
+// package.
+func (s *Session) Keys() []interface{} {
+	s.Load()
+	keys := make([]interface{}, 0, len(s.Values))
+	for k := range s.Values {
+		keys = append(keys, k)
+	}
+	sortKeys(keys)
+	return keys
+}
+
+// Range calls fn with every key and value of the session, in the order of
+// Keys, until fn returns false. It iterates over a copy taken when it is
+// called, so fn may change the session and sees none of the changes.
+func (s *Session) Range(fn func(key, value interface{}) bool) {
+	keys := s.Keys()
+	values := make([]interface{}, len(keys))
+	for i, k := range keys {
+		values[i] = s.Values[k]
+	}
+	for i, k := range keys {
+		if !fn(k, values[i]) {
+			return
+		}
+	}
+}
+
+// sortKeys sorts keys by their string form.
+func sortKeys(keys []interface{}) {
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+}
+
+// Len returns the number of values stored in the session.
+func (s *Session) Len() int {
+	s.Load()
+	return len(s.Values)
+}
+
+// StringMap returns a copy of the values of the session stored under string
+// keys, the ones templates and JSON encoders can use. Changes to the map
+// are not applied to the session; see UpdateStringMap.
+func (s *Session) StringMap() map[string]interface{} {
+	s.Load()
+	m := make(map[string]interface{}, len(s.Values))
+	for k, v := range s.Values {
+		if key, ok := k.(string); ok {
+			m[key] = v
+		}
+	}
+	return m
+}
+
+// UpdateStringMap calls fn with the StringMap of the session, then writes
+// the values set, changed or deleted by fn back to the session.
+func (s *Session) UpdateStringMap(fn func(m map[string]interface{})) {
+	before := s.StringMap()
+	m := s.StringMap()
+	fn(m)
+	for k, v := range m {
+		if old, ok := before[k]; !ok || !reflect.DeepEqual(old, v) {
+			s.Set(k, v)
+		}
+	}
+	for k := range before {
+		if _, ok := m[k]; !ok {
+			s.Delete(k)
+		}
+	}
+}
+
 // Registry -------------------------------------------------------------------
 
 // sessionInfo stores a session tracked by the registry.
@@ -208,20 +1508,31 @@ func (s *Registry) Get(store Store, name string) (session *Session, err error) {
 	} else {
 		session, err = store.New(s.context, name)
 		session.name = name
+		_, presented := readChunkedCookie(s.context.Request, name)
+		session.setLoadResult(presented, err)
 		s.sessions[name] = sessionInfo{s: session, e: err}
 	}
 	session.store = store
 	return
 }
 
-// Save saves all sessions registered for the current request.
+// Save saves all sessions registered for the current request. Read-only
+// sessions, and the ones emptied for a store error, are skipped.
 func (s *Registry) Save(c *floki.Context) error {
 	var errMulti MultiError
 	for name, info := range s.sessions {
 		session := info.s
-		if session.store == nil {
+		if session.loadErr != nil || session.readOnly {
+			continue
+		} else if err := session.Load(); err != nil {
+			errMulti = append(errMulti, fmt.Errorf(
+				"sessions: error saving session %q -- %v", name, err))
+		} else if session.store == nil {
 			errMulti = append(errMulti, fmt.Errorf(
 				"sessions: missing store for session %q", name))
+		} else if err := session.Options.validate(name); err != nil {
+			errMulti = append(errMulti, fmt.Errorf(
+				"sessions: error saving session %q -- %v", name, err))
 		} else if err := session.store.Save(c, session); err != nil {
 			errMulti = append(errMulti, fmt.Errorf(
 				"sessions: error saving session %q -- %v", name, err))
@@ -240,6 +1551,17 @@ func init() {
 	gob.Register(floki.Model{})
 }
 
+// RegisterType registers the type of v so that values of that type can be
+// stored in a session encoded with gob, the default codec. Without it gob
+// fails to decode sessions holding custom structs.
+//
+// Register every type once, at startup:
+//
+//	sessions.RegisterType(User{})
+func RegisterType(v interface{}) {
+	gob.Register(v)
+}
+
 // Save saves all sessions used during the current request.
 func Save(c *floki.Context) error {
 	return GetRegistry(c).Save(c)
@@ -254,16 +1576,23 @@ func NewCookie(name, value string, options *Options) *http.Cookie {
 	}
 
 	cookie := &http.Cookie{
-		Name:     name,
-		Value:    value,
-		Path:     options.Path,
-		Domain:   options.Domain,
-		MaxAge:   options.MaxAge,
-		Secure:   options.Secure,
-		HttpOnly: options.HttpOnly,
+		Name:        name,
+		Value:       value,
+		Path:        options.Path,
+		Domain:      options.Domain,
+		MaxAge:      options.MaxAge,
+		Secure:      options.Secure,
+		HttpOnly:    options.HttpOnly,
+		SameSite:    options.SameSite,
+		Partitioned: options.Partitioned,
+	}
+	if cookie.SameSite == 0 {
+		cookie.SameSite = http.SameSiteLaxMode
 	}
 
-	if options.MaxAge > 0 {
+	if options.MaxAge > 0 && options.BrowserSession {
+		cookie.MaxAge = 0
+	} else if options.MaxAge > 0 {
 		d := time.Duration(options.MaxAge) * time.Second
 		cookie.Expires = time.Now().Add(d)
 	} else if options.MaxAge < 0 {
@@ -275,6 +1604,42 @@ func NewCookie(name, value string, options *Options) *http.Cookie {
 
 // Error ----------------------------------------------------------------------
 
+// ErrSessionTooLarge is returned by Save when the encoded session exceeds
+// the maximum length configured on the store or codec.
+var ErrSessionTooLarge = errors.New("sessions: the session is too large")
+
+// ErrSameSiteNoneInsecure is returned for Options with SameSite set to
+// http.SameSiteNoneMode but not Secure, which browsers reject.
+var ErrSameSiteNoneInsecure = errors.New("sessions: SameSite=None requires Secure")
+
+// ErrPartitionedInsecure is returned for Options with Partitioned set but
+// not Secure, which browsers reject.
+var ErrPartitionedInsecure = errors.New("sessions: Partitioned requires Secure")
+
+// ErrDomainInvalid is returned for Options whose Domain holds a port, a
+// path or other characters not allowed in a cookie domain.
+var ErrDomainInvalid = errors.New("sessions: Domain must be a bare host name")
+
+// ErrPathInvalid is returned for Options whose Path is not absolute or
+// holds characters not allowed in a cookie path.
+var ErrPathInvalid = errors.New("sessions: Path must start with /")
+
+// Errors matched, with errors.Is, by the decode errors of stores. See
+// DecodeError.
+var (
+	// ErrMACInvalid means the signature of a cookie or record does not
+	// match: it was tampered with or signed with an unknown key.
+	ErrMACInvalid = errors.New("sessions: the value is not valid")
+
+	// ErrExpired means a cookie or record is older than the maximum age of
+	// its codec.
+	ErrExpired = errors.New("sessions: the value has expired")
+
+	// ErrMalformed means a cookie or record could not be parsed, decrypted
+	// or decoded.
+	ErrMalformed = errors.New("sessions: the value is malformed")
+)
+
 // MultiError stores multiple errors.
 //
 // Borrowed from the App Engine SDK.
@@ -1,10 +1,26 @@
 package sessions
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-floki/floki"
+	"github.com/golang/protobuf/proto"
+	"github.com/gorilla/securecookie"
+	"html/template"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func Test_Sessions(t *testing.T) {
@@ -37,6 +53,3699 @@ func Test_Sessions(t *testing.T) {
 	f.ServeHTTP(res2, req2)
 }
 
+func Test_MemoryStore(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStoreWithShards(4, []byte("secret123"))
+	f.Use(Sessions("my_session1", store, nil))
+
+	f.GET("/testsession", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", "world")
+		c.Send(200, "OK")
+	})
+
+	f.GET("/show", func(c *floki.Context) {
+		session := Get(c)
+		if session.IsNew {
+			t.Error("Session was not found in the memory store")
+		}
+		if session.Get("hello") != "world" {
+			t.Error("Session writing failed")
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	f.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+}
+
+func Test_RegenerateID(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(Sessions("my_session1", store, nil))
+
+	var oldID, newID string
+	f.GET("/login", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", "world")
+		if err := session.Save(c); err != nil {
+			t.Fatal(err)
+		}
+		oldID = session.ID
+		if err := session.RegenerateID(c); err != nil {
+			t.Fatal(err)
+		}
+		newID = session.ID
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/login", nil)
+	f.ServeHTTP(res, req)
+
+	if oldID == "" || oldID == newID {
+		t.Fatal("Session ID was not regenerated")
+	}
+	old := NewSession(store, "my_session1")
+	old.ID = oldID
+	if ok, _ := store.load(old); ok {
+		t.Error("Record of the old session ID was not deleted")
+	}
+	current := NewSession(store, "my_session1")
+	current.ID = newID
+	if ok, _ := store.load(current); !ok || current.Get("hello") != "world" {
+		t.Error("Values were not saved under the new session ID")
+	}
+}
+
+func Test_DestroyAllForUser(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(Sessions("my_session1", store, nil))
+
+	f.GET("/login", func(c *floki.Context) {
+		Get(c).SetAuthenticated("alice")
+		c.Send(200, "OK")
+	})
+
+	for i := 0; i < 2; i++ {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/login", nil)
+		f.ServeHTTP(res, req)
+	}
+
+	ids, _ := store.UserSessionIDs("alice")
+	if len(ids) != 2 {
+		t.Fatalf("Expected 2 indexed sessions, got %d", len(ids))
+	}
+	if err := DestroyAllForUser(context.Background(), store, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		session := NewSession(store, "my_session1")
+		session.ID = id
+		if ok, _ := store.load(session); ok {
+			t.Error("Session of the user was not destroyed")
+		}
+	}
+	if ids, _ := store.UserSessionIDs("alice"); len(ids) != 0 {
+		t.Error("User index was not cleaned up")
+	}
+}
+
+func Test_MaxSessionsPerUser(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{MaxSessionsPerUser: 2}))
+
+	var ids []string
+	f.GET("/login", func(c *floki.Context) {
+		session := Get(c)
+		session.Set(createdAtKey, time.Now().Add(time.Duration(len(ids))*time.Minute).Unix())
+		session.SetAuthenticated("alice")
+		if err := session.Save(c); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, session.ID)
+		c.Send(200, "OK")
+	})
+
+	for i := 0; i < 3; i++ {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/login", nil)
+		f.ServeHTTP(res, req)
+	}
+
+	left, _ := store.UserSessionIDs("alice")
+	if len(left) != 2 {
+		t.Fatalf("Expected 2 sessions left, got %d", len(left))
+	}
+	for _, id := range left {
+		if id == ids[0] {
+			t.Error("Oldest session was not evicted")
+		}
+	}
+}
+
+func Test_NotBefore(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	notBefore := NewMemoryNotBeforeStore()
+	f.Use(SessionsWithConfig("my_session1", store, Config{NotBefore: notBefore}))
+
+	f.GET("/login", func(c *floki.Context) {
+		session := Get(c)
+		session.SetAuthenticated("alice")
+		session.Set(loginTimeKey, time.Now().Add(-time.Hour).Unix())
+		c.Send(200, "OK")
+	})
+
+	f.GET("/schedule", func(c *floki.Context) {
+		session := Get(c)
+		session.SetAuthenticated("bob")
+		session.InvalidateAfter(time.Now().Add(-time.Second))
+		c.Send(200, "OK")
+	})
+
+	f.GET("/show", func(c *floki.Context) {
+		if session := Get(c); !session.IsNew || sessionUserID(session) != "" {
+			t.Error("Invalidated session was loaded")
+		}
+		c.Send(200, "OK")
+	})
+
+	for _, path := range []string{"/login", "/schedule"} {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		f.ServeHTTP(res, req)
+
+		notBefore.SetNotBefore("alice", time.Now())
+
+		res2 := httptest.NewRecorder()
+		req2, _ := http.NewRequest("GET", "/show", nil)
+		req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+		f.ServeHTTP(res2, req2)
+	}
+}
+
+func Test_Epoch(t *testing.T) {
+	f := floki.Default()
+
+	store := NewCookieStore([]byte("secret123"))
+	epoch := &MemoryEpoch{}
+	f.Use(SessionsWithConfig("my_session1", store, Config{Epoch: epoch}))
+
+	f.GET("/testsession", func(c *floki.Context) {
+		Get(c).Set("hello", "world")
+		c.Send(200, "OK")
+	})
+
+	var hello interface{}
+	f.GET("/show", func(c *floki.Context) {
+		hello = Get(c).Get("hello")
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	f.ServeHTTP(res, req)
+	cookie := res.Header().Get("Set-Cookie")
+
+	show := func() {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/show", nil)
+		req.Header.Set("Cookie", cookie)
+		f.ServeHTTP(res, req)
+	}
+
+	show()
+	if hello != "world" {
+		t.Fatal("Session of the current epoch was not loaded")
+	}
+	epoch.BumpEpoch()
+	show()
+	if hello != nil {
+		t.Error("Session of an older epoch was loaded")
+	}
+}
+
+func Test_DeleteUserSessions(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(Sessions("my_session1", store, nil))
+
+	f.GET("/login", func(c *floki.Context) {
+		Get(c).SetAuthenticated("alice")
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/login", nil)
+	f.ServeHTTP(res, req)
+
+	if err := DeleteUserSessions(context.Background(), store, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.users["alice"]; ok {
+		t.Error("User was not removed from the index")
+	}
+	for _, shard := range store.shards {
+		if len(shard.records) != 0 {
+			t.Error("Session of the user was not deleted")
+		}
+	}
+}
+
+func Test_IDGenerator(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	store.IDs = PrefixedIDGenerator("app-", ULIDGenerator{})
+	f.Use(Sessions("my_session1", store, nil))
+
+	var id string
+	f.GET("/testsession", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", "world")
+		if err := session.Save(c); err != nil {
+			t.Fatal(err)
+		}
+		id = session.ID
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	f.ServeHTTP(res, req)
+
+	if len(id) != len("app-")+26 || id[:4] != "app-" {
+		t.Errorf("Unexpected session ID %q", id)
+	}
+	if id, _ := DefaultIDGenerator.NewID(); len(id) != 43 {
+		t.Errorf("Unexpected default session ID %q", id)
+	}
+}
+
+func Test_AbsoluteTimeout(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{AbsoluteTimeout: time.Hour}))
+
+	f.GET("/testsession", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", "world")
+		session.Set(createdAtKey, time.Now().Add(-2*time.Hour).Unix())
+		c.Send(200, "OK")
+	})
+
+	f.GET("/show", func(c *floki.Context) {
+		session := Get(c)
+		if !session.IsNew || session.Get("hello") != nil {
+			t.Error("Session older than AbsoluteTimeout was not replaced")
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	f.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+}
+
+func Test_IdleTimeout(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{IdleTimeout: time.Minute}))
+
+	f.GET("/testsession", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", "world")
+		session.Set(lastSeenKey, time.Now().Add(-2*time.Minute).Unix())
+		c.Send(200, "OK")
+	})
+
+	f.GET("/show", func(c *floki.Context) {
+		session := Get(c)
+		if !session.IsNew || session.Get("hello") != nil {
+			t.Error("Idle session was not replaced")
+		}
+		if _, ok := unixTime(session.Get(lastSeenKey)); !ok {
+			t.Error("Last activity was not recorded")
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	f.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+}
+
+func Test_ExpiryWarning(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	var warned time.Duration
+	f.Use(SessionsWithConfig("my_session1", store, Config{
+		AbsoluteTimeout: time.Hour,
+		ExpiryWarning:   5 * time.Minute,
+		OnExpiryWarning: func(c *floki.Context, s *Session, left time.Duration) {
+			warned = left
+		},
+	}))
+
+	f.GET("/testsession", func(c *floki.Context) {
+		session := Get(c)
+		if _, ok := ExpiringSoon(c); ok {
+			t.Error("New session is expiring soon")
+		}
+		session.Set(createdAtKey, time.Now().Add(-58*time.Minute).Unix())
+		c.Send(200, "OK")
+	})
+
+	f.GET("/show", func(c *floki.Context) {
+		if deadline, ok := ExpiringSoon(c); !ok || time.Until(deadline) > 2*time.Minute {
+			t.Errorf("Expiring session was not flagged: %v", deadline)
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	f.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+
+	if warned <= 0 || warned > 2*time.Minute {
+		t.Errorf("OnExpiryWarning was called with %v", warned)
+	}
+}
+
+func Test_StepUp(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(Sessions("my_session1", store, nil))
+
+	f.GET("/login", func(c *floki.Context) {
+		Get(c).RecordAuth(1)
+		c.Send(200, "OK")
+	})
+
+	reached := false
+	f.GET("/transfer", StepUp(time.Minute, 2, nil), func(c *floki.Context) {
+		reached = true
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/login", nil)
+	f.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/transfer", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+
+	if reached || res2.Code != http.StatusUnauthorized {
+		t.Error("Insufficient authentication level was not challenged")
+	}
+}
+
+func Test_Nonce(t *testing.T) {
+	s := NewSession(NewMemoryStore([]byte("secret123")), "my_session1")
+	nonce, err := s.IssueNonce("oauth-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ConsumeNonce("confirm-email", nonce) {
+		t.Error("Nonce was accepted for another purpose")
+	}
+	if !s.ConsumeNonce("oauth-state", nonce) {
+		t.Error("Nonce was not accepted")
+	}
+	if s.ConsumeNonce("oauth-state", nonce) {
+		t.Error("Nonce was accepted twice")
+	}
+
+	nonce, _ = s.IssueNonce("oauth-state")
+	s.SetReadOnly(true)
+	if _, err := s.IssueNonce("confirm-email"); err != ErrReadOnly {
+		t.Error("Unexpected error issuing a nonce in a read-only session:", err)
+	}
+	if s.ConsumeNonce("oauth-state", nonce) {
+		t.Error("Nonce was accepted without being consumed")
+	}
+}
+
+func Test_VaultKeyProvider(t *testing.T) {
+	var mu sync.Mutex
+	keys := "aGFzaDE=:YmxvY2tibG9ja2Jsb2NrMTY="
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" || r.URL.Path != "/v1/secret/data/sessions" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, `{"data":{"data":{"keys":%q}}}`, keys)
+	}))
+	defer vault.Close()
+
+	p, err := NewVaultKeyProvider(context.Background(), vault.URL, "token", "secret/data/sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p.Current().HashKey) != "hash1" {
+		t.Errorf("Unexpected hash key %q", p.Current().HashKey)
+	}
+
+	rotated := false
+	p.OnRotate(func(KeyPair) { rotated = true })
+	keys = "aGFzaDI=:YmxvY2tibG9ja2Jsb2NrMTY=," + keys
+	if err := p.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !rotated || string(p.Current().HashKey) != "hash2" || len(p.Previous()) != 1 {
+		t.Error("Key pairs were not refreshed from Vault")
+	}
+
+	// Refreshes started by Start report their errors, and so does a key
+	// ring given key pairs it can't use.
+	keys = "aGFzaDM=:MDEyMzQ1Njc4OWFiY2RlZg=="
+	if err := p.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ring, err := NewProviderKeyRing(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, _ := ring.Encode("my_session1", "world")
+	errs := make(chan error, 1)
+	p.OnError = func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+	for next, want := range map[string]string{"not base64!": "base64", "aGFzaDQ=:YmxvY2s=": "key size"} {
+		mu.Lock()
+		keys = next
+		mu.Unlock()
+		p.Start(10 * time.Millisecond)
+		timeout := time.After(time.Second)
+	wait:
+		for {
+			select {
+			case err := <-errs:
+				if strings.Contains(err.Error(), want) {
+					break wait
+				}
+			case <-timeout:
+				t.Errorf("Error of keys %q was not reported", next)
+				break wait
+			}
+		}
+		p.Stop()
+	}
+	var v string
+	if err := ring.Decode("my_session1", value, &v); err != nil || v != "world" {
+		t.Error("Key ring dropped its keys after a failed update:", err)
+	}
+}
+
+func Test_SetSecret(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{
+		SecretKeys: [][]byte{[]byte("0123456789abcdef")},
+	}))
+
+	f.GET("/testsession", func(c *floki.Context) {
+		session := Get(c)
+		if err := session.SetSecret("ssn", "078-05-1120"); err != nil {
+			t.Fatal(err)
+		}
+		if session.Get("ssn") == "078-05-1120" {
+			t.Error("Secret value was stored in plaintext")
+		}
+		c.Send(200, "OK")
+	})
+
+	f.GET("/show", func(c *floki.Context) {
+		v, err := Get(c).GetSecret("ssn")
+		if err != nil || v != "078-05-1120" {
+			t.Errorf("Secret value was not decrypted: %v, %v", v, err)
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	f.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+}
+
+func Test_LazyCreation(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{
+		LazyCreation:    true,
+		AbsoluteTimeout: time.Hour,
+		Fingerprint:     DefaultFingerprint,
+	}))
+
+	f.GET("/read", func(c *floki.Context) {
+		Get(c).Get("hello")
+		c.Send(200, "OK")
+	})
+
+	f.GET("/write", func(c *floki.Context) {
+		Get(c).Set("hello", "world")
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/read", nil)
+	f.ServeHTTP(res, req)
+	if cookie := res.Header().Get("Set-Cookie"); cookie != "" {
+		t.Errorf("Unwritten session was saved: %q", cookie)
+	}
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/write", nil)
+	f.ServeHTTP(res2, req2)
+	if res2.Header().Get("Set-Cookie") == "" {
+		t.Error("Written session was not saved")
+	}
+}
+
+func Test_CreationLimiter(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{
+		CreationLimiter: NewMemoryRateLimiter(2, time.Minute),
+	}))
+
+	f.GET("/testsession", func(c *floki.Context) {
+		Get(c).Set("hello", "world")
+		c.Send(200, "OK")
+	})
+
+	for i := 0; i < 3; i++ {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/testsession", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		f.ServeHTTP(res, req)
+		if cookie := res.Header().Get("Set-Cookie"); (cookie != "") != (i < 2) {
+			t.Errorf("Request %d: unexpected cookie %q", i, cookie)
+		}
+	}
+}
+
+func Test_RememberMe(t *testing.T) {
+	f := floki.Default()
+
+	var stolen string
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{
+		RememberMe: &RememberConfig{
+			Store:   NewMemoryRememberStore(),
+			OnTheft: func(c *floki.Context, userID string) { stolen = userID },
+		},
+	}))
+
+	f.GET("/login", func(c *floki.Context) {
+		Get(c).SetAuthenticated("alice")
+		if err := Remember(c, "alice"); err != nil {
+			t.Fatal(err)
+		}
+		c.Send(200, "OK")
+	})
+
+	var user string
+	f.GET("/show", func(c *floki.Context) {
+		user = sessionUserID(Get(c))
+		c.Send(200, "OK")
+	})
+
+	rememberCookie := func(res *httptest.ResponseRecorder) string {
+		for _, cookie := range res.Result().Cookies() {
+			if cookie.Name == "remember" {
+				return cookie.Name + "=" + cookie.Value
+			}
+		}
+		return ""
+	}
+	show := func(cookie string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/show", nil)
+		req.Header.Set("Cookie", cookie)
+		f.ServeHTTP(res, req)
+		return res
+	}
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/login", nil)
+	f.ServeHTTP(res, req)
+	first := rememberCookie(res)
+
+	res2 := show(first)
+	if user != "alice" || !strings.Contains(rememberCookie(res2), ":") {
+		t.Fatal("Session was not re-created from the remember-me token")
+	}
+
+	// The first cookie was rotated; presenting it again reveals a theft.
+	user = ""
+	show(first)
+	if user != "" || stolen != "alice" {
+		t.Error("Reuse of a rotated token was not detected")
+	}
+	if show(rememberCookie(res2)); user != "" {
+		t.Error("Token was not revoked after a theft")
+	}
+
+	f = floki.Default()
+	f.GET("/logout", func(c *floki.Context) {
+		if err := Forget(c); err != ErrNoRememberMe {
+			t.Error("Unexpected error without the middleware:", err)
+		}
+		c.Send(200, "OK")
+	})
+	res3 := httptest.NewRecorder()
+	req3, _ := http.NewRequest("GET", "/logout", nil)
+	f.ServeHTTP(res3, req3)
+}
+
+func Test_AuditSink(t *testing.T) {
+	var events []AuditEventType
+	RegisterAuditSink(AuditSinkFunc(func(e AuditEvent) {
+		if e.Session == "audited" {
+			events = append(events, e.Type)
+		}
+	}))
+
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("audited", store, Config{IdleTimeout: time.Minute}))
+
+	f.GET("/login", func(c *floki.Context) {
+		session := Get(c)
+		session.SetAuthenticated("alice")
+		session.Set(lastSeenKey, time.Now().Add(-2*time.Minute).Unix())
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/login", nil)
+	f.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/login", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+
+	expected := []AuditEventType{AuditCreated, AuditExpired, AuditCreated}
+	if fmt.Sprint(events) != fmt.Sprint(expected) {
+		t.Errorf("Expected events %v, got %v", expected, events)
+	}
+}
+
+func Test_DecodeError(t *testing.T) {
+	f := floki.Default()
+
+	store := NewCookieStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{OnDecodeError: RejectInvalidSession}))
+
+	f.GET("/show", func(c *floki.Context) {
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/show", nil)
+	req.Header.Set("Cookie", "my_session1=tampered")
+	f.ServeHTTP(res, req)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a tampered cookie, got %d", res.Code)
+	}
+
+	// Rejected requests leave no record behind in server-side stores.
+	memory := NewMemoryStore([]byte("secret123"))
+	f2 := floki.Default()
+	f2.Use(SessionsWithConfig("my_session1", memory, Config{OnDecodeError: RejectInvalidSession}))
+	f2.GET("/show", func(c *floki.Context) {
+		c.Send(200, "OK")
+	})
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", "my_session1=tampered")
+	f2.ServeHTTP(res2, req2)
+	if res2.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a tampered cookie, got %d", res2.Code)
+	}
+	for _, shard := range memory.shards {
+		if len(shard.records) != 0 {
+			t.Error("Rejected request saved a session")
+		}
+	}
+	if cookie := res2.Header().Get("Set-Cookie"); !strings.Contains(cookie, "Max-Age=0") {
+		t.Errorf("Cookie of the rejected session was not expired: %q", cookie)
+	}
+
+	s := NewSession(store, "my_session1")
+	err := decodeSecureValues("my_session1", "tampered", s.Values, nil, store.Codecs...)
+	var decodeErr *DecodeError
+	if !errors.Is(err, ErrMACInvalid) || !errors.As(err, &decodeErr) {
+		t.Errorf("Expected ErrMACInvalid, got %v", err)
+	}
+}
+
+func Test_Revocations(t *testing.T) {
+	f := floki.Default()
+
+	list := NewMemoryRevocationList()
+	store := NewCookieStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{Revocations: list}))
+
+	var id string
+	f.GET("/testsession", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", "world")
+		id = session.RevocationID()
+		c.Send(200, "OK")
+	})
+
+	var hello interface{}
+	var newID string
+	f.GET("/show", func(c *floki.Context) {
+		session := Get(c)
+		hello = session.Get("hello")
+		newID = session.RevocationID()
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	f.ServeHTTP(res, req)
+	if id == "" {
+		t.Fatal("Cookie session was not given a revocation ID")
+	}
+
+	show := func() {
+		hello, newID = nil, ""
+		res2 := httptest.NewRecorder()
+		req2, _ := http.NewRequest("GET", "/show", nil)
+		req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+		f.ServeHTTP(res2, req2)
+	}
+
+	show()
+	if hello != "world" || newID != id {
+		t.Errorf("Session was not kept before revocation: %v, %q", hello, newID)
+	}
+
+	list.Revoke(id, time.Minute)
+	show()
+	if hello != nil {
+		t.Error("Revoked session was not replaced")
+	}
+	if newID == "" || newID == id {
+		t.Errorf("Replacing session has revocation ID %q", newID)
+	}
+}
+
+func Test_Fingerprint(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{
+		Fingerprint:          DefaultFingerprint,
+		FingerprintTolerance: 1,
+	}))
+
+	f.GET("/testsession", func(c *floki.Context) {
+		Get(c).Set("hello", "world")
+		c.Send(200, "OK")
+	})
+
+	var hello interface{}
+	f.GET("/show", func(c *floki.Context) {
+		hello = Get(c).Get("hello")
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	req.Header.Set("User-Agent", "Firefox")
+	req.Header.Set("Accept-Language", "fr")
+	f.ServeHTTP(res, req)
+
+	for _, tt := range []struct {
+		userAgent, language string
+		kept                bool
+	}{
+		{"Firefox", "fr", true},
+		{"Firefox", "en", true},
+		{"curl", "en", false},
+	} {
+		hello = nil
+		res2 := httptest.NewRecorder()
+		req2, _ := http.NewRequest("GET", "/show", nil)
+		req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+		req2.Header.Set("User-Agent", tt.userAgent)
+		req2.Header.Set("Accept-Language", tt.language)
+		f.ServeHTTP(res2, req2)
+		if (hello == "world") != tt.kept {
+			t.Errorf("Unexpected session from %s/%s: %v", tt.userAgent, tt.language, hello)
+		}
+	}
+}
+
+func Test_ChannelBinding(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{ChannelBinding: ClientCertificateBinding}))
+
+	f.GET("/testsession", func(c *floki.Context) {
+		Get(c).Set("hello", "world")
+		c.Send(200, "OK")
+	})
+
+	withCert := func(req *http.Request, cert string) {
+		req.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{Raw: []byte(cert)}},
+		}
+	}
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	withCert(req, "client-a")
+	f.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/testsession", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	withCert(req2, "client-b")
+	f.ServeHTTP(res2, req2)
+	if res2.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another certificate, got %d", res2.Code)
+	}
+	for _, shard := range store.shards {
+		if len(shard.records) != 0 {
+			t.Error("Rejected request saved a session")
+		}
+	}
+}
+
+func Test_Anomaly(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{
+		Country: func(c *floki.Context) string { return c.Request.Header.Get("X-Country") },
+		Anomaly: func(c *floki.Context, s *Session, meta SessionMeta) Decision {
+			if meta.LastCountry != c.Request.Header.Get("X-Country") {
+				return Reauthenticate
+			}
+			return Allow
+		},
+	}))
+
+	f.GET("/login", func(c *floki.Context) {
+		Get(c).SetAuthenticated("alice")
+		c.Send(200, "OK")
+	})
+
+	var user string
+	f.GET("/show", func(c *floki.Context) {
+		user = sessionUserID(Get(c))
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/login", nil)
+	req.Header.Set("X-Country", "FR")
+	f.ServeHTTP(res, req)
+
+	for _, country := range []string{"FR", "NZ"} {
+		res2 := httptest.NewRecorder()
+		req2, _ := http.NewRequest("GET", "/show", nil)
+		req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+		req2.Header.Set("X-Country", country)
+		f.ServeHTTP(res2, req2)
+		if (user == "alice") != (country == "FR") {
+			t.Errorf("Unexpected user %q from %s", user, country)
+		}
+	}
+}
+
+func Test_AnomalyReject(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{
+		Country: func(c *floki.Context) string { return c.Request.Header.Get("X-Country") },
+		Anomaly: func(c *floki.Context, s *Session, meta SessionMeta) Decision {
+			if meta.LastCountry != "" && meta.LastCountry != c.Request.Header.Get("X-Country") {
+				return Reject
+			}
+			return Allow
+		},
+		RememberMe: &RememberConfig{Store: NewMemoryRememberStore()},
+	}))
+
+	f.GET("/login", func(c *floki.Context) {
+		Get(c).SetAuthenticated("alice")
+		if err := Remember(c, "alice"); err != nil {
+			t.Fatal(err)
+		}
+		c.Send(200, "OK")
+	})
+
+	var user string
+	f.GET("/show", func(c *floki.Context) {
+		user = sessionUserID(Get(c))
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/login", nil)
+	req.Header.Set("X-Country", "FR")
+	f.ServeHTTP(res, req)
+	var cookies []string
+	for _, cookie := range res.Result().Cookies() {
+		cookies = append(cookies, cookie.Name+"="+cookie.Value)
+	}
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", strings.Join(cookies, "; "))
+	req2.Header.Set("X-Country", "NZ")
+	f.ServeHTTP(res2, req2)
+	if res2.Code != http.StatusForbidden || user != "" {
+		t.Fatalf("Anomalous request was not rejected: %d %q", res2.Code, user)
+	}
+	for _, cookie := range res2.Result().Cookies() {
+		if cookie.Name == "remember" || cookie.MaxAge >= 0 {
+			t.Error("Rejected request issued a cookie:", cookie)
+		}
+	}
+	for _, shard := range store.shards {
+		if len(shard.records) != 0 {
+			t.Error("Rejected request saved a session")
+		}
+	}
+}
+
+func Test_AnomalyIdleTimeout(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	var lastSeen time.Time
+	f.Use(SessionsWithConfig("my_session1", store, Config{
+		IdleTimeout: time.Minute,
+		Anomaly: func(c *floki.Context, s *Session, meta SessionMeta) Decision {
+			lastSeen = meta.LastSeen
+			return Allow
+		},
+	}))
+
+	f.GET("/testsession", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", "world")
+		session.Set(lastSeenKey, time.Now().Add(-2*time.Minute).Unix())
+		c.Send(200, "OK")
+	})
+
+	f.GET("/show", func(c *floki.Context) {
+		session := Get(c)
+		if !session.IsNew || session.Get("hello") != nil {
+			t.Error("Idle session was not replaced")
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	f.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+	if !lastSeen.IsZero() {
+		t.Error("Anomaly was called for an expired session")
+	}
+}
+
+func Test_SecureCodec(t *testing.T) {
+	hashKey, blockKey := []byte("hash-key-0123456789"), []byte("0123456789abcdef")
+	codec, err := NewSecureCodec(hashKey, blockKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := securecookie.New(hashKey, blockKey)
+
+	values := map[interface{}]interface{}{"hello": "world"}
+	fromCodec, err := codec.Encode("my_session1", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromSC, err := sc.Encode("my_session1", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := make(map[interface{}]interface{})
+	if err := sc.Decode("my_session1", fromCodec, &decoded); err != nil || decoded["hello"] != "world" {
+		t.Errorf("securecookie failed to decode a SecureCodec value: %v", err)
+	}
+	decoded = make(map[interface{}]interface{})
+	if err := codec.Decode("my_session1", fromSC, &decoded); err != nil || decoded["hello"] != "world" {
+		t.Errorf("SecureCodec failed to decode a securecookie value: %v", err)
+	}
+
+	var v map[interface{}]interface{}
+	if err := codec.Decode("other_session", fromCodec, &v); !errors.Is(err, ErrMACInvalid) {
+		t.Errorf("Expected ErrMACInvalid for another cookie name, got %v", err)
+	}
+	tampered := []byte(fromCodec)
+	tampered[len(tampered)/2] ^= 1
+	if err := codec.Decode("my_session1", string(tampered), &v); err == nil {
+		t.Error("Tampered value was decoded")
+	}
+
+	jsonCodec, _ := NewSecureCodec(hashKey, nil)
+	jsonCodec.SetSerializer(JSONSerializer{})
+	encoded, err := jsonCodec.Encode("my_session1", map[string]string{"hello": "world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]string
+	if err := jsonCodec.Decode("my_session1", encoded, &m); err != nil || m["hello"] != "world" {
+		t.Errorf("Value was not decoded with JSONSerializer: %v", err)
+	}
+}
+
+func Test_GCMCodec(t *testing.T) {
+	codec, err := NewGCMCodec([]byte("a master secret of at least 32 bytes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := codec.Encode("my_session1", "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(encoded, "world") {
+		t.Error("Value was not encrypted")
+	}
+	var v string
+	if err := codec.Decode("my_session1", encoded, &v); err != nil || v != "world" {
+		t.Errorf("Value was not decoded: %q, %v", v, err)
+	}
+	if err := codec.Decode("other_session", encoded, &v); !errors.Is(err, ErrMACInvalid) {
+		t.Errorf("Expected ErrMACInvalid for another cookie name, got %v", err)
+	}
+	b := []byte(encoded)
+	b[len(b)-5] ^= 1
+	if err := codec.Decode("my_session1", string(b), &v); !errors.Is(err, ErrMACInvalid) {
+		t.Errorf("Expected ErrMACInvalid for a tampered value, got %v", err)
+	}
+	other, _ := NewGCMCodec([]byte("another master secret of 32 bytes"))
+	if err := other.Decode("my_session1", encoded, &v); !errors.Is(err, ErrMACInvalid) {
+		t.Errorf("Expected ErrMACInvalid for another secret, got %v", err)
+	}
+	if again, _ := codec.Encode("my_session1", "world"); again == encoded {
+		t.Error("Nonce was reused")
+	}
+}
+
+func Test_KeyRing(t *testing.T) {
+	oldPair := KeyPair{HashKey: []byte("old-hash-key"), BlockKey: []byte("0123456789abcdef")}
+	newPair := KeyPair{HashKey: []byte("new-hash-key"), BlockKey: []byte("fedcba9876543210")}
+	ring, err := NewKeyRing(oldPair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := ring.Encode("my_session1", "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ring.Rotate(newPair, 2); err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := ring.Encode("my_session1", "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{old, encoded} {
+		var v string
+		if err := ring.Decode("my_session1", value, &v); err != nil || v != "world" {
+			t.Errorf("Value was not decoded after rotation: %q, %v", v, err)
+		}
+	}
+	oldOnly, _ := NewKeyRing(oldPair)
+	var v string
+	if err := oldOnly.Decode("my_session1", encoded, &v); err == nil {
+		t.Error("New values were not signed with the newest key pair")
+	}
+
+	// Keeping a single pair drops the old one.
+	if err := ring.Rotate(newPair, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.Decode("my_session1", old, &v); err == nil {
+		t.Error("Value signed with a dropped key pair was decoded")
+	}
+
+	// MaxAge may be changed while values are decoded.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			ring.MaxAge(3600 + i)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		ring.Decode("my_session1", encoded, &v)
+	}
+	<-done
+}
+
+func Test_RotatingKeyProvider(t *testing.T) {
+	provider, err := NewRotatingKeyProvider(time.Hour, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring, err := NewProviderKeyRing(provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rotated int
+	provider.OnRotate(func(current KeyPair) { rotated++ })
+
+	first, err := ring.Encode("my_session1", "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if rotated != 1 || len(provider.Previous()) != 1 {
+		t.Fatal("Rotation was not recorded:", rotated, len(provider.Previous()))
+	}
+	second, _ := ring.Encode("my_session1", "world")
+	var v string
+	for _, value := range []string{first, second} {
+		if err := ring.Decode("my_session1", value, &v); err != nil || v != "world" {
+			t.Errorf("Value was not decoded after rotation: %q, %v", v, err)
+		}
+	}
+
+	// Only one previous key pair is kept.
+	if err := provider.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.Decode("my_session1", first, &v); err == nil {
+		t.Error("Value signed with an expired key pair was decoded")
+	}
+	if err := ring.Decode("my_session1", second, &v); err != nil {
+		t.Error("Value signed with the previous key pair was not decoded:", err)
+	}
+}
+
+func Test_SecureCodecMAC(t *testing.T) {
+	legacy, _ := NewSecureCodec([]byte("secret123"), nil)
+	codec, _ := NewSecureCodec([]byte("secret123"), nil)
+	codec.SetMAC(MACBLAKE2b)
+
+	old, err := legacy.Encode("my_session1", "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := codec.Encode("my_session1", "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{old, encoded} {
+		var v string
+		if err := codec.Decode("my_session1", value, &v); err != nil || v != "world" {
+			t.Errorf("Value was not decoded: %q, %v", v, err)
+		}
+	}
+	var v string
+	if err := legacy.Decode("my_session1", encoded, &v); err != nil {
+		t.Errorf("SHA-256 codec failed to verify a BLAKE2b value: %v", err)
+	}
+}
+
+func Test_EnableCompression(t *testing.T) {
+	store := NewMemoryStore([]byte("secret123"))
+	record := func(id string) []byte {
+		shard := store.shard(id)
+		shard.Lock()
+		defer shard.Unlock()
+		return shard.records[id].data
+	}
+
+	// A session written before compression was enabled.
+	plain := NewSession(store, "my_session1")
+	plain.ID = "a"
+	plain.Options = store.Options
+	plain.Set("hello", "world")
+	if err := store.save(plain); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := EnableCompressionThreshold(store, Gzip, 64); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableCompressionThreshold(store, Gzip, 64); err != nil {
+		t.Fatal(err)
+	}
+	s := NewSession(store, "my_session1")
+	s.ID = "b"
+	s.Options = store.Options
+	s.Set("hello", strings.Repeat("world", 100))
+	if err := store.save(s); err != nil {
+		t.Fatal(err)
+	}
+	data := record("b")
+	if len(data) < 3 || data[0] != payloadMagic || data[1] != compressedTag || data[2] != Gzip.ID() {
+		t.Fatal("Record was not compressed")
+	}
+	if inner, _ := Gzip.Decompress(data[3:]); len(inner) > 0 && inner[0] == payloadMagic {
+		t.Error("Record was compressed twice")
+	}
+
+	for id, want := range map[string]string{"a": "world", "b": strings.Repeat("world", 100)} {
+		loaded := NewSession(store, "my_session1")
+		loaded.ID = id
+		if ok, err := store.load(loaded); !ok || err != nil || loaded.Get("hello") != want {
+			t.Errorf("Record %s failed to load: %v", id, err)
+		}
+	}
+
+	if err := EnableCompression(NewCookieStore([]byte("secret123")), Gzip); err != ErrNoPayloadStore {
+		t.Error("Unexpected error for a store without payloads:", err)
+	}
+}
+
+func Test_EnableEncryption(t *testing.T) {
+	store := NewMemoryStore([]byte("secret123"))
+	oldKey, newKey := []byte("0123456789abcdef"), []byte("fedcba9876543210")
+	if err := EnableEncryption(store, oldKey); err != nil {
+		t.Fatal(err)
+	}
+	s := NewSession(store, "my_session1")
+	s.ID = "a"
+	s.Options = store.Options
+	s.Set("hello", "world")
+	if err := store.save(s); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate: new payloads are encrypted with the new key only.
+	if err := EnableEncryption(store, newKey, oldKey); err != nil {
+		t.Fatal(err)
+	}
+	s.ID = "b"
+	if err := store.save(s); err != nil {
+		t.Fatal(err)
+	}
+	shard := store.shard("b")
+	shard.Lock()
+	data := shard.records["b"].data
+	shard.Unlock()
+	if strings.Contains(string(data), "world") {
+		t.Error("Record was stored in clear")
+	}
+	newOnly, _ := newEncryptTransform(newKey)
+	if plain, err := newOnly.Decode(data); err != nil {
+		t.Error("Record was not encrypted with the new key:", err)
+	} else if len(plain) > 1 && plain[0] == payloadMagic && plain[1] == encryptedTag {
+		t.Error("Record was encrypted twice")
+	}
+
+	for _, id := range []string{"a", "b"} {
+		loaded := NewSession(store, "my_session1")
+		loaded.ID = id
+		if ok, err := store.load(loaded); !ok || err != nil || loaded.Get("hello") != "world" {
+			t.Errorf("Record %s failed to load: %v", id, err)
+		}
+	}
+
+	if err := EnableEncryption(store, newKey[:5]); err == nil {
+		t.Error("Invalid key was accepted")
+	}
+	if err := EnableEncryption(NewCookieStore([]byte("secret123")), newKey); err != ErrNoPayloadStore {
+		t.Error("Unexpected error for a store without payloads:", err)
+	}
+}
+
+func Test_LazyDecode(t *testing.T) {
+	store := NewMemoryStore([]byte("secret123"))
+	store.LazyDecode = true
+	s := NewSession(store, "my_session1")
+	s.ID = "a"
+	s.Options = store.Options
+	s.Set("hello", "world")
+	if err := store.save(s); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewSession(store, "my_session1")
+	loaded.ID = "a"
+	if ok, err := store.load(loaded); !ok || err != nil {
+		t.Fatalf("Record failed to load: %v", err)
+	}
+	if loaded.raw == nil || len(loaded.Values) != 0 {
+		t.Error("Payload was decoded on load")
+	}
+	if loaded.Get("hello") != "world" {
+		t.Error("Payload was not decoded on first use")
+	}
+	if loaded.raw != nil || loaded.IsDirty() {
+		t.Error("Unexpected state after decoding:", loaded.raw, loaded.IsDirty())
+	}
+
+	// Corrupt the record: the session fails to decode and is never saved
+	// over it.
+	shard := store.shard("a")
+	shard.Lock()
+	record := shard.records["a"]
+	record.data = append([]byte(nil), record.data[:len(record.data)/2]...)
+	shard.records["a"] = record
+	shard.Unlock()
+	broken := NewSession(store, "my_session1")
+	broken.ID = "a"
+	if ok, err := store.load(broken); !ok || err != nil {
+		t.Fatalf("Record failed to load: %v", err)
+	}
+	broken.Set("hello", "there")
+	if err := broken.Load(); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Expected ErrMalformed, got %v", err)
+	}
+	if err := store.save(broken); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Session failing to decode was saved: %v", err)
+	}
+	shard.Lock()
+	saved := shard.records["a"].data
+	shard.Unlock()
+	if string(saved) != string(record.data) {
+		t.Error("Record was overwritten")
+	}
+}
+
+func Test_IntegrityStore(t *testing.T) {
+	store := NewMemoryStore([]byte("secret123"))
+	IntegrityStore(store, []byte("checksum-key"), IntegrityDiscard)
+
+	s := NewSession(store, "my_session1")
+	s.ID = "a"
+	s.Options = store.Options
+	s.Set("hello", "world")
+	if err := store.save(s); err != nil {
+		t.Fatal(err)
+	}
+
+	// Copy the record of a to b, as an attacker with access to the backend.
+	sa, sb := store.shard("a"), store.shard("b")
+	sa.Lock()
+	record := sa.records["a"]
+	sa.Unlock()
+	sb.Lock()
+	sb.records["b"] = record
+	sb.Unlock()
+
+	loaded := NewSession(store, "my_session1")
+	loaded.ID = "a"
+	if ok, err := store.load(loaded); !ok || err != nil || loaded.Get("hello") != "world" {
+		t.Errorf("Record failed to load: %v", err)
+	}
+	copied := NewSession(store, "my_session1")
+	copied.ID = "b"
+	if _, err := store.load(copied); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("Expected ErrIntegrity for a copied record, got %v", err)
+	}
+}
+
+func Test_Strict(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(Strict("my_session1", store, []byte("0123456789abcdef0123456789abcdef")))
+
+	f.GET("/testsession", func(c *floki.Context) {
+		Get(c).Set("hello", "world")
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	f.ServeHTTP(res, req)
+
+	cookie := res.Header().Get("Set-Cookie")
+	for _, attr := range []string{"__Host-my_session1=", "Secure", "HttpOnly", "SameSite=Lax", "Path=/"} {
+		if !strings.Contains(cookie, attr) {
+			t.Errorf("Cookie %q lacks %s", cookie, attr)
+		}
+	}
+}
+
+func Test_Destroy(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(Sessions("my_session1", store, nil))
+
+	var id string
+	f.GET("/login", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", "world")
+		if err := session.Save(c); err != nil {
+			t.Fatal(err)
+		}
+		id = session.ID
+		c.Send(200, "OK")
+	})
+
+	f.GET("/logout", func(c *floki.Context) {
+		if err := Get(c).Destroy(c); err != nil {
+			t.Fatal(err)
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/login", nil)
+	f.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/logout", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+
+	if cookie := res2.Header().Get("Set-Cookie"); !strings.Contains(cookie, "Max-Age=0") {
+		t.Errorf("Cookie was not expired: %q", cookie)
+	}
+	s := NewSession(store, "my_session1")
+	s.ID = id
+	if ok, _ := store.load(s); ok {
+		t.Error("Record of the destroyed session was not deleted")
+	}
+}
+
+func Test_Tombstones(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{Tombstones: NewMemoryRevocationList()}))
+
+	f.GET("/login", func(c *floki.Context) {
+		Get(c).Set("hello", "world")
+		c.Send(200, "OK")
+	})
+
+	f.GET("/logout", func(c *floki.Context) {
+		if err := Get(c).Destroy(c); err != nil {
+			t.Fatal(err)
+		}
+		c.Send(200, "OK")
+	})
+
+	f.GET("/show", func(c *floki.Context) {
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/login", nil)
+	f.ServeHTTP(res, req)
+	cookie := res.Header().Get("Set-Cookie")
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/logout", nil)
+	req2.Header.Set("Cookie", cookie)
+	f.ServeHTTP(res2, req2)
+
+	res3 := httptest.NewRecorder()
+	req3, _ := http.NewRequest("GET", "/show", nil)
+	req3.Header.Set("Cookie", cookie)
+	f.ServeHTTP(res3, req3)
+
+	if res3.Code != http.StatusUnauthorized {
+		t.Errorf("Request with a destroyed session returned %d", res3.Code)
+	}
+}
+
+func Test_Touch(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(Sessions("my_session1", store, nil))
+
+	f.GET("/testsession", func(c *floki.Context) {
+		Get(c).Set("hello", "world")
+		c.Send(200, "OK")
+	})
+
+	var before, after time.Time
+	f.GET("/touch", func(c *floki.Context) {
+		session := Get(c)
+		shard := store.shard(session.ID)
+		before = shard.records[session.ID].expires
+		time.Sleep(10 * time.Millisecond)
+		if err := session.Touch(c); err != nil {
+			t.Fatal(err)
+		}
+		after = shard.records[session.ID].expires
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	f.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/touch", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+
+	if !after.After(before) || res2.Header().Get("Set-Cookie") == "" {
+		t.Error("Session lifetime was not extended")
+	}
+}
+
+func Test_RenewOnActivity(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{RenewOnActivity: true}))
+
+	f.GET("/testsession", func(c *floki.Context) {
+		Get(c).Set("hello", "world")
+		c.Send(200, "OK")
+	})
+
+	f.GET("/show", func(c *floki.Context) {
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	f.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+
+	if res2.Header().Get("Set-Cookie") == "" {
+		t.Error("Session cookie was not renewed")
+	}
+}
+
+func Test_SetAuthenticated(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(Sessions("my_session1", store, nil))
+
+	var ids []string
+	f.GET("/visit", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", "world")
+		if err := session.Save(c); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, session.ID)
+		c.Send(200, "OK")
+	})
+	f.GET("/login", func(c *floki.Context) {
+		Get(c).SetAuthenticated("alice")
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/visit", nil)
+	f.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/login", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+
+	old := NewSession(store, "my_session1")
+	old.ID = ids[0]
+	if ok, _ := store.load(old); ok {
+		t.Error("Session ID was not rotated on login")
+	}
+	userIDs, _ := store.UserSessionIDs("alice")
+	if len(userIDs) != 1 || userIDs[0] == ids[0] {
+		t.Fatal("Unexpected sessions of the user:", userIDs)
+	}
+	current := NewSession(store, "my_session1")
+	current.ID = userIDs[0]
+	if ok, _ := store.load(current); !ok || current.Get("hello") != "world" || sessionUserID(current) != "alice" {
+		t.Error("Session was not kept under the new ID")
+	}
+}
+
+func Test_RotateIDEvery(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{RotateIDEvery: time.Hour}))
+
+	var id string
+	f.GET("/testsession", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", "world")
+		session.Set(idIssuedKey, time.Now().Add(-2*time.Hour).Unix())
+		if err := session.Save(c); err != nil {
+			t.Fatal(err)
+		}
+		id = session.ID
+		c.Send(200, "OK")
+	})
+
+	f.GET("/show", func(c *floki.Context) {
+		if Get(c).Get("hello") != "world" {
+			t.Error("Session values were lost")
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	f.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+
+	if res2.Header().Get("Set-Cookie") == "" {
+		t.Fatal("Session ID was not rotated")
+	}
+	s := NewSession(store, "my_session1")
+	s.ID = id
+	if ok, _ := store.load(s); ok {
+		t.Error("Record of the old ID was not deleted")
+	}
+}
+
+func Test_CookieRefreshAfter(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{
+		IdleTimeout:        time.Hour,
+		CookieRefreshAfter: 0.5,
+	}))
+
+	f.GET("/testsession", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", "world")
+		session.Set(lastSeenKey, time.Now().Add(-time.Minute).Unix())
+		c.Send(200, "OK")
+	})
+
+	var id string
+	f.GET("/read", func(c *floki.Context) {
+		id = Get(c).ID
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	f.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/read", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+
+	if cookie := res2.Header().Get("Set-Cookie"); cookie != "" {
+		t.Errorf("Cookie of an unmodified session was reissued: %q", cookie)
+	}
+	s := NewSession(store, "my_session1")
+	s.ID = id
+	store.load(s)
+	if seen, _ := unixTime(s.Get(lastSeenKey)); time.Since(seen) > time.Second {
+		t.Error("Record of the session was not updated")
+	}
+}
+
+func Test_SetMaxAge(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(Sessions("my_session1", store, &Options{Path: "/", MaxAge: 3600}))
+
+	f.GET("/login", func(c *floki.Context) {
+		Get(c).SetMaxAge(86400 * 30)
+		c.Send(200, "OK")
+	})
+
+	f.GET("/show", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", "world")
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/login", nil)
+	f.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+
+	for _, r := range []*httptest.ResponseRecorder{res, res2} {
+		if cookie := r.Header().Get("Set-Cookie"); !strings.Contains(cookie, "Max-Age=2592000") {
+			t.Errorf("Per-session MaxAge was not applied: %q", cookie)
+		}
+	}
+}
+
+func Test_OnExpire(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(Sessions("my_session1", store, nil))
+
+	var expiredID string
+	var expiredValues map[interface{}]interface{}
+	store.OnExpire(func(id string, values map[interface{}]interface{}) {
+		expiredID, expiredValues = id, values
+	})
+
+	var id string
+	f.GET("/testsession", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", "world")
+		if err := session.Save(c); err != nil {
+			t.Fatal(err)
+		}
+		id = session.ID
+		c.Send(200, "OK")
+	})
+
+	f.GET("/show", func(c *floki.Context) {
+		if !Get(c).IsNew {
+			t.Error("Expired session was loaded")
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	f.ServeHTTP(res, req)
+
+	shard := store.shard(id)
+	record := shard.records[id]
+	record.expires = time.Now().Add(-time.Second)
+	shard.records[id] = record
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+
+	if expiredID != id || expiredValues["hello"] != "world" {
+		t.Errorf("OnExpire was not called with the expired session: %q %v", expiredID, expiredValues)
+	}
+}
+
+func Test_Janitor(t *testing.T) {
+	store := NewMemoryStoreWithShards(4)
+	expired := 0
+	store.OnExpire(func(id string, values map[interface{}]interface{}) {
+		expired++
+	})
+	for i := 0; i < 5; i++ {
+		session := NewSession(store, "my_session1")
+		session.ID = fmt.Sprint("expired", i)
+		session.Options = &Options{MaxAge: 60}
+		if err := store.save(session); err != nil {
+			t.Fatal(err)
+		}
+		shard := store.shard(session.ID)
+		record := shard.records[session.ID]
+		record.expires = time.Now().Add(-time.Second)
+		shard.records[session.ID] = record
+	}
+	live := NewSession(store, "my_session1")
+	live.ID = "live"
+	live.Options = &Options{MaxAge: 60}
+	if err := store.save(live); err != nil {
+		t.Fatal(err)
+	}
+
+	janitor := NewJanitor(store, JanitorConfig{BatchSize: 2})
+	n, err := janitor.Sweep(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 || expired != 5 {
+		t.Errorf("Sweep removed %d sessions and notified %d, want 5", n, expired)
+	}
+	if _, ok := store.shard("live").records["live"]; !ok {
+		t.Error("Sweep removed a live session")
+	}
+
+	janitor = NewJanitor(store, JanitorConfig{Interval: time.Millisecond})
+	janitor.Start()
+	time.Sleep(5 * time.Millisecond)
+	if err := janitor.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_FilesystemStoreDeleteExpired(t *testing.T) {
+	f := floki.Default()
+
+	store := NewFilesystemStore(t.TempDir(), []byte("secret123"))
+	store.Options.MaxAge = 60
+	f.Use(Sessions("my_session1", store, nil))
+
+	var id string
+	f.GET("/remember", func(c *floki.Context) {
+		session := Get(c)
+		session.SetMaxAge(86400 * 30)
+		session.Set("hello", "world")
+		if err := session.Save(c); err != nil {
+			t.Fatal(err)
+		}
+		id = session.ID
+		c.Send(200, "OK")
+	})
+	f.GET("/testsession", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", "world")
+		if err := session.Save(c); err != nil {
+			t.Fatal(err)
+		}
+		id = session.ID
+		c.Send(200, "OK")
+	})
+
+	var ids []string
+	for _, path := range []string{"/remember", "/testsession"} {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		f.ServeHTTP(res, req)
+		ids = append(ids, id)
+		past := time.Now().Add(-2 * time.Minute)
+		if err := os.Chtimes(store.path+"session_"+id, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := store.DeleteExpired(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("DeleteExpired removed %d sessions, want 1", n)
+	}
+	if _, err := os.Stat(store.path + "session_" + ids[0]); err != nil {
+		t.Error("Session with a longer MaxAge was removed:", err)
+	}
+	if _, err := os.Stat(store.path + "session_" + ids[1]); !os.IsNotExist(err) {
+		t.Error("Expired session was not removed:", err)
+	}
+
+	if err := store.DeleteID(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store.maxAgeFile(ids[0])); !os.IsNotExist(err) {
+		t.Error("MaxAge of a deleted session was kept:", err)
+	}
+}
+
+func Test_LoadResult(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(Sessions("my_session1", store, nil))
+
+	var id string
+	f.GET("/testsession", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", "world")
+		if err := session.Save(c); err != nil {
+			t.Fatal(err)
+		}
+		id = session.ID
+		c.Send(200, "OK")
+	})
+
+	var result LoadResult
+	f.GET("/show", func(c *floki.Context) {
+		result = Get(c).LoadResult()
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	f.ServeHTTP(res, req)
+	cookie := res.Header().Get("Set-Cookie")
+
+	show := func(cookie string) LoadResult {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/show", nil)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		f.ServeHTTP(res, req)
+		return result
+	}
+
+	if r := show(""); r != LoadNew {
+		t.Errorf("Request without cookie loaded %v", r)
+	}
+	if r := show(cookie); r != LoadFound {
+		t.Errorf("Saved session loaded %v", r)
+	}
+	if r := show("my_session1=garbage"); r != LoadInvalid {
+		t.Errorf("Invalid cookie loaded %v", r)
+	}
+	shard := store.shard(id)
+	record := shard.records[id]
+	record.expires = time.Now().Add(-time.Second)
+	shard.records[id] = record
+	if r := show(cookie); r != LoadExpired {
+		t.Errorf("Expired session loaded %v", r)
+	}
+	if r := show(cookie); r != LoadNotFound {
+		t.Errorf("Deleted session loaded %v", r)
+	}
+}
+
+func Test_BrowserSession(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(Sessions("my_session1", store, &Options{Path: "/", MaxAge: 600, BrowserSession: true}))
+
+	var id string
+	f.GET("/testsession", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", "world")
+		if err := session.Save(c); err != nil {
+			t.Fatal(err)
+		}
+		id = session.ID
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	f.ServeHTTP(res, req)
+
+	cookie := res.Header().Get("Set-Cookie")
+	if cookie == "" || strings.Contains(cookie, "Max-Age") || strings.Contains(cookie, "Expires") {
+		t.Errorf("Unexpected browser session cookie %q", cookie)
+	}
+	if expires := store.shard(id).records[id].expires; time.Until(expires) < 9*time.Minute {
+		t.Errorf("Record expires at %v, want in 10 minutes", expires)
+	}
+}
+
+func Test_ExpiryGrace(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	store.ExpiryGrace = time.Minute
+	f.Use(SessionsWithConfig("my_session1", store, Config{
+		IdleTimeout: time.Minute,
+		ExpiryGrace: time.Minute,
+	}))
+
+	var id string
+	f.GET("/testsession", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", "world")
+		session.Set(lastSeenKey, time.Now().Add(-90*time.Second).Unix())
+		if err := session.Save(c); err != nil {
+			t.Fatal(err)
+		}
+		id = session.ID
+		c.Send(200, "OK")
+	})
+
+	f.GET("/show", func(c *floki.Context) {
+		if Get(c).Get("hello") != "world" {
+			t.Error("Session within the grace period was not loaded")
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	f.ServeHTTP(res, req)
+
+	shard := store.shard(id)
+	record := shard.records[id]
+	record.expires = time.Now().Add(-time.Second)
+	shard.records[id] = record
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+
+	if !shard.records[id].expires.After(time.Now()) {
+		t.Error("Session within the grace period was not renewed")
+	}
+}
+
+func Test_TypedGettersRange(t *testing.T) {
+	for _, codec := range []Codec{nil, JSONCodec{}} {
+		f := floki.Default()
+
+		store := NewMemoryStore([]byte("secret123"))
+		store.ValueCodec = codec
+		f.Use(SessionsWithConfig("my_session1", store, Config{StringKeys: true}))
+
+		f.GET("/testsession", func(c *floki.Context) {
+			session := Get(c)
+			session.Set("whole", 3.0)
+			session.Set("fraction", 3.7)
+			session.Set("huge", uint64(math.MaxInt64)+1)
+			session.Set("big", 1e19)
+			c.Send(200, "OK")
+		})
+
+		f.GET("/show", func(c *floki.Context) {
+			session := Get(c)
+			if n, ok := session.GetInt64("whole"); !ok || n != 3 {
+				t.Errorf("GetInt64 returned %v, %v with %T", n, ok, codec)
+			}
+			for _, key := range []string{"fraction", "huge", "big"} {
+				if n, ok := session.GetInt64(key); ok {
+					t.Errorf("GetInt64 converted %s to %v with %T", key, n, codec)
+				}
+				if n, ok := session.GetInt(key); ok {
+					t.Errorf("GetInt converted %s to %v with %T", key, n, codec)
+				}
+			}
+			if n := session.Increment("fraction", 1); n != 1 {
+				t.Errorf("Increment counted from %v with %T", n-1, codec)
+			}
+			c.Send(200, "OK")
+		})
+
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/testsession", nil)
+		f.ServeHTTP(res, req)
+
+		res2 := httptest.NewRecorder()
+		req2, _ := http.NewRequest("GET", "/show", nil)
+		req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+		f.ServeHTTP(res2, req2)
+	}
+}
+
+func Test_TypedGetters(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	store.ValueCodec = JSONCodec{}
+	f.Use(SessionsWithConfig("my_session1", store, Config{StringKeys: true}))
+
+	now := time.Now().Truncate(time.Second)
+	f.GET("/testsession", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("uid", 42)
+		session.Set("name", "alice")
+		session.Set("admin", true)
+		session.Set("ratio", 0.5)
+		session.Set("at", now)
+		c.Send(200, "OK")
+	})
+
+	f.GET("/show", func(c *floki.Context) {
+		session := Get(c)
+		if uid, ok := session.GetInt("uid"); !ok || uid != 42 {
+			t.Errorf("GetInt returned %v, %v", uid, ok)
+		}
+		if uid, ok := session.GetInt64("uid"); !ok || uid != 42 {
+			t.Errorf("GetInt64 returned %v, %v", uid, ok)
+		}
+		if name, ok := session.GetString("name"); !ok || name != "alice" {
+			t.Errorf("GetString returned %v, %v", name, ok)
+		}
+		if admin, ok := session.GetBool("admin"); !ok || !admin {
+			t.Errorf("GetBool returned %v, %v", admin, ok)
+		}
+		if ratio, ok := session.GetFloat("ratio"); !ok || ratio != 0.5 {
+			t.Errorf("GetFloat returned %v, %v", ratio, ok)
+		}
+		if at, ok := session.GetTime("at"); !ok || !at.Equal(now) {
+			t.Errorf("GetTime returned %v, %v", at, ok)
+		}
+		if _, ok := session.GetInt("name"); ok {
+			t.Error("GetInt converted a string")
+		}
+		if _, ok := session.GetString("missing"); ok {
+			t.Error("GetString found a missing key")
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	f.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+}
+
+func Test_Has(t *testing.T) {
+	s := NewSession(NewMemoryStore(), "my_session1")
+	s.Values["nil"] = nil
+	if !s.Has("nil") || s.Has("missing") {
+		t.Error("Has did not tell stored keys from missing ones")
+	}
+	if s.dirty {
+		t.Error("Has marked the session as modified")
+	}
+}
+
+func Test_KeysLen(t *testing.T) {
+	s := NewSession(NewMemoryStore(), "my_session1")
+	s.Set("b", 2)
+	s.Set("a", 1)
+	keys := s.Keys()
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" || s.Len() != 2 {
+		t.Errorf("Unexpected keys %v", keys)
+	}
+}
+
+func Test_Clear(t *testing.T) {
+	s := NewSession(NewMemoryStore(), "my_session1")
+	s.Set("hello", "world")
+	s.Set(createdAtKey, int64(1))
+	s.SetAuthenticated("alice")
+	s.AddFlash("saved")
+	s.Clear(flashesKey)
+	if s.Has("hello") || sessionUserID(s) != "" {
+		t.Error("Values were not cleared")
+	}
+	if !s.Has(createdAtKey) || len(s.Flashes()) != 1 {
+		t.Error("Kept values were cleared")
+	}
+}
+
+func Test_Pop(t *testing.T) {
+	s := NewSession(NewMemoryStore(), "my_session1")
+	if s.Pop("next") != nil || s.dirty {
+		t.Error("Pop of a missing key modified the session")
+	}
+	s.Set("next", "/account")
+	if s.Pop("next") != "/account" || s.Has("next") {
+		t.Error("Pop did not return and remove the value")
+	}
+}
+
+func Test_GetOrSet(t *testing.T) {
+	s := NewSession(NewMemoryStore(), "my_session1")
+	calls := 0
+	compute := func() interface{} {
+		calls++
+		return "cart"
+	}
+	if s.GetOrSet("cart", compute) != "cart" || s.GetOrSet("cart", compute) != "cart" {
+		t.Error("GetOrSet did not return the computed value")
+	}
+	if calls != 1 || s.Get("cart") != "cart" {
+		t.Errorf("Value was computed %d times", calls)
+	}
+}
+
+func Test_Increment(t *testing.T) {
+	s := NewSession(NewMemoryStore(), "my_session1")
+	if n := s.Increment("attempts", 2); n != 2 {
+		t.Errorf("Increment of a missing key returned %d", n)
+	}
+	s.Set("attempts", json.Number("5"))
+	if n := s.Increment("attempts", 1); n != 6 {
+		t.Errorf("Increment of a decoded number returned %d", n)
+	}
+	if n := s.Decrement("attempts", 4); n != 2 || s.Get("attempts") != int64(2) {
+		t.Errorf("Decrement returned %d", n)
+	}
+}
+
+func Test_BindPut(t *testing.T) {
+	type wizard struct {
+		Step   int    `session:"wizard_step"`
+		Email  string `session:"wizard_email,omitempty"`
+		Secret string `session:"-"`
+		Tags   []string
+	}
+
+	s := NewSession(NewMemoryStore(), "my_session1")
+	if err := s.Put(wizard{Step: 2, Secret: "x", Tags: []string{"a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if s.Has("wizard_email") || s.Has("Secret") || s.Get("wizard_step") != 2 {
+		t.Errorf("Unexpected values %v", s.Values)
+	}
+
+	s.Set("wizard_step", json.Number("3"))
+	s.Set("Tags", []interface{}{"b", "c"})
+	var w wizard
+	if err := s.Bind(&w); err != nil {
+		t.Fatal(err)
+	}
+	if w.Step != 3 || len(w.Tags) != 2 || w.Tags[1] != "c" {
+		t.Errorf("Unexpected bound struct %+v", w)
+	}
+	if err := s.Bind(w); err == nil {
+		t.Error("Bind accepted a struct value")
+	}
+}
+
+func Test_BindRange(t *testing.T) {
+	type counters struct {
+		Small int8
+		Count uint
+		Step  int
+	}
+
+	for key, v := range map[string]interface{}{"Small": 300, "Count": -1, "Step": 3.7} {
+		s := NewSession(NewMemoryStore(), "my_session1")
+		s.Set(key, v)
+		var c counters
+		if err := s.Bind(&c); err == nil {
+			t.Errorf("Bind stored %v in %s: %+v", v, key, c)
+		}
+	}
+
+	s := NewSession(NewMemoryStore(), "my_session1")
+	s.Set("Small", json.Number("-128"))
+	s.Set("Count", float64(7))
+	var c counters
+	if err := s.Bind(&c); err != nil || c.Small != -128 || c.Count != 7 {
+		t.Errorf("Bind returned %+v, %v", c, err)
+	}
+}
+
+func Test_Scope(t *testing.T) {
+	s := NewSession(NewMemoryStore(), "my_session1")
+	s.Set("items", "outside")
+	cart := s.Scope("cart")
+	cart.Set("items", 3)
+	cart.Scope("coupon").Set("code", "SAVE")
+	if s.Get("cart.items") != 3 || s.Get("cart.coupon.code") != "SAVE" {
+		t.Errorf("Unexpected values %v", s.Values)
+	}
+	if keys := cart.Keys(); len(keys) != 2 || keys[0] != "coupon.code" || keys[1] != "items" {
+		t.Errorf("Unexpected scope keys %v", keys)
+	}
+	cart.Clear()
+	if s.Len() != 1 || s.Get("items") != "outside" {
+		t.Errorf("Clear of the scope removed %v", s.Values)
+	}
+}
+
+func Test_GetDefault(t *testing.T) {
+	s := NewSession(NewMemoryStore(), "my_session1")
+	s.Set("lang", "fr")
+	if s.GetDefault("lang", "en") != "fr" || s.GetDefault("theme", "dark") != "dark" {
+		t.Error("GetDefault returned unexpected values")
+	}
+}
+
+func Test_SetManyDeleteMany(t *testing.T) {
+	s := NewSession(NewMemoryStore(), "my_session1")
+	s.SetMany(map[string]interface{}{"name": "alice", "email": "a@example.com", "step": 2})
+	if s.Len() != 3 || s.Get("name") != "alice" {
+		t.Errorf("Unexpected values %v", s.Values)
+	}
+	s.DeleteMany("name", "email")
+	if s.Len() != 1 || !s.Has("step") {
+		t.Errorf("Unexpected values %v", s.Values)
+	}
+}
+
+func Test_FlashLevels(t *testing.T) {
+	s := NewSession(NewMemoryStore(), "my_session1")
+	s.AddFlashLevel(FlashSuccess, "saved")
+	s.AddFlashLevel(FlashError, "failed")
+	s.AddFlashLevel(FlashError, "retry")
+	s.AddFlash("plain")
+	levels := s.FlashesByLevel()
+	if len(levels) != 2 || len(levels[FlashSuccess]) != 1 || len(levels[FlashError]) != 2 {
+		t.Errorf("Unexpected flashes %v", levels)
+	}
+	if len(s.FlashesByLevel()) != 0 || len(s.Flashes()) != 1 {
+		t.Error("Flashes by level were not consumed alone")
+	}
+}
+
+func Test_PeekFlashes(t *testing.T) {
+	s := NewSession(NewMemoryStore(), "my_session1")
+	s.AddFlash("saved")
+	if flashes := s.PeekFlashes(); len(flashes) != 1 || flashes[0] != "saved" {
+		t.Errorf("Unexpected flashes %v", flashes)
+	}
+	if len(s.Flashes()) != 1 {
+		t.Error("PeekFlashes consumed the flashes")
+	}
+}
+
+func Test_FlashMessages(t *testing.T) {
+	s := NewSession(NewMemoryStore(), "my_session1")
+	s.AddFlashMessage(Flash{Level: FlashError, Title: "Oops", Body: "<failed>"})
+	s.Values[flashMessagesKey] = append(s.Values[flashMessagesKey].([]interface{}),
+		map[string]interface{}{"Level": "info", "Body": "decoded"})
+
+	var buf strings.Builder
+	tmpl := template.Must(template.New("page").Funcs(FlashFuncs()).Parse("{{renderFlashes .}}"))
+	if err := tmpl.Execute(&buf, s); err != nil {
+		t.Fatal(err)
+	}
+	want := `<div class="alert alert-danger" role="alert"><strong>Oops</strong> &lt;failed&gt;</div>` +
+		`<div class="alert alert-info" role="alert">decoded</div>`
+	if buf.String() != want {
+		t.Errorf("Unexpected rendering %q", buf.String())
+	}
+	if len(s.FlashMessages()) != 0 {
+		t.Error("Rendering did not consume the flashes")
+	}
+}
+
+func Test_ExposeFlashes(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{ExposeFlashes: "flashes"}))
+
+	f.GET("/save", func(c *floki.Context) {
+		session := Get(c)
+		session.AddFlash("plain")
+		session.AddFlashLevel(FlashSuccess, "saved")
+		session.AddFlashMessage(Flash{Body: "structured"})
+		c.Send(200, "OK")
+	})
+
+	f.GET("/show", func(c *floki.Context) {
+		exposed, _ := c.Get("flashes")
+		flashes := exposed.(ExposedFlashes)
+		if len(flashes.Messages) != 1 || len(flashes.Levels[FlashSuccess]) != 1 ||
+			len(flashes.Structured) != 1 {
+			t.Errorf("Unexpected exposed flashes %+v", flashes)
+		}
+		if len(Get(c).PeekFlashes()) != 0 {
+			t.Error("Exposed flashes were not consumed")
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/save", nil)
+	f.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+}
+
+func Test_SetOptions(t *testing.T) {
+	for _, o := range []Options{
+		{SameSite: http.SameSiteNoneMode},
+		{Domain: "example.com:8080"},
+		{Path: "app"},
+	} {
+		if o.Validate() == nil {
+			t.Errorf("Options %+v were accepted", o)
+		}
+	}
+	if (&Options{Path: "/app", Domain: "example.com", SameSite: http.SameSiteNoneMode, Secure: true}).Validate() != nil {
+		t.Error("Valid options were rejected")
+	}
+
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(Sessions("my_session1", store, nil))
+
+	f.GET("/get", func(c *floki.Context) {
+		session := Get(c)
+		if session.SetOptions(Options{Path: "app"}) == nil {
+			t.Error("Invalid options were set")
+		}
+		if err := session.SetOptions(Options{Path: "/app", MaxAge: 60}); err != nil {
+			t.Error(err)
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/get", nil)
+	f.ServeHTTP(res, req)
+
+	if cookie := res.Header().Get("Set-Cookie"); !strings.Contains(cookie, "Path=/app") ||
+		!strings.Contains(cookie, "Max-Age=60") {
+		t.Error("Options were not applied to the cookie:", cookie)
+	}
+}
+
+func Test_Discard(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(Sessions("my_session1", store, nil))
+
+	f.GET("/set", func(c *floki.Context) {
+		Get(c).Set("hello", "world")
+		c.Send(200, "OK")
+	})
+
+	f.GET("/discard", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", "changed")
+		session.Set("partial", true)
+		session.SetAuthenticated("alice")
+		session.Discard()
+		if session.Get("hello") != "world" || session.Has("partial") {
+			t.Error("Changes were not discarded:", session.Values)
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/set", nil)
+	f.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/discard", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+
+	if res2.Header().Get("Set-Cookie") != "" {
+		t.Error("Discarded session was saved")
+	}
+}
+
+func Test_ChangedKeys(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(Sessions("my_session1", store, nil))
+
+	f.GET("/set", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("a", 1)
+		session.Set("b", 2)
+		session.Set("c", 3)
+		c.Send(200, "OK")
+	})
+
+	f.GET("/change", func(c *floki.Context) {
+		session := Get(c)
+		if session.IsDirty() || len(session.ChangedKeys()) != 0 {
+			t.Error("Loaded session is dirty:", session.ChangedKeys())
+		}
+		session.Set("a", 1)
+		session.Set("b", 20)
+		session.Delete("c")
+		session.Set("d", 4)
+		if !session.IsDirty() {
+			t.Error("Modified session is not dirty")
+		}
+		if keys := fmt.Sprint(session.ChangedKeys()); keys != "[b c d]" {
+			t.Error("Unexpected changed keys:", keys)
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/set", nil)
+	f.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/change", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+}
+
+func Test_BeginRollback(t *testing.T) {
+	store := NewMemoryStore([]byte("secret123"))
+	session := NewSession(store, "my_session1")
+	session.Set("kept", 1)
+
+	tx := session.Begin()
+	session.Set("kept", 2)
+	session.Set("tentative", true)
+	tx.Rollback()
+	if session.Get("kept") != 1 || session.Has("tentative") {
+		t.Error("Changes were not rolled back:", session.Values)
+	}
+
+	tx = session.Begin()
+	session.Set("committed", true)
+	tx.Commit()
+	tx.Rollback()
+	if !session.Has("committed") {
+		t.Error("Committed changes were rolled back")
+	}
+}
+
+func Test_Detach(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(Sessions("my_session1", store, nil))
+
+	done := make(chan struct{})
+	f.GET("/start", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("job", "pending")
+		if err := session.Save(c); err != nil {
+			t.Error(err)
+		}
+		d := session.Detach()
+		go func() {
+			defer close(done)
+			ctx := context.Background()
+			if err := d.Load(ctx); err != nil {
+				t.Error(err)
+			}
+			d.Set("job", "done")
+			if err := d.Save(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+		c.Send(200, "OK")
+	})
+
+	f.GET("/check", func(c *floki.Context) {
+		if job := Get(c).Get("job"); job != "done" {
+			t.Error("Detached changes were not saved:", job)
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/start", nil)
+	f.ServeHTTP(res, req)
+	<-done
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/check", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+
+	cookies := NewCookieStore([]byte("secret123"))
+	if NewSession(cookies, "my_session1").Detach().Save(context.Background()) != ErrNotDetachable {
+		t.Error("Cookie sessions were detached")
+	}
+}
+
+func Test_Require(t *testing.T) {
+	store := NewMemoryStore([]byte("secret123"))
+	session := NewSession(store, "my_session1")
+	session.Set("name", "alice")
+	session.Set("count", int64(3))
+
+	if v, err := session.RequireString("name"); err != nil || v != "alice" {
+		t.Error("Unexpected required string:", v, err)
+	}
+	if v, err := session.RequireInt("count"); err != nil || v != 3 {
+		t.Error("Unexpected required int:", v, err)
+	}
+	if _, err := session.Require("missing"); !errors.Is(err, ErrRequired) ||
+		err.Error() != "sessions: required value missing is missing" {
+		t.Error("Unexpected error for a missing value:", err)
+	}
+	if _, err := session.RequireBool("name"); !errors.Is(err, ErrRequired) ||
+		err.Error() != "sessions: required value name is not a boolean" {
+		t.Error("Unexpected error for a value of another type:", err)
+	}
+	if _, err := session.RequireUserID(); !errors.Is(err, ErrRequired) {
+		t.Error("Anonymous session has a user:", err)
+	}
+	session.SetAuthenticated("alice")
+	if id, err := session.RequireUserID(); err != nil || id != "alice" {
+		t.Error("Unexpected required user:", id, err)
+	}
+}
+
+func Test_StringMap(t *testing.T) {
+	store := NewMemoryStore([]byte("secret123"))
+	session := NewSession(store, "my_session1")
+	session.Set("name", "alice")
+	session.Set("old", true)
+	session.Set(1, "not a string key")
+
+	m := session.StringMap()
+	if len(m) != 2 || m["name"] != "alice" {
+		t.Error("Unexpected string map:", m)
+	}
+	m["name"] = "bob"
+	if session.Get("name") != "alice" {
+		t.Error("StringMap is not a copy")
+	}
+
+	session.dirty = false
+	session.UpdateStringMap(func(m map[string]interface{}) {
+		m["name"] = "bob"
+		delete(m, "old")
+	})
+	if session.Get("name") != "bob" || session.Has("old") || session.Get(1) == nil {
+		t.Error("Changes were not written back:", session.Values)
+	}
+	if !session.IsDirty() {
+		t.Error("Updated session is not dirty")
+	}
+}
+
+func Test_Clone(t *testing.T) {
+	type cart struct {
+		Items []string
+	}
+
+	store := NewMemoryStore([]byte("secret123"))
+	session := NewSession(store, "my_session1")
+	session.ID = "id"
+	session.Options = &Options{Path: "/", MaxAge: 60}
+	session.Set("cart", &cart{Items: []string{"book"}})
+	session.Set("tags", map[string]int{"a": 1})
+
+	clone := session.Clone()
+	clone.Get("cart").(*cart).Items[0] = "pen"
+	clone.Get("tags").(map[string]int)["b"] = 2
+	clone.Set("name", "bob")
+	clone.Options.MaxAge = 10
+
+	if session.Get("cart").(*cart).Items[0] != "book" || len(session.Get("tags").(map[string]int)) != 1 ||
+		session.Has("name") || session.Options.MaxAge != 60 {
+		t.Error("Clone aliases the session:", session.Values, session.Options)
+	}
+	if clone.ID != "id" || clone.Name() != "my_session1" {
+		t.Error("Clone lost the metadata of the session")
+	}
+}
+
+func Test_MarshalJSON(t *testing.T) {
+	RegisterSensitive("token")
+
+	store := NewMemoryStore([]byte("secret123"))
+	session := NewSession(store, "my_session1")
+	session.ID = "secret-id"
+	session.Set("name", "alice")
+	session.Set("token", "abc")
+	session.Set(1, "one")
+	session.Set(jtiKey, "jti-value")
+	session.Set(channelBindingKey, "cb-value")
+	session.Set(noncePrefix+"reset", "nonce-value")
+
+	b, err := json.Marshal(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "secret-id") || strings.Contains(string(b), "abc") ||
+		strings.Contains(string(b), "-value") {
+		t.Error("Sensitive data was marshaled:", string(b))
+	}
+
+	decoded := NewSession(store, "my_session1")
+	if err := json.Unmarshal(b, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Get("name") != "alice" || decoded.Get("1") != "one" || decoded.Get("token") != "[redacted]" {
+		t.Error("Unexpected unmarshaled values:", decoded.Values)
+	}
+}
+
+func Test_TrackAccess(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{TrackAccess: true}))
+
+	var saves int
+	f.GET("/get", func(c *floki.Context) {
+		session := Get(c)
+		if session.CreatedAt().IsZero() || session.LastAccessedAt().IsZero() {
+			t.Error("Access times were not recorded")
+		}
+		saves = session.SaveCount()
+		session.Set("hello", "world")
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/get", nil)
+	f.ServeHTTP(res, req)
+	if saves != 0 {
+		t.Error("New session was saved", saves, "times")
+	}
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/get", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+	if saves != 1 {
+		t.Error("Unexpected save count:", saves)
+	}
+}
+
+func Test_SetUser(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(Sessions("my_session1", store, nil))
+
+	f.GET("/login", func(c *floki.Context) {
+		session := Get(c)
+		if session.IsAuthenticated() {
+			t.Error("New session is authenticated")
+		}
+		session.SetUser("alice", map[string]interface{}{"role": "admin"})
+		c.Send(200, "OK")
+	})
+
+	f.GET("/logout", func(c *floki.Context) {
+		session := Get(c)
+		if session.UserID() != "alice" || session.Claim("role") != "admin" {
+			t.Error("Unexpected user:", session.UserID(), session.Claims())
+		}
+		if err := session.Logout(c); err != nil {
+			t.Error(err)
+		}
+		if session.IsAuthenticated() || session.Claims() != nil {
+			t.Error("Session is still authenticated")
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/login", nil)
+	f.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/logout", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+
+	if !strings.Contains(res2.Header().Get("Set-Cookie"), "Max-Age=0") {
+		t.Error("Logout did not expire the cookie:", res2.Header().Get("Set-Cookie"))
+	}
+}
+
+func Test_Range(t *testing.T) {
+	store := NewMemoryStore([]byte("secret123"))
+	session := NewSession(store, "my_session1")
+	session.Set("a", 1)
+	session.Set("b", 2)
+	session.Set("c", 3)
+
+	var seen []interface{}
+	session.Range(func(k, v interface{}) bool {
+		seen = append(seen, k)
+		session.Delete("c")
+		return k != "c"
+	})
+	if fmt.Sprint(seen) != "[a b c]" {
+		t.Error("Unexpected iteration:", seen)
+	}
+
+	seen = nil
+	session.Range(func(k, v interface{}) bool {
+		seen = append(seen, k)
+		return false
+	})
+	if len(seen) != 1 {
+		t.Error("Range did not stop:", seen)
+	}
+}
+
+func Test_TryGet(t *testing.T) {
+	f := floki.Default()
+
+	f.GET("/get", func(c *floki.Context) {
+		if _, ok := TryGet(c); ok {
+			t.Error("Session found without the middleware")
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/get", nil)
+	f.ServeHTTP(res, req)
+
+	f = floki.Default()
+	f.Use(Sessions("my_session1", NewMemoryStore([]byte("secret123")), nil))
+	f.GET("/get", func(c *floki.Context) {
+		if s, ok := TryGet(c); !ok || s != Get(c) {
+			t.Error("Session of the middleware not found")
+		}
+		c.Send(200, "OK")
+	})
+
+	res2 := httptest.NewRecorder()
+	f.ServeHTTP(res2, req)
+}
+
+// failingStore is a MemoryStore failing to load sessions, like a store
+// whose backend is down.
+type failingStore struct {
+	*MemoryStore
+}
+
+func (s failingStore) New(c *floki.Context, name string) (*Session, error) {
+	session, _ := s.MemoryStore.New(c, name)
+	session.Set("partial", true)
+	return session, errors.New("backend is down")
+}
+
+func Test_OnError(t *testing.T) {
+	f := floki.Default()
+
+	store := failingStore{NewMemoryStore([]byte("secret123"))}
+	f.Use(Sessions("my_session1", store, nil))
+
+	f.GET("/get", func(c *floki.Context) {
+		session := Get(c)
+		if session.LoadError() == nil || session.Has("partial") {
+			t.Error("Session was not emptied after a store error")
+		}
+		session.Set("hello", "world")
+		if session.Save(c) != ErrSessionUnavailable {
+			t.Error("Session of a failed load was saved")
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/get", nil)
+	f.ServeHTTP(res, req)
+
+	if res.Code != 200 || res.Header().Get("Set-Cookie") != "" {
+		t.Error("Unexpected response after a store error:", res.Code, res.Header())
+	}
+
+	f = floki.Default()
+	f.Use(SessionsWithConfig("my_session1", store, Config{
+		OnError: func(c *floki.Context, err error) ErrAction { return ErrAbort },
+	}))
+	f.GET("/get", func(c *floki.Context) {
+		t.Error("Request was not aborted")
+	})
+
+	res2 := httptest.NewRecorder()
+	f.ServeHTTP(res2, req)
+	if res2.Code != http.StatusServiceUnavailable {
+		t.Error("Unexpected status:", res2.Code)
+	}
+}
+
+type failingRevocationList struct{}
+
+func (failingRevocationList) Revoke(id string, ttl time.Duration) error {
+	return errors.New("backend is down")
+}
+
+func (failingRevocationList) IsRevoked(id string) (bool, error) {
+	return false, errors.New("backend is down")
+}
+
+func Test_OnErrorChecks(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(Sessions("my_session1", store, nil))
+	f.GET("/set", func(c *floki.Context) {
+		Get(c).Set("hello", "world")
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/set", nil)
+	f.ServeHTTP(res, req)
+
+	var failed error
+	f = floki.Default()
+	f.Use(SessionsWithConfig("my_session1", store, Config{
+		Revocations: failingRevocationList{},
+		OnError: func(c *floki.Context, err error) ErrAction {
+			failed = err
+			return ErrContinue
+		},
+	}))
+
+	f.GET("/get", func(c *floki.Context) {
+		session := Get(c)
+		if session.LoadError() == nil {
+			t.Error("Session was not emptied after a revocation check error")
+		}
+		c.Send(200, "OK")
+	})
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/get", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+	if failed == nil {
+		t.Error("OnError was not called")
+	}
+	if res2.Code != 200 || res2.Header().Get("Set-Cookie") != "" {
+		t.Error("Unexpected response after a revocation check error:", res2.Code, res2.Header())
+	}
+}
+
+// unsavableStore is a MemoryStore failing to save sessions.
+type unsavableStore struct {
+	*MemoryStore
+}
+
+func (s unsavableStore) Save(c *floki.Context, session *Session) error {
+	return errors.New("backend is down")
+}
+
+func (s unsavableStore) save(session *Session) error {
+	return errors.New("backend is down")
+}
+
+func Test_OnSaveError(t *testing.T) {
+	f := floki.Default()
+
+	var saveErr error
+	store := unsavableStore{NewMemoryStore([]byte("secret123"))}
+	f.Use(SessionsWithConfig("my_session1", store, Config{
+		OnSaveError: func(c *floki.Context, s *Session, err error) { saveErr = err },
+	}))
+
+	f.GET("/get", func(c *floki.Context) {
+		Get(c).Set("hello", "world")
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/get", nil)
+	f.ServeHTTP(res, req)
+
+	if saveErr == nil || saveErr.Error() != "backend is down" {
+		t.Error("Save error was not reported:", saveErr)
+	}
+
+	// Records saved without reissuing the cookie report their errors too.
+	memory := NewMemoryStore([]byte("secret123"))
+	config := Config{
+		IdleTimeout:        time.Hour,
+		CookieRefreshAfter: 0.5,
+		OnSaveError:        func(c *floki.Context, s *Session, err error) { saveErr = err },
+	}
+	f2 := floki.Default()
+	f2.Use(SessionsWithConfig("my_session1", memory, config))
+	f2.GET("/get", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", "world")
+		session.Set(lastSeenKey, time.Now().Add(-time.Minute).Unix())
+		c.Send(200, "OK")
+	})
+	f3 := floki.Default()
+	f3.Use(SessionsWithConfig("my_session1", unsavableStore{memory}, config))
+	f3.GET("/read", func(c *floki.Context) {
+		c.Send(200, "OK")
+	})
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/get", nil)
+	f2.ServeHTTP(res2, req2)
+
+	saveErr = nil
+	res3 := httptest.NewRecorder()
+	req3, _ := http.NewRequest("GET", "/read", nil)
+	req3.Header.Set("Cookie", res2.Header().Get("Set-Cookie"))
+	f3.ServeHTTP(res3, req3)
+	if saveErr == nil {
+		t.Error("Error saving the record only was not reported")
+	}
+	if cookie := res3.Header().Get("Set-Cookie"); cookie != "" {
+		t.Errorf("Cookie of an unmodified session was reissued: %q", cookie)
+	}
+}
+
+// countingStore is a MemoryStore counting the sessions it loads.
+type countingStore struct {
+	*MemoryStore
+	loads *int
+}
+
+func (s countingStore) New(c *floki.Context, name string) (*Session, error) {
+	*s.loads++
+	return s.MemoryStore.New(c, name)
+}
+
+func Test_LazyLoad(t *testing.T) {
+	f := floki.Default()
+
+	var loads int
+	store := countingStore{NewMemoryStore([]byte("secret123")), &loads}
+	f.Use(SessionsWithConfig("my_session1", store, Config{LazyLoad: true}))
+
+	f.GET("/static", func(c *floki.Context) {
+		c.Send(200, "OK")
+	})
+
+	f.GET("/set", func(c *floki.Context) {
+		Get(c).Set("hello", "world")
+		c.Send(200, "OK")
+	})
+
+	f.GET("/get", func(c *floki.Context) {
+		if Get(c).Get("hello") != "world" {
+			t.Error("Lazy session was not loaded")
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/static", nil)
+	f.ServeHTTP(res, req)
+	if loads != 0 || res.Header().Get("Set-Cookie") != "" {
+		t.Error("Unused session was loaded")
+	}
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/set", nil)
+	f.ServeHTTP(res2, req2)
+
+	res3 := httptest.NewRecorder()
+	req3, _ := http.NewRequest("GET", "/get", nil)
+	req3.Header.Set("Cookie", res2.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res3, req3)
+	if loads != 2 {
+		t.Error("Unexpected number of loads:", loads)
+	}
+}
+
+func Test_SkipOnly(t *testing.T) {
+	for _, tt := range []struct {
+		filter pathFilter
+		path   string
+		bypass bool
+	}{
+		{pathFilter{skip: []string{"/static/"}}, "/static/app.js", true},
+		{pathFilter{skip: []string{"/static/"}}, "/login", false},
+		{pathFilter{skip: []string{"*.css"}}, "/assets/site.css", true},
+		{pathFilter{skip: []string{"/healthz"}}, "/healthz", true},
+		{pathFilter{skip: []string{"/healthz"}}, "/healthz/db", false},
+		{pathFilter{only: []string{"/app/"}}, "/metrics", true},
+		{pathFilter{only: []string{"/app/"}, skip: []string{"/app/static/"}}, "/app/static/a.js", true},
+		{pathFilter{only: []string{"/app/"}}, "/app/home", false},
+	} {
+		if tt.filter.bypass(tt.path) != tt.bypass {
+			t.Errorf("%+v: bypass(%q) != %v", tt.filter, tt.path, tt.bypass)
+		}
+	}
+
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{Skip: []string{"/static/"}}))
+
+	f.GET("/static/app.js", func(c *floki.Context) {
+		if _, ok := TryGet(c); ok {
+			t.Error("Skipped request has a session")
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/static/app.js", nil)
+	f.ServeHTTP(res, req)
+	if res.Code != 200 {
+		t.Error("Skipped request was not served:", res.Code)
+	}
+}
+
+func Test_Routes(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{
+		Routes: []Route{{
+			Pattern: "/admin/",
+			Name:    "admin_session",
+			Options: &Options{Path: "/admin", MaxAge: 600, SameSite: http.SameSiteStrictMode},
+		}},
+	}))
+
+	handler := func(c *floki.Context) {
+		Get(c).Set("hello", "world")
+		c.Send(200, "OK")
+	}
+	f.GET("/home", handler)
+	f.GET("/admin/home", handler)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/home", nil)
+	f.ServeHTTP(res, req)
+	if cookie := res.Header().Get("Set-Cookie"); !strings.HasPrefix(cookie, "my_session1=") ||
+		!strings.Contains(cookie, "Path=/;") {
+		t.Error("Unexpected cookie outside the route:", cookie)
+	}
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/admin/home", nil)
+	f.ServeHTTP(res2, req2)
+	if cookie := res2.Header().Get("Set-Cookie"); !strings.HasPrefix(cookie, "admin_session=") ||
+		!strings.Contains(cookie, "Max-Age=600") || !strings.Contains(cookie, "SameSite=Strict") {
+		t.Error("Unexpected cookie of the route:", cookie)
+	}
+}
+
+func Test_SaveBeforeWrite(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(Sessions("my_session1", store, nil))
+
+	f.GET("/set", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", "world")
+		c.Send(200, "OK")
+		session.Set("after", "written")
+	})
+
+	f.GET("/get", func(c *floki.Context) {
+		if Get(c).Get("after") != "written" {
+			t.Error("Changes made after the response were not saved")
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/set", nil)
+	f.ServeHTTP(res, req)
+
+	// Result holds the headers as they were when the response was written.
+	cookie := res.Result().Header.Get("Set-Cookie")
+	if cookie == "" {
+		t.Fatal("Cookie was set after the response was written")
+	}
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/get", nil)
+	req2.Header.Set("Cookie", cookie)
+	f.ServeHTTP(res2, req2)
+}
+
+func Test_New(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(New(store,
+		WithName("my_session1"),
+		WithCookieOptions(Options{Path: "/", MaxAge: 60}),
+		WithSkip("/static/"),
+		WithConfig(func(config *Config) { config.StringKeys = true }),
+	))
+
+	f.GET("/get", func(c *floki.Context) {
+		Get(c).Set("hello", "world")
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/get", nil)
+	f.ServeHTTP(res, req)
+
+	if cookie := res.Header().Get("Set-Cookie"); !strings.HasPrefix(cookie, "my_session1=") ||
+		!strings.Contains(cookie, "Max-Age=60") {
+		t.Error("Options were not applied:", cookie)
+	}
+}
+
+func Test_SessionsNamed(t *testing.T) {
+	f := floki.Default()
+
+	f.Use(SessionsNamed(
+		NamedSession{Name: "auth", Store: NewMemoryStore([]byte("secret123"))},
+		NamedSession{Name: "prefs", Store: NewCookieStore([]byte("secret123")),
+			Config: Config{Options: &Options{Path: "/", MaxAge: 86400 * 365}}},
+	))
+
+	f.GET("/set", func(c *floki.Context) {
+		if Get(c) != GetNamed(c, "auth") {
+			t.Error("Get does not return the first session")
+		}
+		GetNamed(c, "auth").Set("user", "alice")
+		GetNamed(c, "prefs").Set("theme", "dark")
+		c.Send(200, "OK")
+	})
+
+	f.GET("/get", func(c *floki.Context) {
+		if GetNamed(c, "auth").Get("user") != "alice" || GetNamed(c, "prefs").Get("theme") != "dark" {
+			t.Error("Named sessions were not saved")
+		}
+		if GetNamed(c, "auth").Has("theme") {
+			t.Error("Named sessions share their values")
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/set", nil)
+	f.ServeHTTP(res, req)
+
+	cookies := res.Header()["Set-Cookie"]
+	if len(cookies) != 2 {
+		t.Fatal("Unexpected cookies:", cookies)
+	}
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/get", nil)
+	for _, cookie := range cookies {
+		req2.Header.Add("Cookie", strings.SplitN(cookie, ";", 2)[0])
+	}
+	f.ServeHTTP(res2, req2)
+}
+
+func Test_SessionsNamedConfig(t *testing.T) {
+	f := floki.Default()
+
+	tombstones := NewMemoryRevocationList()
+	f.Use(SessionsNamed(
+		NamedSession{Name: "auth", Store: NewMemoryStore([]byte("secret123")),
+			Config: Config{
+				Tombstones: tombstones,
+				RememberMe: &RememberConfig{Store: NewMemoryRememberStore()},
+			}},
+		NamedSession{Name: "prefs", Store: NewCookieStore([]byte("secret123"))},
+	))
+
+	f.GET("/logout", func(c *floki.Context) {
+		auth := GetNamed(c, "auth")
+		auth.Set("user", "alice")
+		if err := auth.Save(c); err != nil {
+			t.Fatal(err)
+		}
+		GetNamed(c, "prefs").Set("theme", "dark")
+		values, _ := c.Get("session")
+		if values.(map[interface{}]interface{})["user"] != "alice" {
+			t.Error("The session context value is not the one of the first session")
+		}
+		id := auth.ID
+		if err := auth.Logout(c); err != nil {
+			t.Error("Unexpected error logging out:", err)
+		}
+		if revoked, _ := tombstones.IsRevoked(id); !revoked {
+			t.Error("Destroyed session was not tombstoned")
+		}
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/logout", nil)
+	f.ServeHTTP(res, req)
+}
+
+func Test_ReadOnly(t *testing.T) {
+	f := floki.Default()
+
+	store := NewCookieStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session", store, Config{ReadOnly: []string{"/api/"}}))
+
+	f.GET("/set", func(c *floki.Context) {
+		Get(c).Set("hello", "world")
+		c.Send(200, "OK")
+	})
+
+	checkReadOnly := func(c *floki.Context) {
+		s := Get(c)
+		if !s.IsReadOnly() {
+			t.Error("Session is not read-only")
+		}
+		s.Set("hello", "there")
+		s.Delete("hello")
+		if s.Get("hello") != "world" {
+			t.Error("Read-only session was modified")
+		}
+		if n := s.Increment("count", 1); n != 0 || s.Has("count") {
+			t.Error("Read-only session was incremented:", n)
+		}
+		s.SetMaxAge(60)
+		if err := s.SetOptions(Options{Path: "/", MaxAge: 60}); err != ErrReadOnly || s.Options.MaxAge == 60 {
+			t.Error("Options of a read-only session were changed:", err)
+		}
+		if err := s.Destroy(c); err != ErrReadOnly || s.Get("hello") != "world" {
+			t.Error("Read-only session was destroyed:", err)
+		}
+		if err := s.Save(c); err != ErrReadOnly {
+			t.Error("Unexpected error saving a read-only session:", err)
+		}
+		if err := Save(c); err != nil {
+			t.Error("Unexpected error saving the sessions of the request:", err)
+		}
+		c.Send(200, "OK")
+	}
+	f.GET("/api/get", checkReadOnly)
+	f.GET("/page", ReadOnly(), checkReadOnly)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/set", nil)
+	f.ServeHTTP(res, req)
+	cookie := res.Header().Get("Set-Cookie")
+
+	for _, path := range []string{"/api/get", "/page"} {
+		res2 := httptest.NewRecorder()
+		req2, _ := http.NewRequest("GET", path, nil)
+		req2.Header.Set("Cookie", cookie)
+		f.ServeHTTP(res2, req2)
+		if res2.Header().Get("Set-Cookie") != "" {
+			t.Error("Read-only session was saved for", path)
+		}
+	}
+}
+
+func Test_ReadOnlyDestroy(t *testing.T) {
+	f := floki.Default()
+
+	store := NewMemoryStore([]byte("secret123"))
+	f.Use(SessionsWithConfig("my_session1", store, Config{
+		ReadOnly:   []string{"/api/"},
+		Tombstones: NewMemoryRevocationList(),
+	}))
+
+	var id string
+	f.GET("/set", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", "world")
+		if err := session.Save(c); err != nil {
+			t.Fatal(err)
+		}
+		id = session.ID
+		c.Send(200, "OK")
+	})
+
+	f.GET("/api/logout", func(c *floki.Context) {
+		if err := Get(c).Destroy(c); err != ErrReadOnly {
+			t.Error("Unexpected error destroying a read-only session:", err)
+		}
+		c.Send(200, "OK")
+	})
+
+	var hello interface{}
+	f.GET("/show", func(c *floki.Context) {
+		hello = Get(c).Get("hello")
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/set", nil)
+	f.ServeHTTP(res, req)
+	cookie := res.Header().Get("Set-Cookie")
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/api/logout", nil)
+	req2.Header.Set("Cookie", cookie)
+	f.ServeHTTP(res2, req2)
+	if res2.Header().Get("Set-Cookie") != "" {
+		t.Error("Cookie of a read-only session was expired")
+	}
+	if _, ok := store.shard(id).records[id]; !ok {
+		t.Error("Record of a read-only session was deleted")
+	}
+
+	res3 := httptest.NewRecorder()
+	req3, _ := http.NewRequest("GET", "/show", nil)
+	req3.Header.Set("Cookie", cookie)
+	f.ServeHTTP(res3, req3)
+	if hello != "world" || res3.Code != http.StatusOK {
+		t.Error("Read-only session was destroyed:", res3.Code, hello)
+	}
+}
+
+func Test_JSONCodec(t *testing.T) {
+	codec := JSONCodec{}
+	data, err := codec.Marshal(map[interface{}]interface{}{"hello": "world", "n": 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make(map[interface{}]interface{})
+	if err := codec.Unmarshal(data, values); err != nil {
+		t.Fatal(err)
+	}
+	if values["hello"] != "world" {
+		t.Error("JSONCodec round trip failed:", values)
+	}
+
+	if _, err := codec.Marshal(map[interface{}]interface{}{1: "one"}); err == nil {
+		t.Error("JSONCodec accepted a non-string key")
+	}
+}
+
+type protoUser struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3"`
+}
+
+func (m *protoUser) Reset()         { *m = protoUser{} }
+func (m *protoUser) String() string { return m.Name }
+func (*protoUser) ProtoMessage()    {}
+
+func Test_ProtoCodec(t *testing.T) {
+	codec := NewProtoCodec(func() proto.Message { return new(protoUser) })
+	bare, err := codec.Marshal(map[interface{}]interface{}{ProtoKey: &protoUser{Name: "alice"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := proto.Marshal(&protoUser{Name: "alice"}); !bytes.Equal(bare, want) {
+		t.Error("Message alone was not written as bare protobuf")
+	}
+
+	f := floki.Default()
+	store := NewMemoryStore([]byte("secret123"))
+	store.ValueCodec = codec
+	f.Use(Sessions("my_session1", store, nil))
+
+	f.GET("/login", func(c *floki.Context) {
+		session := Get(c)
+		session.Set(ProtoKey, &protoUser{Name: "alice"})
+		session.AddFlash("welcome")
+		c.Send(200, "OK")
+	})
+
+	var user *protoUser
+	var flashes []interface{}
+	f.GET("/show", func(c *floki.Context) {
+		session := Get(c)
+		user, _ = session.Get(ProtoKey).(*protoUser)
+		flashes = session.Flashes()
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/login", nil)
+	f.ServeHTTP(res, req)
+	if res.Header().Get("Set-Cookie") == "" {
+		t.Fatal("Session with a flash was not saved")
+	}
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res2, req2)
+	if user == nil || user.Name != "alice" || len(flashes) != 1 || flashes[0] != "welcome" {
+		t.Error("ProtoCodec lost session values:", user, flashes)
+	}
+}
+
+func Test_VersionedCodec(t *testing.T) {
+	// Version 0 payloads are plain JSON written before the codec was
+	// versioned, keeping the user under "user"; version 1 renamed it "uid".
+	old, _ := JSONCodec{}.Marshal(map[interface{}]interface{}{"user": "alice"})
+	rename := func(data []byte) ([]byte, error) {
+		return bytes.Replace(data, []byte(`"user"`), []byte(`"uid"`), 1), nil
+	}
+
+	v1 := NewVersionedCodec(JSONCodec{}, 1)
+	v1.Upgrade(0, rename)
+	values := make(map[interface{}]interface{})
+	if err := v1.Unmarshal(old, values); err != nil {
+		t.Fatal(err)
+	}
+	if values["uid"] != "alice" || values["user"] != nil {
+		t.Error("Unversioned payload was not upgraded:", values)
+	}
+
+	current, err := v1.Marshal(map[interface{}]interface{}{"uid": "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Version 2 upgrades both older formats step by step.
+	v2 := NewVersionedCodec(JSONCodec{}, 2)
+	v2.Upgrade(0, rename)
+	v2.Upgrade(1, func(data []byte) ([]byte, error) {
+		return bytes.Replace(data, []byte(`"uid"`), []byte(`"user_id"`), 1), nil
+	})
+	for payload, want := range map[string]string{string(old): "alice", string(current): "bob"} {
+		values := make(map[interface{}]interface{})
+		if err := v2.Unmarshal([]byte(payload), values); err != nil {
+			t.Fatal(err)
+		}
+		if values["user_id"] != want {
+			t.Error("Payload was not upgraded to version 2:", values)
+		}
+	}
+
+	if err := NewVersionedCodec(JSONCodec{}, 2).Unmarshal(current, values); err != ErrUnknownVersion {
+		t.Error("Payload without an upgrade path was decoded:", err)
+	}
+	if err := NewVersionedCodec(JSONCodec{}, 0).Unmarshal(current, values); err != ErrUnknownVersion {
+		t.Error("Payload of a newer version was decoded:", err)
+	}
+
+	failed := errors.New("upgrade failed")
+	v3 := NewVersionedCodec(JSONCodec{}, 1)
+	v3.Upgrade(0, func([]byte) ([]byte, error) { return nil, failed })
+	if err := v3.Unmarshal(old, values); err != failed {
+		t.Error("Upgrade error was not returned:", err)
+	}
+}
+
+func Test_CookieStoreChunks(t *testing.T) {
+	f := floki.Default()
+
+	store := NewCookieStore([]byte("secret123"))
+	f.Use(Sessions("my_session1", store, nil))
+
+	large := hex.EncodeToString(securecookie.GenerateRandomKey(5000))
+	f.GET("/testsession", func(c *floki.Context) {
+		session := Get(c)
+		session.Set("hello", large)
+		if err := session.Save(c); err != nil {
+			t.Error("Large session was not saved:", err)
+		}
+		session.Set("hello", large+strings.Repeat(large, 5))
+		if err := session.Save(c); err != ErrSessionTooLarge {
+			t.Error("Session over the chunk limit was saved:", err)
+		}
+		session.Set("hello", large)
+		c.Send(200, "OK")
+	})
+
+	var hello interface{}
+	f.GET("/show", func(c *floki.Context) {
+		hello = Get(c).Get("hello")
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	f.ServeHTTP(res, req)
+
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	chunks := 0
+	for _, cookie := range res.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			continue
+		}
+		if len(cookie.Value) > 4096 {
+			t.Errorf("Cookie %s is %d bytes long", cookie.Name, len(cookie.Value))
+		}
+		if strings.HasPrefix(cookie.Name, "my_session1-") {
+			chunks++
+		}
+		req2.AddCookie(cookie)
+	}
+	if chunks < 2 {
+		t.Fatal("Large session was not split:", chunks)
+	}
+	f.ServeHTTP(httptest.NewRecorder(), req2)
+	if hello != large {
+		t.Error("Split session was not read back")
+	}
+}
+
+func Test_NewCookieSameSite(t *testing.T) {
+	cookie := NewCookie("my_session", "value", &Options{Path: "/"})
+	if cookie.SameSite != http.SameSiteLaxMode {
+		t.Error("SameSite does not default to Lax:", cookie.SameSite)
+	}
+
+	options := &Options{SameSite: http.SameSiteNoneMode}
+	if options.validate("my_session") != ErrSameSiteNoneInsecure {
+		t.Error("SameSite=None was accepted without Secure")
+	}
+
+	if (&Options{Path: "/", Secure: true}).validate("__Host-id") != nil {
+		t.Error("Valid __Host- cookie options were rejected")
+	}
+	if (&Options{Path: "/app", Secure: true}).validate("__Host-id") == nil {
+		t.Error("__Host- cookie options with a Path other than / were accepted")
+	}
+}
+
+func Test_CookiePrefixes(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		options *Options
+		want    []string
+	}{
+		{"__Host-my_session", &Options{Path: "/app", Domain: "example.com", MaxAge: 60}, []string{"Secure", "Path=/;"}},
+		{"__Host-my_session", nil, []string{"Secure", "Path=/;"}},
+		{"__Secure-my_session", &Options{Path: "/app", MaxAge: 60}, []string{"Secure", "Path=/app"}},
+		{"__Secure-my_session", nil, []string{"Secure"}},
+	} {
+		f := floki.Default()
+
+		store := NewCookieStore([]byte("secret123"))
+		store.Options.Domain = "example.com"
+		f.Use(SessionsWithConfig(tt.name, store, Config{Options: tt.options}))
+
+		f.GET("/testsession", func(c *floki.Context) {
+			Get(c).Set("hello", "world")
+			c.Send(200, "OK")
+		})
+
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/testsession", nil)
+		f.ServeHTTP(res, req)
+		cookie := res.Header().Get("Set-Cookie") + ";"
+		for _, attr := range tt.want {
+			if !strings.Contains(cookie, attr) {
+				t.Errorf("Cookie %s lacks %s: %s", tt.name, attr, cookie)
+			}
+		}
+		if strings.HasPrefix(tt.name, hostPrefix) && strings.Contains(cookie, "Domain=") {
+			t.Errorf("Cookie %s has a Domain: %s", tt.name, cookie)
+		}
+		if store.Options.Secure || (tt.options != nil && tt.options.Secure) {
+			t.Error("Prefix rules modified the given Options")
+		}
+	}
+}
+
 /*
 func Test_SessionsDeleteValue(t *testing.T) {
 	m := martini.Classic()
@@ -0,0 +1,98 @@
+package sessions
+
+import (
+	"errors"
+	"github.com/go-floki/floki"
+	"net/http"
+	"time"
+)
+
+// Keys under which the time and level of the last authentication are kept.
+const (
+	authTimeKey  = "_auth_time"
+	authLevelKey = "_auth_level"
+)
+
+var (
+	// ErrStaleAuth is returned by Session.RequireFreshAuth when the user
+	// authenticated too long ago, or never did.
+	ErrStaleAuth = errors.New("sessions: re-authentication required")
+
+	// ErrAuthLevel is returned by Session.RequireLevel when the user
+	// authenticated with a lower level than required.
+	ErrAuthLevel = errors.New("sessions: higher authentication level required")
+)
+
+// RecordAuth records that the user just authenticated with the given
+// level, such as 1 for a password and 2 for a second factor. Like
+// SetAuthenticated, it regenerates the session ID at the end of the request.
+func (s *Session) RecordAuth(level int) {
+	s.Set(authTimeKey, time.Now().Unix())
+	s.Set(authLevelKey, int64(level))
+	s.rotate = true
+}
+
+// AuthTime returns when the user last authenticated, or the zero time.
+func (s *Session) AuthTime() time.Time {
+	t, ok := unixTime(s.Get(authTimeKey))
+	if !ok {
+		return time.Time{}
+	}
+	return t
+}
+
+// AuthLevel returns the level the user last authenticated with, or 0.
+func (s *Session) AuthLevel() int {
+	level, _ := int64Value(s.Get(authLevelKey))
+	return int(level)
+}
+
+// RequireFreshAuth returns ErrStaleAuth unless the user authenticated
+// within maxAge.
+func (s *Session) RequireFreshAuth(maxAge time.Duration) error {
+	t := s.AuthTime()
+	if t.IsZero() || time.Since(t) > maxAge {
+		return ErrStaleAuth
+	}
+	return nil
+}
+
+// RequireLevel returns ErrAuthLevel unless the user authenticated with at
+// least the given level.
+func (s *Session) RequireLevel(level int) error {
+	if s.AuthTime().IsZero() || s.AuthLevel() < level {
+		return ErrAuthLevel
+	}
+	return nil
+}
+
+// StepUp returns a middleware guarding sensitive routes: requests whose
+// session did not authenticate within maxAge, or with less than level, are
+// passed to challenge instead of the next handlers, like requests of routes
+// without the sessions middleware. Set maxAge to 0 to only check the level.
+//
+// Challenge typically redirects to a login page and aborts the request; it
+// defaults to aborting with 401 Unauthorized.
+func StepUp(maxAge time.Duration, level int, challenge floki.HandlerFunc) floki.HandlerFunc {
+	if challenge == nil {
+		challenge = func(c *floki.Context) {
+			c.Abort(http.StatusUnauthorized)
+		}
+	}
+	return func(c *floki.Context) {
+		s, ok := TryGet(c)
+		if !ok {
+			challenge(c)
+			return
+		}
+		err := s.RequireLevel(level)
+		if err == nil && maxAge > 0 {
+			err = s.RequireFreshAuth(maxAge)
+		}
+		if err != nil {
+			challenge(c)
+			return
+		}
+		c.Next()
+	}
+}
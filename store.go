@@ -5,14 +5,18 @@
 package sessions
 
 import (
-	"encoding/base32"
+	"context"
+	"fmt"
 	"github.com/go-floki/floki"
 	"github.com/gorilla/securecookie"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // Store is an interface for custom session stores.
@@ -32,6 +36,140 @@ type Store interface {
 	Save(r *floki.Context, s *Session) error
 }
 
+// Deleter is implemented by stores keeping a server-side record for each
+// session, so the record of a session ID can be removed without touching
+// the response.
+type Deleter interface {
+	// DeleteID removes the record stored for the session ID. Removing an
+	// unknown ID is not an error.
+	DeleteID(id string) error
+}
+
+// Toucher is implemented by stores able to extend the lifetime of a saved
+// session, refreshing the TTL of its record and its cookie, without
+// encoding and writing its values again. See Session.Touch.
+type Toucher interface {
+	Touch(c *floki.Context, s *Session) error
+}
+
+// writeIDCookie sets the cookie holding the ID of session, signed with
+// codecs.
+func writeIDCookie(c *floki.Context, session *Session, codecs ...securecookie.Codec) error {
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(c.Writer, NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// PayloadTransform rewrites the encoded payload of a session on its way to
+// and from the backend of a server-side store.
+type PayloadTransform interface {
+	// Encode is applied to a payload before it is written.
+	Encode(b []byte) ([]byte, error)
+
+	// Decode reverses Encode on a payload that has been read back.
+	Decode(b []byte) ([]byte, error)
+}
+
+// PayloadStore is implemented by stores that persist an encoded payload per
+// session, such as FilesystemStore, RediStore and MemoryStore.
+type PayloadStore interface {
+	Store
+
+	// AddTransform appends t to the transforms applied to stored payloads.
+	// Transforms are applied in the order they were added when writing and
+	// in reverse order when reading. The transforms of EnableCompression,
+	// EnableEncryption and IntegrityStore replace the previous one of the
+	// same kind instead, so they are never applied twice. It is safe to
+	// call while the store is in use.
+	AddTransform(t PayloadTransform)
+}
+
+// Payload headers written by transforms start with a zero byte, which
+// neither gob streams nor securecookie values ever begin with, so payloads
+// written before a transform was added can still be told apart.
+const payloadMagic byte = 0x00
+
+// sessionTransform is implemented by transforms that need the session a
+// payload belongs to, such as the one of IntegrityStore.
+type sessionTransform interface {
+	EncodeSession(s *Session, b []byte) ([]byte, error)
+	DecodeSession(s *Session, b []byte) ([]byte, error)
+}
+
+// uniqueTransform is implemented by the transforms of this package, at most
+// one of each kind being installed in a store.
+type uniqueTransform interface {
+	// kind identifies the transforms replacing each other.
+	kind() byte
+}
+
+// transformChain is the ordered list of transforms used by a PayloadStore.
+type transformChain struct {
+	mu         sync.RWMutex
+	transforms []PayloadTransform
+}
+
+// add appends tr to the chain, or replaces the transform of the same kind.
+// The slice is copied, so encode and decode keep using the one they got.
+func (t *transformChain) add(tr PayloadTransform) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	transforms := append([]PayloadTransform(nil), t.transforms...)
+	if u, ok := tr.(uniqueTransform); ok {
+		for i, old := range transforms {
+			if o, ok := old.(uniqueTransform); ok && o.kind() == u.kind() {
+				transforms[i] = tr
+				t.transforms = transforms
+				return
+			}
+		}
+	}
+	t.transforms = append(transforms, tr)
+}
+
+// list returns the transforms of the chain.
+func (t *transformChain) list() []PayloadTransform {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.transforms
+}
+
+// encode applies every transform in order to the payload of s.
+func (t *transformChain) encode(s *Session, b []byte) ([]byte, error) {
+	var err error
+	for _, tr := range t.list() {
+		if st, ok := tr.(sessionTransform); ok {
+			b, err = st.EncodeSession(s, b)
+		} else {
+			b, err = tr.Encode(b)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// decode applies every transform in reverse order to the payload of s.
+func (t *transformChain) decode(s *Session, b []byte) ([]byte, error) {
+	var err error
+	transforms := t.list()
+	for i := len(transforms) - 1; i >= 0; i-- {
+		if st, ok := transforms[i].(sessionTransform); ok {
+			b, err = st.DecodeSession(s, b)
+		} else {
+			b, err = transforms[i].Decode(b)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
 // CookieStore ----------------------------------------------------------------
 
 // NewCookieStore returns a new CookieStore.
@@ -51,18 +189,64 @@ type Store interface {
 // strong keys.
 func NewCookieStore(keyPairs ...[]byte) *CookieStore {
 	return &CookieStore{
-		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Codecs: unlimitedCodecs(securecookie.CodecsFromPairs(keyPairs...)),
 		Options: &Options{
-			Path:   "/",
-			MaxAge: 86400 * 30,
+			Path:     "/",
+			MaxAge:   86400 * 30,
+			SameSite: http.SameSiteLaxMode,
 		},
+		ChunkSize: defaultChunkSize,
+		maxLength: 4096,
 	}
 }
 
+const (
+	// defaultChunkSize is the ChunkSize of new CookieStores, leaving room
+	// for the cookie name within the 4096 bytes browsers accept.
+	defaultChunkSize = 4000
+
+	// maxCookieChunks is the number of cookies a session may be split
+	// over, keeping the Cookie header of requests within server limits.
+	maxCookieChunks = 8
+)
+
 // CookieStore stores sessions using secure cookies.
 type CookieStore struct {
-	Codecs  []securecookie.Codec
-	Options *Options // default configuration
+	Codecs     []securecookie.Codec
+	Options    *Options // default configuration
+	ValueCodec Codec    // serializes Values; securecookie's gob when nil
+
+	// ChunkSize, if not 0, splits session cookies longer than ChunkSize
+	// bytes over several cookies named name-0 … name-N, at most 8, which
+	// are joined again when the session is read. It is 4000 for a new
+	// CookieStore.
+	ChunkSize int
+
+	maxLength int
+}
+
+// MaxLength restricts the maximum length of each session cookie to l; Save
+// returns ErrSessionTooLarge for sessions that need longer ones, which
+// browsers would drop, or more cookies than ChunkSize allows.
+// If l is 0 there is no limit to the size of a session, use with caution.
+// The default for a new CookieStore is 4096.
+func (s *CookieStore) MaxLength(l int) {
+	s.maxLength = l
+}
+
+// tooLarge reports whether a session cookie of n bytes, once split into
+// chunks, exceeds the limits of MaxLength.
+func (s *CookieStore) tooLarge(n int) bool {
+	if s.maxLength == 0 {
+		return false
+	}
+	if s.ChunkSize > 0 && n > s.ChunkSize {
+		if (n+s.ChunkSize-1)/s.ChunkSize > maxCookieChunks {
+			return true
+		}
+		n = s.ChunkSize
+	}
+	return n > s.maxLength
 }
 
 // Get returns a session for the given name after adding it to the registry.
@@ -90,9 +274,9 @@ func (s *CookieStore) New(c *floki.Context, name string) (*Session, error) {
 	var err error
 	//c.Logger().Println("cookies:", r.Cookies())
 
-	if cookie, errCookie := r.Cookie(name); errCookie == nil {
-		err = securecookie.DecodeMulti(name, cookie.Value, &session.Values,
-			s.Codecs...)
+	if value, ok := readChunkedCookie(r, name); ok {
+		err = decodeSecureValues(name, value, session.Values,
+			s.ValueCodec, s.Codecs...)
 		if err == nil {
 			session.IsNew = false
 		}
@@ -102,16 +286,84 @@ func (s *CookieStore) New(c *floki.Context, name string) (*Session, error) {
 
 // Save adds a single session to the response.
 func (s *CookieStore) Save(c *floki.Context, session *Session) error {
-	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values,
-		s.Codecs...)
+	encoded, err := encodeSecureValues(session.Name(), session.Values,
+		s.ValueCodec, s.Codecs...)
 	if err != nil {
 		return err
 	}
+	if s.tooLarge(len(encoded)) {
+		return ErrSessionTooLarge
+	}
 	//c.Logger().Println("set cookie", session.Name(), encoded)
-	http.SetCookie(c.Writer, NewCookie(session.Name(), encoded, session.Options))
+	writeChunkedCookie(c, session.Name(), encoded, session.Options, s.ChunkSize)
 	return nil
 }
 
+// chunkName returns the name of the i-th chunk of the cookie called name.
+func chunkName(name string, i int) string {
+	return fmt.Sprintf("%s-%d", name, i)
+}
+
+// readChunkedCookie returns the value of the cookie called name, joining
+// its chunks if it was split by writeChunkedCookie.
+func readChunkedCookie(r *http.Request, name string) (string, bool) {
+	if cookie, err := r.Cookie(name); err == nil {
+		return cookie.Value, true
+	}
+	var value string
+	for i := 0; ; i++ {
+		cookie, err := r.Cookie(chunkName(name, i))
+		if err != nil {
+			return value, i > 0
+		}
+		value += cookie.Value
+	}
+}
+
+// writeChunkedCookie sets the cookie called name to value, split into
+// chunks of at most size bytes if size is not 0 and value is longer. Chunks
+// and unsplit cookies left over from previous responses are expired.
+func writeChunkedCookie(c *floki.Context, name, value string, options *Options, size int) {
+	expired := *options
+	expired.MaxAge = -1
+	n := 0
+	if size > 0 && len(value) > size {
+		for ; len(value) > 0; n++ {
+			chunk := value
+			if len(chunk) > size {
+				chunk = chunk[:size]
+			}
+			value = value[len(chunk):]
+			http.SetCookie(c.Writer, NewCookie(chunkName(name, n), chunk, options))
+		}
+		if _, err := c.Request.Cookie(name); err == nil {
+			http.SetCookie(c.Writer, NewCookie(name, "", &expired))
+		}
+	} else {
+		http.SetCookie(c.Writer, NewCookie(name, value, options))
+	}
+	for i := n; ; i++ {
+		if _, err := c.Request.Cookie(chunkName(name, i)); err != nil {
+			break
+		}
+		http.SetCookie(c.Writer, NewCookie(chunkName(name, i), "", &expired))
+	}
+}
+
+// unlimitedCodecs lifts the length limit of the securecookie codecs, so that
+// stores can enforce their own limit and report it with ErrSessionTooLarge.
+func unlimitedCodecs(codecs []securecookie.Codec) []securecookie.Codec {
+	for _, c := range codecs {
+		switch codec := c.(type) {
+		case *securecookie.SecureCookie:
+			codec.MaxLength(0)
+		case *SecureCodec:
+			codec.MaxLength(0)
+		}
+	}
+	return codecs
+}
+
 // FilesystemStore ------------------------------------------------------------
 
 var fileMutex sync.RWMutex
@@ -130,12 +382,14 @@ func NewFilesystemStore(path string, keyPairs ...[]byte) *FilesystemStore {
 		path += "/"
 	}
 	return &FilesystemStore{
-		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Codecs: unlimitedCodecs(securecookie.CodecsFromPairs(keyPairs...)),
 		Options: &Options{
-			Path:   "/",
-			MaxAge: 86400 * 30,
+			Path:     "/",
+			MaxAge:   86400 * 30,
+			SameSite: http.SameSiteLaxMode,
 		},
-		path: path,
+		path:      path,
+		maxLength: 4096,
 	}
 }
 
@@ -145,20 +399,28 @@ func NewFilesystemStore(path string, keyPairs ...[]byte) *FilesystemStore {
 //
 // This store is still experimental and not well tested. Feedback is welcome.
 type FilesystemStore struct {
-	Codecs  []securecookie.Codec
-	Options *Options // default configuration
-	path    string
+	Codecs     []securecookie.Codec
+	Options    *Options    // default configuration
+	ValueCodec Codec       // serializes Values; securecookie's gob when nil
+	IDs        IDGenerator // creates session IDs; DefaultIDGenerator when nil
+	path       string
+	maxLength  int
+	transforms transformChain
+
+	expireListeners
+}
+
+// AddTransform appends t to the transforms applied to session files.
+func (s *FilesystemStore) AddTransform(t PayloadTransform) {
+	s.transforms.add(t)
 }
 
-// MaxLength restricts the maximum length of new sessions to l.
+// MaxLength restricts the maximum length of new sessions to l; Save
+// returns ErrSessionTooLarge for longer ones.
 // If l is 0 there is no limit to the size of a session, use with caution.
 // The default for a new FilesystemStore is 4096.
 func (s *FilesystemStore) MaxLength(l int) {
-	for _, c := range s.Codecs {
-		if codec, ok := c.(*securecookie.SecureCookie); ok {
-			codec.MaxLength(l)
-		}
-	}
+	s.maxLength = l
 }
 
 // Get returns a session for the given name after adding it to the registry.
@@ -179,11 +441,14 @@ func (s *FilesystemStore) New(c *floki.Context, name string) (*Session, error) {
 	session.IsNew = true
 	var err error
 	if c, errCookie := r.Cookie(name); errCookie == nil {
-		err = securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...)
+		err = decodeError(securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...))
 		if err == nil {
 			err = s.load(session)
 			if err == nil {
 				session.IsNew = false
+			} else if os.IsNotExist(err) {
+				// The session was deleted or never saved.
+				err = nil
 			}
 		}
 	}
@@ -192,12 +457,20 @@ func (s *FilesystemStore) New(c *floki.Context, name string) (*Session, error) {
 
 // Save adds a single session to the response.
 func (s *FilesystemStore) Save(c *floki.Context, session *Session) error {
+	// Marked for deletion.
+	if session.Options.MaxAge < 0 {
+		if err := s.DeleteID(session.ID); err != nil {
+			return err
+		}
+		http.SetCookie(c.Writer, NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
 	if session.ID == "" {
-		// Because the ID is used in the filename, encode it to
-		// use alphanumeric characters only.
-		session.ID = strings.TrimRight(
-			base32.StdEncoding.EncodeToString(
-				securecookie.GenerateRandomKey(32)), "=")
+		id, err := idGeneratorOrDefault(s.IDs).NewID()
+		if err != nil {
+			return err
+		}
+		session.ID = id
 	}
 	if err := s.save(session); err != nil {
 		return err
@@ -211,10 +484,58 @@ func (s *FilesystemStore) Save(c *floki.Context, session *Session) error {
 	return nil
 }
 
+// DeleteID removes the file of the session ID.
+func (s *FilesystemStore) DeleteID(id string) error {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+	err := os.Remove(s.path + "session_" + id)
+	if err == nil || os.IsNotExist(err) {
+		err = removeFile(s.maxAgeFile(id))
+	}
+	return err
+}
+
+// removeFile removes the file called name, if it exists.
+func removeFile(name string) error {
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// maxAgeFile returns the name of the file recording the MaxAge of the
+// session id, written next to its session file when it differs from the
+// MaxAge of the store, such as after Session.SetMaxAge.
+func (s *FilesystemStore) maxAgeFile(id string) string {
+	return s.path + "maxage_" + id
+}
+
+// maxAge returns the MaxAge the session id was saved with.
+func (s *FilesystemStore) maxAge(id string) int {
+	fileMutex.RLock()
+	b, err := ioutil.ReadFile(s.maxAgeFile(id))
+	fileMutex.RUnlock()
+	if err != nil {
+		return s.Options.MaxAge
+	}
+	age, err := strconv.Atoi(string(b))
+	if err != nil {
+		return s.Options.MaxAge
+	}
+	return age
+}
+
 // save writes encoded session.Values to a file.
 func (s *FilesystemStore) save(session *Session) error {
-	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values,
-		s.Codecs...)
+	encoded, err := encodeSecureValues(session.Name(), session.Values,
+		s.ValueCodec, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	if s.maxLength != 0 && len(encoded) > s.maxLength {
+		return ErrSessionTooLarge
+	}
+	data, err := s.transforms.encode(session, []byte(encoded))
 	if err != nil {
 		return err
 	}
@@ -225,11 +546,70 @@ func (s *FilesystemStore) save(session *Session) error {
 	if err != nil {
 		return err
 	}
-	if _, err = fp.Write([]byte(encoded)); err != nil {
+	if _, err = fp.Write(data); err != nil {
 		return err
 	}
 	fp.Close()
-	return nil
+	if age := session.Options.MaxAge; age != s.Options.MaxAge {
+		return ioutil.WriteFile(s.maxAgeFile(session.ID), []byte(strconv.Itoa(age)), 0600)
+	}
+	return removeFile(s.maxAgeFile(session.ID))
+}
+
+// Touch updates the modification time of the file of session, which
+// DeleteExpired uses as its last activity, and reissues its cookie.
+func (s *FilesystemStore) Touch(c *floki.Context, session *Session) error {
+	now := time.Now()
+	fileMutex.Lock()
+	err := os.Chtimes(s.path+"session_"+session.ID, now, now)
+	fileMutex.Unlock()
+	if os.IsNotExist(err) {
+		err = s.save(session)
+	}
+	if err != nil {
+		return err
+	}
+	return writeIDCookie(c, session, s.Codecs...)
+}
+
+// DeleteExpired removes at most limit session files, or all of them if
+// limit is 0, that were not modified for the MaxAge they were saved with,
+// which is Options.MaxAge unless the session set its own, and returns how
+// many were removed. Sessions whose MaxAge isn't positive are never
+// removed. See Janitor.
+//
+// Values can't be decoded without the session name, so the functions
+// registered with OnExpire are called with nil values.
+func (s *FilesystemStore) DeleteExpired(ctx context.Context, limit int) (int, error) {
+	infos, err := ioutil.ReadDir(s.path)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	n := 0
+	for _, info := range infos {
+		if limit > 0 && n >= limit {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, "session_") {
+			continue
+		}
+		id := strings.TrimPrefix(name, "session_")
+		age := s.maxAge(id)
+		if age <= 0 || !info.ModTime().Add(time.Duration(age)*time.Second).Before(now) {
+			continue
+		}
+		if err := s.DeleteID(id); err != nil {
+			return n, err
+		}
+		s.expired(id, nil)
+		n++
+	}
+	return n, nil
 }
 
 // load reads a file and decodes its content into session.Values.
@@ -253,8 +633,11 @@ func (s *FilesystemStore) load(session *Session) error {
 			return err
 		}
 	}
-	if err = securecookie.DecodeMulti(session.Name(), string(fdata),
-		&session.Values, s.Codecs...); err != nil {
+	if fdata, err = s.transforms.decode(session, fdata); err != nil {
+		return malformed(err)
+	}
+	if err = decodeSecureValues(session.Name(), string(fdata),
+		session.Values, s.ValueCodec, s.Codecs...); err != nil {
 		return err
 	}
 	return nil
@@ -0,0 +1,64 @@
+package sessions
+
+import (
+	"errors"
+	"github.com/go-floki/floki"
+	"net/http"
+)
+
+// ErrAction tells the middleware what to do after a store error, see
+// Config.OnError.
+type ErrAction int
+
+const (
+	// ErrContinue serves the request with an empty session that is never
+	// saved, so the stored session of the client survives the failure.
+	ErrContinue ErrAction = iota
+
+	// ErrAbort aborts the request with 503 Service Unavailable.
+	ErrAbort
+
+	// ErrPanic panics with the error.
+	ErrPanic
+)
+
+// ErrSessionUnavailable is returned by Session.Save for the sessions served
+// after a store error with ErrContinue.
+var ErrSessionUnavailable = errors.New("sessions: the session could not be loaded")
+
+// LogStoreError is the default Config.OnError: it logs err and serves the
+// request with an empty session.
+func LogStoreError(c *floki.Context, err error) ErrAction {
+	c.Logger().Println("error loading session:", err)
+	return ErrContinue
+}
+
+// LogSaveError is the default Config.OnSaveError: it logs err, leaving the
+// stored session as it was before the request.
+func LogSaveError(c *floki.Context, s *Session, err error) {
+	c.Logger().Println("error saving session:", err)
+}
+
+// handleStoreError applies the action of onError for the store error err,
+// returned while loading s. It reports whether the request may go on.
+func handleStoreError(c *floki.Context, s *Session, err error,
+	onError func(*floki.Context, error) ErrAction) bool {
+	action := onError(c, err)
+	if action == ErrPanic {
+		panic(err)
+	}
+	s.clearValues()
+	s.loadErr = err
+	if action == ErrAbort {
+		c.Abort(http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// LoadError returns the store error the session of the request was replaced
+// with an empty one for, see Config.OnError, or nil.
+func (s *Session) LoadError() error {
+	s.loadLazy()
+	return s.loadErr
+}
@@ -0,0 +1,83 @@
+package sessions
+
+import (
+	"github.com/go-floki/floki"
+	"github.com/gorilla/securecookie"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Timeouts of the sessions of the Strict middleware.
+const (
+	StrictIdleTimeout     = 30 * time.Minute
+	StrictAbsoluteTimeout = 12 * time.Hour
+)
+
+// Info strings binding the keys Strict derives from its secret to their
+// purpose.
+const (
+	strictCookieInfo  = "floki sessions strict cookie key"
+	strictRecordInfo  = "floki sessions strict record key"
+	strictSecretsInfo = "floki sessions strict secrets key"
+)
+
+// Strict returns a Sessions middleware with a hardened configuration, for
+// applications that don't need to tune it:
+//
+//   - the cookie name gets the __Host- prefix, and the cookie is Secure,
+//     HttpOnly, SameSite=Lax and scoped to the whole host;
+//   - session contents are encrypted with keys derived from secret, with
+//     GCMCodec for a CookieStore and EnableEncryption for the other built-in
+//     stores;
+//   - sessions expire after StrictIdleTimeout of inactivity and
+//     StrictAbsoluteTimeout in any case;
+//   - Session.SetSecret is enabled.
+//
+// As with every configuration, Session.SetAuthenticated must be called on
+// login so the session ID is rotated. Secret must hold at least 32 random
+// bytes. Strict sets the Options of the built-in stores, and panics if the
+// configuration can't be applied.
+func Strict(name string, store Store, secret []byte) floki.HandlerFunc {
+	if len(secret) < 32 {
+		panic("sessions: Strict needs a secret of at least 32 bytes")
+	}
+	if !strings.HasPrefix(name, hostPrefix) {
+		name = hostPrefix + name
+	}
+	options := &Options{
+		Path:     "/",
+		MaxAge:   int(StrictAbsoluteTimeout / time.Second),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	switch s := store.(type) {
+	case *CookieStore:
+		codec, err := NewGCMCodec(deriveKey(secret, strictCookieInfo))
+		if err != nil {
+			panic(err)
+		}
+		s.Codecs = []securecookie.Codec{codec.MaxAge(options.MaxAge)}
+		s.Options = options
+	case *FilesystemStore:
+		s.Options = options
+	case *RediStore:
+		s.Options = options
+	case *MemoryStore:
+		s.Options = options
+	}
+	if _, ok := store.(PayloadStore); ok {
+		if err := EnableEncryption(store, deriveKey(secret, strictRecordInfo)); err != nil {
+			panic(err)
+		}
+	}
+
+	return SessionsWithConfig(name, store, Config{
+		Options:         options,
+		IdleTimeout:     StrictIdleTimeout,
+		AbsoluteTimeout: StrictAbsoluteTimeout,
+		SecretKeys:      [][]byte{deriveKey(secret, strictSecretsInfo)},
+	})
+}
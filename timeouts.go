@@ -0,0 +1,203 @@
+package sessions
+
+import (
+	"encoding/json"
+	"github.com/go-floki/floki"
+	"math"
+	"time"
+)
+
+// createdAtKey is the session key of the creation time of the session, in
+// unix seconds.
+const createdAtKey = "_created"
+
+// renewedKey is the session key of the time the session was last renewed by
+// Config.RenewOnActivity, in unix seconds.
+const renewedKey = "_renewed"
+
+// lastSeenKey is the session key of the time of the last request made with
+// the session, in unix seconds.
+const lastSeenKey = "_seen"
+
+// idIssuedKey is the session key of the time the current session ID was
+// issued, in unix seconds, for Config.RotateIDEvery.
+const idIssuedKey = "_id_issued"
+
+// int64Value converts an integer stored in Values back to an int64. Codecs
+// decode integers differently: gob keeps the stored type, JSONCodec yields
+// json.Number and other codecs may yield floats or unsigned ints. Values
+// that are not integers, such as 3.7, or don't fit in an int64 are
+// rejected rather than truncated or wrapped.
+func int64Value(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case uint:
+		if uint64(v) > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	case json.Number:
+		n, err := v.Int64()
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// unixTime converts a time stored in Values as unix seconds back to a
+// time.Time.
+func unixTime(v interface{}) (time.Time, bool) {
+	sec, ok := int64Value(v)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(sec, 0), true
+}
+
+// checkAbsoluteTimeout invalidates s if it was created more than timeout
+// ago, and records the creation time of sessions that have none yet.
+func checkAbsoluteTimeout(c *floki.Context, s *Session, timeout time.Duration) {
+	now := time.Now()
+	if created, ok := unixTime(s.Get(createdAtKey)); ok {
+		if now.Sub(created) <= timeout {
+			return
+		}
+		audit(c, s, AuditExpired, "absolute timeout")
+		expireSession(s)
+		s.invalidate()
+		s.loadResult = LoadExpired
+	}
+	s.Set(createdAtKey, now.Unix())
+}
+
+// checkIdleTimeout invalidates s if no request was made with it for more
+// than timeout, then records the current request as its last activity.
+func checkIdleTimeout(c *floki.Context, s *Session, timeout time.Duration) {
+	now := time.Now()
+	seen, ok := unixTime(s.Get(lastSeenKey))
+	if ok && now.Sub(seen) > timeout {
+		audit(c, s, AuditExpired, "idle timeout")
+		expireSession(s)
+		s.invalidate()
+		s.loadResult = LoadExpired
+	}
+	touchLastSeen(s, now)
+}
+
+// checkIDRotation marks s for a new ID if its current one was issued more
+// than every ago. The time of issue is recorded on the first request made
+// with a session, and reset by RegenerateID.
+func checkIDRotation(s *Session, every time.Duration) {
+	issued, ok := unixTime(s.Get(idIssuedKey))
+	if !ok {
+		if !s.IsNew {
+			s.Set(idIssuedKey, time.Now().Unix())
+		}
+		return
+	}
+	if time.Since(issued) >= every {
+		s.rotate = true
+	}
+}
+
+// expiringKey is the context key set when the session of the request is
+// about to expire, see ExpiringSoon.
+const expiringKey = "_expiring"
+
+// sessionDeadline returns when s expires under the absolute and idle
+// timeouts of config if no other request is made with it, or the zero time
+// if it has no timeout.
+func sessionDeadline(s *Session, config *Config) time.Time {
+	var deadline time.Time
+	if config.IdleTimeout > 0 {
+		deadline = time.Now().Add(config.IdleTimeout)
+	}
+	if config.AbsoluteTimeout > 0 {
+		if created, ok := unixTime(s.Get(createdAtKey)); ok {
+			end := created.Add(config.AbsoluteTimeout)
+			if deadline.IsZero() || end.Before(deadline) {
+				deadline = end
+			}
+		}
+	}
+	return deadline
+}
+
+// checkExpiryWarning flags the request in c, and calls OnExpiryWarning,
+// when s expires within config.ExpiryWarning.
+func checkExpiryWarning(c *floki.Context, s *Session, config *Config) {
+	deadline := sessionDeadline(s, config)
+	if deadline.IsZero() {
+		return
+	}
+	if left := time.Until(deadline); left <= config.ExpiryWarning {
+		c.Set(expiringKey, deadline)
+		if config.OnExpiryWarning != nil {
+			config.OnExpiryWarning(c, s, left)
+		}
+	}
+}
+
+// ExpiringSoon reports whether the session of the request expires within
+// the Config.ExpiryWarning of the middleware, and when, so pages can warn
+// users and offer to extend their session before they lose work.
+func ExpiringSoon(c *floki.Context) (time.Time, bool) {
+	v, _ := c.Get(expiringKey)
+	deadline, ok := v.(time.Time)
+	return deadline, ok
+}
+
+// touchLastSeen records now as the time of the last request made with s.
+func touchLastSeen(s *Session, now time.Time) {
+	// The time is stored in seconds; don't save the session again for
+	// requests made within the same second.
+	if seen, ok := unixTime(s.Get(lastSeenKey)); !ok || seen.Unix() != now.Unix() {
+		s.Set(lastSeenKey, now.Unix())
+	}
+}
+
+// renewSession extends the lifetime of s, used by the current request, once
+// after fraction of its MaxAge has elapsed since its last renewal. Modified
+// sessions are renewed by being saved, so are left alone.
+func renewSession(c *floki.Context, s *Session, after float64) {
+	if s.IsNew || s.dirty || s.rotate || s.Options.MaxAge <= 0 {
+		return
+	}
+	if after <= 0 {
+		if err := s.Touch(c); err != nil {
+			c.Logger().Println("error renewing session:", err)
+		}
+		return
+	}
+	// The renewal time is kept in Values, so a renewal is a full save.
+	now := time.Now()
+	lifetime := time.Duration(s.Options.MaxAge) * time.Second
+	renewed, ok := unixTime(s.Get(renewedKey))
+	if !ok || now.Sub(renewed) >= time.Duration(after*float64(lifetime)) {
+		s.Set(renewedKey, now.Unix())
+	}
+}
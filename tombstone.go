@@ -0,0 +1,27 @@
+package sessions
+
+import (
+	"github.com/go-floki/floki"
+	"net/http"
+	"time"
+)
+
+// tombstones records destroyed sessions for a while, see Config.Tombstones.
+type tombstones struct {
+	list RevocationList
+	ttl  time.Duration
+}
+
+// buried reports whether s was destroyed less than the tombstone TTL ago.
+func (t *tombstones) buried(s *Session) (bool, error) {
+	id := s.RevocationID()
+	if id == "" {
+		return false, nil
+	}
+	return t.list.IsRevoked(id)
+}
+
+// rejectTombstone is the default Config.OnTombstone.
+func rejectTombstone(c *floki.Context, s *Session) {
+	c.Abort(http.StatusUnauthorized)
+}
@@ -0,0 +1,42 @@
+package sessions
+
+// Snapshot is a tentative set of changes to a session, started by Begin.
+type Snapshot struct {
+	session *Session
+	state   *sessionState
+}
+
+// Begin records the state of the session so the changes made afterwards
+// can be abandoned with Rollback, for handlers that change the session in
+// several steps any of which may fail:
+//
+//	tx := session.Begin()
+//	session.Set("cart", cart)
+//	if err := reserve(cart); err != nil {
+//		tx.Rollback()
+//		return
+//	}
+//	tx.Commit()
+//
+// Like Discard, values changed in place are not restored.
+func (s *Session) Begin() *Snapshot {
+	s.loadLazy()
+	return &Snapshot{session: s, state: s.state()}
+}
+
+// Commit keeps the changes made since Begin. The session is saved as usual
+// at the end of the request.
+func (t *Snapshot) Commit() {
+	t.state = nil
+}
+
+// Rollback restores the session as it was when Begin was called, including
+// whether it was modified. It does nothing once the snapshot is committed or
+// rolled back.
+func (t *Snapshot) Rollback() {
+	if t.state == nil {
+		return
+	}
+	t.session.restore(t.state)
+	t.state = nil
+}
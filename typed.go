@@ -0,0 +1,119 @@
+//go:build go1.18
+// +build go1.18
+
+package sessions
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-floki/floki"
+	"reflect"
+)
+
+// typedDataKey is the key under which TypedSession keeps its payload in
+// Session.Values.
+const typedDataKey = "_data"
+
+// TypedSession gives handlers typed access to a session whose payload is a
+// single user struct T, instead of casting values out of Session.Values:
+//
+//	var cart = sessions.NewTypedSession[Cart]()
+//
+//	func handler(c *floki.Context) {
+//		data, err := cart.Data(c)
+//		if err != nil {
+//			...
+//		}
+//		data.Items++
+//	}
+type TypedSession[T any] struct{}
+
+// NewTypedSession returns a TypedSession for T, registering *T with gob so
+// the payload can be stored with the default codec.
+func NewTypedSession[T any]() TypedSession[T] {
+	RegisterType(new(T))
+	return TypedSession[T]{}
+}
+
+// Data returns the payload of the current request's session, creating an
+// empty T if the session doesn't hold one yet. It returns the error of
+// Session.Load when the session can't be decoded, and an error when the
+// stored payload can't be converted to T; the payload is then left alone.
+//
+// The returned pointer may be mutated freely: the session is marked as
+// modified, and the payload saved, only if it differs at the end of the
+// request from what Data returned, so handlers that only read it don't
+// cause a save.
+func (TypedSession[T]) Data(c *floki.Context) (*T, error) {
+	s := Get(c)
+	if err := s.Load(); err != nil {
+		return nil, err
+	}
+	var p *T
+	switch v := s.Values[typedDataKey].(type) {
+	case *T:
+		if s.watched[typedDataKey] != nil {
+			return v, nil
+		}
+		p = v
+	case T:
+		p = &v
+	case nil:
+		p = new(T)
+	default:
+		// Codecs that don't know about T, such as JSONCodec, decode the
+		// payload into generic maps; convert them through JSON.
+		p = new(T)
+		b, err := json.Marshal(v)
+		if err == nil {
+			err = json.Unmarshal(b, p)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("sessions: can't convert the session payload to %T: %v", *p, err)
+		}
+	}
+	s.Values[typedDataKey] = p
+	snapshot := deepCopy(*p)
+	s.watch(typedDataKey, func() bool {
+		if reflect.DeepEqual(*p, snapshot) {
+			return false
+		}
+		snapshot = deepCopy(*p)
+		return true
+	})
+	return p, nil
+}
+
+// Value returns the value stored under key as a T. Values the codec of the
+// store decoded into another type, such as the json.Number and generic
+// maps of JSONCodec, are converted to T through JSON. It reports false
+// when the key is not set or its value can't be converted.
+func Value[T any](s *Session, key string) (T, bool) {
+	var zero T
+	switch v := s.Get(key).(type) {
+	case T:
+		return v, true
+	case nil:
+		return zero, false
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return zero, false
+		}
+		var t T
+		if json.Unmarshal(b, &t) != nil {
+			return zero, false
+		}
+		return t, true
+	}
+}
+
+// SetValue stores v under key. Unlike Set it only accepts values of the
+// type parameter, so a key is written and read with the same type when
+// both sides use a shared T:
+//
+//	sessions.SetValue[int64](s, "uid", uid)
+//	uid, ok := sessions.Value[int64](s, "uid")
+func SetValue[T any](s *Session, key string, v T) {
+	s.Set(key, v)
+}
@@ -0,0 +1,139 @@
+//go:build go1.18
+// +build go1.18
+
+package sessions
+
+import (
+	"github.com/go-floki/floki"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type typedPoint struct {
+	X, Y int
+}
+
+func Test_Value(t *testing.T) {
+	for _, codec := range []Codec{nil, JSONCodec{}} {
+		f := floki.Default()
+
+		RegisterType(typedPoint{})
+		store := NewMemoryStore([]byte("secret123"))
+		store.ValueCodec = codec
+		f.Use(SessionsWithConfig("my_session1", store, Config{StringKeys: true}))
+
+		f.GET("/testsession", func(c *floki.Context) {
+			session := Get(c)
+			SetValue[int64](session, "uid", 42)
+			SetValue(session, "point", typedPoint{1, 2})
+			SetValue(session, "name", "alice")
+			c.Send(200, "OK")
+		})
+
+		f.GET("/show", func(c *floki.Context) {
+			session := Get(c)
+			if uid, ok := Value[int64](session, "uid"); !ok || uid != 42 {
+				t.Errorf("Value returned %v, %v with %T", uid, ok, codec)
+			}
+			if p, ok := Value[typedPoint](session, "point"); !ok || p != (typedPoint{1, 2}) {
+				t.Errorf("Value returned %v, %v with %T", p, ok, codec)
+			}
+			if _, ok := Value[int](session, "name"); ok {
+				t.Errorf("Value converted a string to int with %T", codec)
+			}
+			if _, ok := Value[string](session, "missing"); ok {
+				t.Errorf("Value found a missing key with %T", codec)
+			}
+			c.Send(200, "OK")
+		})
+
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/testsession", nil)
+		f.ServeHTTP(res, req)
+
+		res2 := httptest.NewRecorder()
+		req2, _ := http.NewRequest("GET", "/show", nil)
+		req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+		f.ServeHTTP(res2, req2)
+	}
+}
+
+type typedCart struct {
+	Items []string
+}
+
+func Test_TypedSession(t *testing.T) {
+	f := floki.Default()
+
+	cart := NewTypedSession[typedCart]()
+	store := NewCookieStore([]byte("secret123"))
+	f.Use(Sessions("my_session1", store, nil))
+
+	f.GET("/add", func(c *floki.Context) {
+		data, err := cart.Data(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data.Items = append(data.Items, "book")
+		if again, _ := cart.Data(c); again != data {
+			t.Error("Data returned another payload")
+		}
+		c.Send(200, "OK")
+	})
+
+	var items []string
+	f.GET("/show", func(c *floki.Context) {
+		data, err := cart.Data(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items = data.Items
+		c.Send(200, "OK")
+	})
+
+	f.GET("/corrupt", func(c *floki.Context) {
+		Get(c).Set(typedDataKey, "not a cart")
+		c.Send(200, "OK")
+	})
+
+	var err error
+	f.GET("/broken", func(c *floki.Context) {
+		_, err = cart.Data(c)
+		c.Send(200, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/add", nil)
+	f.ServeHTTP(res, req)
+	cookie := res.Header().Get("Set-Cookie")
+	if cookie == "" {
+		t.Fatal("Changed payload was not saved")
+	}
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", cookie)
+	f.ServeHTTP(res2, req2)
+	if len(items) != 1 || items[0] != "book" {
+		t.Error("Payload was not saved:", items)
+	}
+	if res2.Header().Get("Set-Cookie") != "" {
+		t.Error("Reading the payload saved the session")
+	}
+
+	res3 := httptest.NewRecorder()
+	req3, _ := http.NewRequest("GET", "/corrupt", nil)
+	f.ServeHTTP(res3, req3)
+
+	res4 := httptest.NewRecorder()
+	req4, _ := http.NewRequest("GET", "/broken", nil)
+	req4.Header.Set("Cookie", res3.Header().Get("Set-Cookie"))
+	f.ServeHTTP(res4, req4)
+	if err == nil {
+		t.Error("Unconvertible payload was not reported")
+	}
+	if res4.Header().Get("Set-Cookie") != "" {
+		t.Error("Unconvertible payload was replaced")
+	}
+}
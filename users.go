@@ -0,0 +1,73 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNoUserIndex is returned for stores that don't implement UserIndexer.
+var ErrNoUserIndex = errors.New("sessions: store has no user index")
+
+// UserIndexer is implemented by stores maintaining an index of the sessions
+// of each user, as recorded with Session.SetAuthenticated, such as
+// RediStore and MemoryStore.
+type UserIndexer interface {
+	Deleter
+
+	// UserSessionIDs returns the IDs of the live sessions of userID.
+	UserSessionIDs(userID string) ([]string, error)
+}
+
+// UserDeleter is implemented by stores able to delete every session of a
+// user at once along with the index of the user, such as RediStore and
+// MemoryStore.
+type UserDeleter interface {
+	// DeleteByUserID deletes every session of userID and forgets the
+	// user. It stops early if ctx is done, returning its error.
+	DeleteByUserID(ctx context.Context, userID string) error
+}
+
+// sessionUserID returns the user recorded in session with SetAuthenticated.
+func sessionUserID(session *Session) string {
+	id, _ := session.Values[userIDKey].(string)
+	return id
+}
+
+// DestroyAllForUser deletes every session of userID from store, which must
+// implement UserIndexer, logging the user out on every device. Use it when
+// a password is reset or an account is compromised.
+//
+// It stops early if ctx is done, returning its error.
+func DestroyAllForUser(ctx context.Context, store Store, userID string) error {
+	index, ok := store.(UserIndexer)
+	if !ok {
+		return ErrNoUserIndex
+	}
+	ids, err := index.UserSessionIDs(userID)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := index.DeleteID(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteUserSessions deletes every session of userID from store, along
+// with the index of the user, so that no session data refers to the user
+// anymore. Call it when an account is removed. Stores implementing
+// UserIndexer but not UserDeleter only have the sessions deleted.
+//
+// Remember-me tokens of the user are not listed by RememberStore and are
+// left to expire; delete them from the token store if it can find them.
+func DeleteUserSessions(ctx context.Context, store Store, userID string) error {
+	if d, ok := store.(UserDeleter); ok {
+		return d.DeleteByUserID(ctx, userID)
+	}
+	return DestroyAllForUser(ctx, store, userID)
+}
@@ -0,0 +1,58 @@
+package sessions
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// saveWriter is the response writer of the requests handled by the
+// middleware. It calls before once, right before the headers of the
+// response are written, so the cookie of a modified session is still sent.
+type saveWriter struct {
+	http.ResponseWriter
+	before func()
+	called bool
+}
+
+// fire calls before the first time it's called.
+func (w *saveWriter) fire() {
+	if !w.called {
+		w.called = true
+		w.before()
+	}
+}
+
+func (w *saveWriter) WriteHeader(code int) {
+	w.fire()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *saveWriter) Write(b []byte) (int, error) {
+	w.fire()
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher when the wrapped writer does.
+func (w *saveWriter) Flush() {
+	w.fire()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker when the wrapped writer does.
+func (w *saveWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("sessions: the response writer can't be hijacked")
+	}
+	w.fire()
+	return h.Hijack()
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (w *saveWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}